@@ -0,0 +1,108 @@
+package watrix
+
+import (
+	"sort"
+)
+
+// makeAlphabet returns the sorted distinct values of vals.  It is never
+// nil, since a nil alphabet means the matrix is not compacted.
+func makeAlphabet(vals []uint64) []uint64 {
+	alphabet := append(make([]uint64, 0, len(vals)), vals...)
+	sort.Slice(alphabet, func(i, j int) bool { return alphabet[i] < alphabet[j] })
+	n := 0
+	for i, val := range alphabet {
+		if i == 0 || val != alphabet[n-1] {
+			alphabet[n] = val
+			n++
+		}
+	}
+	return alphabet[:n:n]
+}
+
+// alphabetCode returns the code of val and whether val is in the alphabet.
+func alphabetCode(alphabet []uint64, val uint64) (uint64, bool) {
+	code := alphabetLowerBound(alphabet, val)
+	return code, code < uint64(len(alphabet)) && alphabet[code] == val
+}
+
+// alphabetLowerBound returns the number of values in the alphabet that are less than val.
+func alphabetLowerBound(alphabet []uint64, val uint64) uint64 {
+	return uint64(sort.Search(len(alphabet), func(i int) bool { return alphabet[i] >= val }))
+}
+
+// alphabetUpperBound returns the number of values in the alphabet that are less than or equal to val.
+func alphabetUpperBound(alphabet []uint64, val uint64) uint64 {
+	return uint64(sort.Search(len(alphabet), func(i int) bool { return alphabet[i] > val }))
+}
+
+// Compacted reports whether the values are stored as codes of a sorted
+// dictionary (see WithCompactAlphabet).
+func (wm *WaveletMatrix) Compacted() bool {
+	return wm.alphabet != nil
+}
+
+// codeDim returns (max. of stored codes + 1), which determines blen.
+func (wm *WaveletMatrix) codeDim() uint64 {
+	if wm.alphabet != nil {
+		return uint64(len(wm.alphabet))
+	}
+	return wm.dim
+}
+
+func (wm *WaveletMatrix) decode(code uint64) uint64 {
+	if wm.alphabet != nil {
+		return wm.alphabet[code]
+	}
+	return code
+}
+
+// codeRange converts valueRange [Beg, End) of original values into the
+// range of codes.
+func (wm *WaveletMatrix) codeRange(valueRange Range) Range {
+	if wm.alphabet == nil {
+		return valueRange
+	}
+	return Range{alphabetLowerBound(wm.alphabet, valueRange.Beg), alphabetLowerBound(wm.alphabet, valueRange.End)}
+}
+
+// rangedRankLessThan returns the number of codes c (< code) in T[posRange).
+// Unlike rangedRankOp, code may be larger than any stored code.
+func (wm *WaveletMatrix) rangedRankLessThan(posRange Range, code uint64) uint64 {
+	if code >= wm.codeDim() {
+		return posRange.End - posRange.Beg
+	}
+	return wm.rangedRankOp(posRange, code, OpLessThan)
+}
+
+// rangedSelectCodeRange returns the position of the (rank+1)'th code in
+// T[posRange) that falls within codeRange, or posRange.End if there is no
+// such position.  It performs a binary search over the positions.
+func (wm *WaveletMatrix) rangedSelectCodeRange(posRange Range, rank uint64, codeRange Range) uint64 {
+	count := func(end uint64) uint64 {
+		r := Range{posRange.Beg, end}
+		return wm.rangedRankLessThan(r, codeRange.End) - wm.rangedRankLessThan(r, codeRange.Beg)
+	}
+	if count(posRange.End) <= rank {
+		return posRange.End
+	}
+	lo, hi := posRange.Beg, posRange.End // count(lo) <= rank < count(hi)
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if count(mid) <= rank {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// ignoreLSBsCodeRange returns the range of codes whose values match val
+// when ignoreBits-bit portion from LSB is not considered.
+func (wm *WaveletMatrix) ignoreLSBsCodeRange(val, ignoreBits uint64) Range {
+	mask := ^uint64(0)
+	if ignoreBits < 64 {
+		mask = (1 << ignoreBits) - 1
+	}
+	return Range{alphabetLowerBound(wm.alphabet, val&^mask), alphabetUpperBound(wm.alphabet, val|mask)}
+}
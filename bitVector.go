@@ -0,0 +1,212 @@
+package watrix
+
+import (
+	"encoding"
+	"fmt"
+
+	"github.com/hillbig/rsdic"
+)
+
+// BitVector is a rank/select dictionary over a bit vector B[0...Num()),
+// used to store each layer of WaveletMatrix.
+type BitVector interface {
+	// Num returns the number of bits
+	Num() uint64
+	// ZeroNum returns the number of zeros in bits
+	ZeroNum() uint64
+	// Bit returns B[pos]
+	Bit(pos uint64) bool
+	// Rank returns the number of bit in B[0...pos)
+	Rank(pos uint64, bit bool) uint64
+	// Select returns the position of (rank+1)-th bit in B.
+	// If there is no such bit, it returns Num().
+	Select(rank uint64, bit bool) uint64
+
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// BitVectorBuilder builds a BitVector from bits given by PushBack.
+type BitVectorBuilder interface {
+	// PushBack appends the bit to the end of B
+	PushBack(bit bool)
+	// Build returns the BitVector of the bits pushed so far.
+	Build() BitVector
+}
+
+// Backend identifies an implementation of BitVector.
+// The backend of a WaveletMatrix is recorded in its serialized form.
+type Backend uint16
+
+const (
+	// BackendRSDic stores layers in github.com/hillbig/rsdic, which is
+	// compressed.  It is the default.
+	BackendRSDic Backend = iota
+	// BackendDense stores layers uncompressed with a cumulative count per
+	// word.  It uses about twice the space of the raw bits.
+	BackendDense
+	// BackendPlain stores layers uncompressed with rank9 counters and
+	// sampled select hints.  It uses about 1.25 times the raw bits.
+	BackendPlain
+	// BackendInterleaved stores layers uncompressed with the counters and
+	// the bits of each 384-bit block in one aligned cache line.
+	// It uses about 1.33 times the raw bits.
+	BackendInterleaved
+)
+
+type backendInfo struct {
+	name         string
+	newBuilder   func() BitVectorBuilder
+	newBitVector func() BitVector
+	portable     bool // MarshalBinary writes the form of marshalWords
+}
+
+var backends = map[Backend]backendInfo{
+	BackendRSDic: {
+		name:         "rsdic",
+		newBuilder:   func() BitVectorBuilder { return &rsdicBuilder{rsdic.New()} },
+		newBitVector: func() BitVector { return rsdic.New() },
+	},
+	BackendDense: {
+		name:         "dense",
+		newBuilder:   func() BitVectorBuilder { return newDenseBitVector() },
+		newBitVector: func() BitVector { return newDenseBitVector() },
+		portable:     true,
+	},
+	BackendPlain: {
+		name:         "plain",
+		newBuilder:   func() BitVectorBuilder { return newPlainBitVector() },
+		newBitVector: func() BitVector { return newPlainBitVector() },
+		portable:     true,
+	},
+	BackendInterleaved: {
+		name:         "interleaved",
+		newBuilder:   func() BitVectorBuilder { return newInterleavedBitVector() },
+		newBitVector: func() BitVector { return newInterleavedBitVector() },
+		portable:     true,
+	},
+}
+
+// String returns the name of the backend.
+func (b Backend) String() string {
+	if info, ok := backends[b]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("Backend(%d)", uint16(b))
+}
+
+// ParseBackend returns the backend of the given name.
+func ParseBackend(name string) (Backend, error) {
+	for b, info := range backends {
+		if info.name == name {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("watrix: unknown backend %q", name)
+}
+
+func (b Backend) info() (backendInfo, error) {
+	info, ok := backends[b]
+	if !ok {
+		return backendInfo{}, fmt.Errorf("watrix: unknown backend %d", uint16(b))
+	}
+	return info, nil
+}
+
+// newBitVectorBuilder returns a builder of the backend, falling back to
+// BackendRSDic for an unknown backend.
+func (b Backend) newBitVectorBuilder() BitVectorBuilder {
+	info, err := b.info()
+	if err != nil {
+		info = backends[BackendRSDic]
+	}
+	return info.newBuilder()
+}
+
+type rsdicBuilder struct {
+	rsd *rsdic.RSDic
+}
+
+func (rb *rsdicBuilder) PushBack(bit bool) {
+	rb.rsd.PushBack(bit)
+}
+
+func (rb *rsdicBuilder) Build() BitVector {
+	return rb.rsd
+}
+
+// bitVectorWords returns the bits of bv packed into words from the LSB.
+func bitVectorWords(bv BitVector) []uint64 {
+	switch v := bv.(type) {
+	case *denseBitVector:
+		return v.words
+	case *plainBitVector:
+		return v.words
+	case *interleavedBitVector:
+		return v.rawWords()
+	}
+	words := make([]uint64, (bv.Num()+63)/64)
+	for pos := uint64(0); pos < bv.Num(); pos++ {
+		if bv.Bit(pos) {
+			words[pos/64] |= 1 << (pos % 64)
+		}
+	}
+	return words
+}
+
+// marshalPortable encodes bv in the form of marshalWords, which does not
+// depend on the backend.
+func marshalPortable(bv BitVector) ([]byte, error) {
+	switch bv.(type) {
+	case *denseBitVector, *plainBitVector, *interleavedBitVector:
+		return bv.MarshalBinary()
+	}
+	return marshalWords(bv.Num(), bitVectorWords(bv)), nil
+}
+
+// unmarshalPortable decodes a BitVector of the backend from a binary form
+// generated marshalPortable.
+func (info backendInfo) unmarshalPortable(in []byte) (BitVector, error) {
+	if info.portable {
+		bv := info.newBitVector()
+		if err := bv.UnmarshalBinary(in); err != nil {
+			return nil, err
+		}
+		return bv, nil
+	}
+	num, words, err := unmarshalWords(in)
+	if err != nil {
+		return nil, err
+	}
+	return info.fromWords(num, words), nil
+}
+
+// fromWords returns a BitVector of the backend holding the num bits of
+// words packed from the LSB.
+func (info backendInfo) fromWords(num uint64, words []uint64) BitVector {
+	if info.portable {
+		bv := info.newBitVector()
+		if err := bv.UnmarshalBinary(marshalWords(num, words)); err == nil {
+			return bv
+		}
+	}
+	builder := info.newBuilder()
+	for pos := uint64(0); pos < num; pos++ {
+		builder.PushBack((words[pos/64]>>(pos%64))&1 == 1)
+	}
+	return builder.Build()
+}
+
+// unmarshalLayer decodes a layer of the backend, which is in the form of
+// marshalPortable if portable, and of the MarshalBinary of the backend
+// otherwise.
+func (info backendInfo) unmarshalLayer(in []byte, portable bool) (BitVector, error) {
+	if portable {
+		return info.unmarshalPortable(in)
+	}
+	bv := info.newBitVector()
+	if err := bv.UnmarshalBinary(in); err != nil {
+		return nil, err
+	}
+	return bv, nil
+}
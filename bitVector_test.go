@@ -0,0 +1,78 @@
+package watrix
+
+import (
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testBitVectorHelper(bv BitVector, orig []bool) {
+	num := uint64(len(orig))
+	So(bv.Num(), ShouldEqual, num)
+	// Compare whole slices; an So per bit is too slow.
+	ones := uint64(0)
+	wantRanks, gotRanks := make([]uint64, 0, num+1), make([]uint64, 0, num+1)
+	wantZeroRanks, gotZeroRanks := make([]uint64, 0, num), make([]uint64, 0, num)
+	wantSelects, gotSelects := make([]uint64, 0, num), make([]uint64, 0, num)
+	gotBits := make([]bool, 0, num)
+	for i, bit := range orig {
+		wantRanks = append(wantRanks, ones)
+		gotRanks = append(gotRanks, bv.Rank(uint64(i), true))
+		wantZeroRanks = append(wantZeroRanks, uint64(i)-ones)
+		gotZeroRanks = append(gotZeroRanks, bv.Rank(uint64(i), false))
+		gotBits = append(gotBits, bv.Bit(uint64(i)))
+		if bit {
+			ones++
+		}
+	}
+	wantRanks = append(wantRanks, ones)
+	gotRanks = append(gotRanks, bv.Rank(num, true))
+	So(gotRanks, ShouldResemble, wantRanks)
+	So(gotZeroRanks, ShouldResemble, wantZeroRanks)
+	So(gotBits, ShouldResemble, orig)
+	So(bv.ZeroNum(), ShouldEqual, num-ones)
+
+	oneRank, zeroRank := uint64(0), uint64(0)
+	for i, bit := range orig {
+		wantSelects = append(wantSelects, uint64(i))
+		if bit {
+			gotSelects = append(gotSelects, bv.Select(oneRank, true))
+			oneRank++
+		} else {
+			gotSelects = append(gotSelects, bv.Select(zeroRank, false))
+			zeroRank++
+		}
+	}
+	So(gotSelects, ShouldResemble, wantSelects)
+	So(bv.Select(ones, true), ShouldEqual, num) // equals num: Not Found
+	So(bv.Select(num-ones, false), ShouldEqual, num)
+}
+
+func TestBitVector(t *testing.T) {
+	for backend, info := range backends {
+		Convey("When bits are pushed into "+backend.String(), t, func() {
+			for _, num := range []int{0, 1, 63, 64, 511, 512, 513, 9000} {
+				for _, density := range []float64{0, 0.05, 0.5, 1} {
+					orig := make([]bool, num)
+					builder := info.newBuilder()
+					for i := range orig {
+						orig[i] = rand.Float64() < density
+						builder.PushBack(orig[i])
+					}
+					bv := builder.Build()
+					testBitVectorHelper(bv, orig)
+					if ibv, ok := bv.(*interleavedBitVector); ok {
+						So(ibv.validateBlocks(), ShouldBeNil)
+					}
+
+					out, err := bv.MarshalBinary()
+					So(err, ShouldBeNil)
+					loaded := info.newBitVector()
+					So(loaded.UnmarshalBinary(out), ShouldBeNil)
+					testBitVectorHelper(loaded, orig)
+				}
+			}
+		})
+	}
+}
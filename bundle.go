@@ -0,0 +1,329 @@
+package watrix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// The bundle form holds named matrices and key/value metadata in one file:
+//
+//	magic    [8]byte  bundleMagic
+//	version  uint32   bundleVersion
+//	members  each matrix in the container form, one after another
+//	toc      section  the members as name, offset and length, followed by
+//	                  the metadata as key and value
+//	trailer  uint64   the offset of the toc, followed by bundleTrailerMagic
+//
+// where the counts and the offsets are uint64s, a string is its length as
+// uint32 followed by its bytes, and a section is that of the container
+// form.  The offsets are from the magic, and the members follow the order
+// they were added in.  See FORMAT.md for the details.
+
+var (
+	bundleMagic        = [8]byte{0x89, 'W', 'M', 'B', '\r', '\n', 0x1a, '\n'}
+	bundleTrailerMagic = [8]byte{'W', 'M', 'B', 'U', 'N', 'D', 'L', 'E'}
+)
+
+const bundleVersion = 1
+
+// BundleWriter writes matrices and metadata in the bundle form.
+// A user calls Add()s and SetMetadata()s followed by Close().
+type BundleWriter struct {
+	w        *countingWriter
+	members  []bundleMember
+	names    map[string]bool
+	metadata map[string]string
+	err      error
+}
+
+type bundleMember struct {
+	name   string
+	offset int64
+	length int64
+}
+
+// NewBundleWriter returns BundleWriter writing to w.
+func NewBundleWriter(w io.Writer) *BundleWriter {
+	bw := &BundleWriter{
+		w:        &countingWriter{w: w},
+		names:    make(map[string]bool),
+		metadata: make(map[string]string),
+	}
+	preamble := make([]byte, formatPreambleLen)
+	copy(preamble, bundleMagic[:])
+	binary.LittleEndian.PutUint32(preamble[len(bundleMagic):], bundleVersion)
+	_, bw.err = bw.w.Write(preamble)
+	return bw
+}
+
+// Add writes wm as the member name with the options of Encode.
+func (bw *BundleWriter) Add(name string, wm *WaveletMatrix, opts ...EncodeOption) error {
+	if bw.err != nil {
+		return bw.err
+	}
+	if name == "" {
+		return errors.New("watrix: a bundle member needs a name")
+	}
+	if bw.names[name] {
+		return fmt.Errorf("watrix: duplicate bundle member %q", name)
+	}
+	offset := bw.w.n
+	if _, bw.err = wm.Encode(bw.w, opts...); bw.err != nil {
+		return bw.err
+	}
+	bw.names[name] = true
+	bw.members = append(bw.members, bundleMember{name, offset, bw.w.n - offset})
+	return nil
+}
+
+// SetMetadata sets the metadata value of key, replacing any previous one.
+func (bw *BundleWriter) SetMetadata(key, value string) {
+	bw.metadata[key] = value
+}
+
+// Close writes the table of contents.  It does not close the underlying
+// writer.
+func (bw *BundleWriter) Close() error {
+	if bw.err != nil {
+		return bw.err
+	}
+	toc := binary.LittleEndian.AppendUint64(nil, uint64(len(bw.members)))
+	for _, member := range bw.members {
+		toc = appendString(toc, member.name)
+		toc = binary.LittleEndian.AppendUint64(toc, uint64(member.offset))
+		toc = binary.LittleEndian.AppendUint64(toc, uint64(member.length))
+	}
+	keys := make([]string, 0, len(bw.metadata))
+	for key := range bw.metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	toc = binary.LittleEndian.AppendUint64(toc, uint64(len(keys)))
+	for _, key := range keys {
+		toc = appendString(toc, key)
+		toc = appendString(toc, bw.metadata[key])
+	}
+
+	trailer := make([]byte, formatTrailerLen)
+	binary.LittleEndian.PutUint64(trailer, uint64(bw.w.n))
+	copy(trailer[8:], bundleTrailerMagic[:])
+	if bw.err = writeSection(bw.w, toc); bw.err != nil {
+		return bw.err
+	}
+	if _, bw.err = bw.w.Write(trailer); bw.err != nil {
+		return bw.err
+	}
+	bw.err = errors.New("watrix: the bundle is closed")
+	return nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// Bundle is a bundle read by OpenBundle.  Only its table of contents is
+// read up front; each member is read when it is loaded.
+type Bundle struct {
+	r        io.ReaderAt
+	members  []bundleMember
+	index    map[string]int
+	metadata map[string]string
+}
+
+// OpenBundle reads the table of contents of the bundle in r, which holds
+// size bytes written by BundleWriter.  r must stay readable while the
+// bundle is used.
+func OpenBundle(r io.ReaderAt, size int64) (*Bundle, error) {
+	preamble := make([]byte, formatPreambleLen)
+	if _, err := r.ReadAt(preamble, 0); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrBadMagic
+		}
+		return nil, err
+	}
+	if !bytes.Equal(preamble[:len(bundleMagic)], bundleMagic[:]) {
+		return nil, ErrBadMagic
+	}
+	if version := binary.LittleEndian.Uint32(preamble[len(bundleMagic):]); version != bundleVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	if size < formatPreambleLen+formatTrailerLen {
+		return nil, io.ErrUnexpectedEOF
+	}
+	trailer := make([]byte, formatTrailerLen)
+	if _, err := r.ReadAt(trailer, size-formatTrailerLen); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if !bytes.Equal(trailer[8:], bundleTrailerMagic[:]) {
+		return nil, ErrBadMagic
+	}
+	tocOffset := int64(binary.LittleEndian.Uint64(trailer))
+	payload, err := readSectionAt(r, tocOffset, size)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{
+		r:        r,
+		index:    make(map[string]int),
+		metadata: make(map[string]string),
+	}
+	toc := payloadReader{buf: payload, what: "the bundle table of contents"}
+	for n := toc.uint64(); n > 0 && toc.err == nil; n-- {
+		member := bundleMember{
+			name:   toc.string(),
+			offset: int64(toc.uint64()),
+			length: int64(toc.uint64()),
+		}
+		if toc.err != nil {
+			break
+		}
+		if member.offset < formatPreambleLen || member.length < 0 || member.length > tocOffset-member.offset {
+			return nil, corruptf("bundle member %q is out of the members", member.name)
+		}
+		if _, ok := b.index[member.name]; ok {
+			return nil, corruptf("duplicate bundle member %q", member.name)
+		}
+		b.index[member.name] = len(b.members)
+		b.members = append(b.members, member)
+	}
+	for n := toc.uint64(); n > 0 && toc.err == nil; n-- {
+		key, value := toc.string(), toc.string()
+		if toc.err == nil {
+			b.metadata[key] = value
+		}
+	}
+	if toc.err != nil {
+		return nil, toc.err
+	}
+	return b, nil
+}
+
+// payloadReader decodes the payload of a section, such as the table of
+// contents of a bundle, and keeps the first error.
+type payloadReader struct {
+	buf  []byte
+	what string // the payload, for the error
+	err  error
+}
+
+func (tr *payloadReader) next(n uint64) []byte {
+	if tr.err != nil {
+		return nil
+	}
+	if n > uint64(len(tr.buf)) {
+		tr.err = corruptf("%s is truncated", tr.what)
+		return nil
+	}
+	ret := tr.buf[:n]
+	tr.buf = tr.buf[n:]
+	return ret
+}
+
+func (tr *payloadReader) uint64() uint64 {
+	if b := tr.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (tr *payloadReader) string() string {
+	b := tr.next(4)
+	if b == nil {
+		return ""
+	}
+	return string(tr.next(uint64(binary.LittleEndian.Uint32(b))))
+}
+
+// Names returns the names of the members in the order they were added.
+func (b *Bundle) Names() []string {
+	names := make([]string, len(b.members))
+	for i, member := range b.members {
+		names[i] = member.name
+	}
+	return names
+}
+
+// Metadata returns a copy of the metadata.
+func (b *Bundle) Metadata() map[string]string {
+	metadata := make(map[string]string, len(b.metadata))
+	for key, value := range b.metadata {
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// Member returns the reader of the member name, which holds the member in
+// the container form.
+func (b *Bundle) Member(name string) (*io.SectionReader, error) {
+	i, ok := b.index[name]
+	if !ok {
+		return nil, fmt.Errorf("watrix: no bundle member %q", name)
+	}
+	member := b.members[i]
+	return io.NewSectionReader(b.r, member.offset, member.length), nil
+}
+
+// Load reads the member name like ReadFrom, without reading the others.
+func (b *Bundle) Load(name string) (*WaveletMatrix, error) {
+	sr, err := b.Member(name)
+	if err != nil {
+		return nil, err
+	}
+	wm := new(WaveletMatrix)
+	if _, err := wm.ReadFrom(sr); err != nil {
+		return nil, fmt.Errorf("watrix: bundle member %q: %w", name, err)
+	}
+	return wm, nil
+}
+
+// OpenLazy opens the member name like OpenLazy.
+func (b *Bundle) OpenLazy(name string) (*LazyMatrix, error) {
+	sr, err := b.Member(name)
+	if err != nil {
+		return nil, err
+	}
+	lm, err := OpenLazy(sr, sr.Size())
+	if err != nil {
+		return nil, fmt.Errorf("watrix: bundle member %q: %w", name, err)
+	}
+	return lm, nil
+}
+
+// BundleFile is Bundle read from a file.
+type BundleFile struct {
+	*Bundle
+	f *os.File
+}
+
+// OpenBundleFile opens the bundle file at inpath.  The matrices loaded by
+// Load stay usable after Close, while those opened by OpenLazy do not.
+func OpenBundleFile(inpath string) (*BundleFile, error) {
+	f, err := os.Open(inpath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	b, err := OpenBundle(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &BundleFile{Bundle: b, f: f}, nil
+}
+
+// Close closes the file.
+func (bf *BundleFile) Close() error {
+	return bf.f.Close()
+}
@@ -0,0 +1,98 @@
+package watrix
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBundle(t *testing.T) {
+	Convey("When matrices are bundled", t, func() {
+		wmb := NewBuilder()
+		for i := uint64(0); i < 3000; i++ {
+			wmb.PushBack(i * 7 % 100 << 30)
+		}
+		members := map[string]*WaveletMatrix{
+			"plain":     buildFromSlice([]uint64{3, 1, 4, 1, 5, 9, 2, 6}),
+			"compacted": wmb.Build(WithCompactAlphabet()),
+			"empty":     NewBuilder().Build(),
+		}
+		names := []string{"plain", "compacted", "empty"}
+
+		var buf bytes.Buffer
+		bw := NewBundleWriter(&buf)
+		for _, name := range names {
+			So(bw.Add(name, members[name], WithCompression(flate.BestSpeed)), ShouldBeNil)
+		}
+		So(bw.Add("plain", members["plain"]), ShouldNotBeNil)
+		So(bw.Add("", members["plain"]), ShouldNotBeNil)
+		bw.SetMetadata("source", "test")
+		bw.SetMetadata("rows", "3000")
+		So(bw.Close(), ShouldBeNil)
+		So(bw.Add("late", members["plain"]), ShouldNotBeNil)
+
+		in := buf.Bytes()
+		cr := &countingReaderAt{r: bytes.NewReader(in), reads: make(map[int64]int)}
+		b, err := OpenBundle(cr, int64(len(in)))
+		So(err, ShouldBeNil)
+		So(b.Names(), ShouldResemble, names)
+		So(b.Metadata(), ShouldResemble, map[string]string{"source": "test", "rows": "3000"})
+
+		Convey("A member should be loaded without reading the others", func() {
+			cr.reads = make(map[int64]int)
+			wm, err := b.Load("compacted")
+			So(err, ShouldBeNil)
+			So(wm, shouldEqualMatrix, members["compacted"])
+
+			member := b.members[b.index["compacted"]]
+			for off := range cr.reads {
+				So(off, ShouldBeBetweenOrEqual, member.offset, member.offset+member.length)
+			}
+		})
+		Convey("A member should be opened lazily", func() {
+			for _, name := range names {
+				lazy, err := b.OpenLazy(name)
+				So(err, ShouldBeNil)
+				wm, err := lazy.Load()
+				So(err, ShouldBeNil)
+				So(wm, shouldEqualMatrix, members[name])
+			}
+		})
+		Convey("A missing member should be an error", func() {
+			_, err := b.Load("missing")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("A bundle file should be opened", func() {
+			path := t.TempDir() + "/test.wmb"
+			f, err := os.Create(path)
+			So(err, ShouldBeNil)
+			w := bufio.NewWriter(f)
+			bw := NewBundleWriter(w)
+			So(bw.Add("plain", members["plain"]), ShouldBeNil)
+			So(bw.Close(), ShouldBeNil)
+			So(w.Flush(), ShouldBeNil)
+			So(f.Close(), ShouldBeNil)
+
+			bf, err := OpenBundleFile(path)
+			So(err, ShouldBeNil)
+			wm, err := bf.Load("plain")
+			So(err, ShouldBeNil)
+			So(bf.Close(), ShouldBeNil)
+			So(wm, shouldEqualMatrix, members["plain"])
+		})
+		Convey("A damaged bundle should be rejected", func() {
+			_, err := OpenBundle(bytes.NewReader(in[:len(in)-1]), int64(len(in)-1))
+			So(err, ShouldNotBeNil)
+			damaged := append([]byte(nil), in...)
+			damaged[len(in)-20] ^= 1
+			_, err = OpenBundle(bytes.NewReader(damaged), int64(len(damaged)))
+			So(err, ShouldEqual, ErrChecksum)
+			_, err = OpenBundle(bytes.NewReader(in[:5]), 5)
+			So(err, ShouldEqual, ErrBadMagic)
+		})
+	})
+}
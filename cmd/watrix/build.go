@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	watrix "github.com/AlexWan0/go-watrix"
+)
+
+func runBuild(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: watrix build [flags] -o out.wm input")
+		fmt.Fprintln(stderr, "Builds a matrix from the values in input, or stdin if it is -.")
+		fs.PrintDefaults()
+	}
+	output := fs.String("o", "", "write the matrix to `file`")
+	format := fs.String("format", "text", "input `format`: text (whitespace separated), binary (little-endian) or csv")
+	column := fs.String("column", "0", "CSV `column`, by index from 0 or by name in the header")
+	header := fs.Bool("header", false, "the CSV input starts with a header")
+	bits := fs.Int("bits", 64, "width of the values in `bits`, which is the record size of binary input")
+	backendName := fs.String("backend", watrix.BackendRSDic.String(), "bit vector `backend`: rsdic, dense, plain or interleaved")
+	compact := fs.Bool("compact", false, "compact the alphabet, for sparse values")
+	compress := fs.Bool("compress", false, "compress the layers")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *output == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	if *bits < 1 || *bits > 64 {
+		return fmt.Errorf("-bits %d is out of 1 to 64", *bits)
+	}
+	backend, err := watrix.ParseBackend(*backendName)
+	if err != nil {
+		return err
+	}
+
+	in := stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	wmb := watrix.NewBuilder()
+	r := bufio.NewReader(in)
+	switch *format {
+	case "text":
+		err = readText(r, *bits, wmb.PushBack)
+	case "binary":
+		err = readBinary(r, *bits, wmb.PushBack)
+	case "csv":
+		err = readCSV(r, *column, *header, *bits, wmb.PushBack)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	opts := []watrix.BuildOption{watrix.WithBackend(backend)}
+	if *compact {
+		opts = append(opts, watrix.WithCompactAlphabet())
+	}
+	wm := wmb.Build(opts...)
+	var encodeOpts []watrix.EncodeOption
+	if *compress {
+		encodeOpts = append(encodeOpts, watrix.WithCompression(flate.DefaultCompression))
+	}
+	if err := wm.MarshalBinaryFile(*output, encodeOpts...); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "wrote %d values of dim %d to %s\n", wm.Num(), wm.Dim(), *output)
+	return nil
+}
+
+// readText pushes the whitespace separated decimal values in r.
+func readText(r io.Reader, bits int, push func(uint64)) error {
+	sc := bufio.NewScanner(r)
+	sc.Split(bufio.ScanWords)
+	for i := 0; sc.Scan(); i++ {
+		val, err := strconv.ParseUint(sc.Text(), 10, bits)
+		if err != nil {
+			return fmt.Errorf("value %d: %w", i, err)
+		}
+		push(val)
+	}
+	return sc.Err()
+}
+
+// readBinary pushes the little-endian values of bits in r, which must be
+// 8, 16, 32 or 64.
+func readBinary(r io.Reader, bits int, push func(uint64)) error {
+	if bits%8 != 0 || bits&(bits-1) != 0 {
+		return fmt.Errorf("binary input needs -bits of 8, 16, 32 or 64, not %d", bits)
+	}
+	record := make([]byte, 8)
+	for i := 0; ; i++ {
+		if _, err := io.ReadFull(r, record[:bits/8]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return fmt.Errorf("value %d is truncated", i)
+			}
+			return err
+		}
+		push(binary.LittleEndian.Uint64(record))
+	}
+}
+
+// readCSV pushes the decimal values in column of r, which is the index of
+// the column or its name in the header.
+func readCSV(r io.Reader, column string, header bool, bits int, push func(uint64)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	index, err := strconv.Atoi(column)
+	if header {
+		names, rerr := cr.Read()
+		if rerr != nil {
+			return rerr
+		}
+		for i, name := range names {
+			if name == column {
+				index, err = i, nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("no column %q in the header", column)
+		}
+	} else if err != nil {
+		return fmt.Errorf("column %q needs -header", column)
+	}
+	if index < 0 {
+		return errors.New("column is negative")
+	}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		if index >= len(record) {
+			return fmt.Errorf("line %d has no column %s", line, column)
+		}
+		val, err := strconv.ParseUint(record[index], 10, bits)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		push(val)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	watrix "github.com/AlexWan0/go-watrix"
+)
+
+// matrixInfo is the output of info.
+type matrixInfo struct {
+	File         string  `json:"file"`
+	Format       string  `json:"format"`
+	Backend      string  `json:"backend"`
+	Num          uint64  `json:"num"`
+	Dim          uint64  `json:"dim"`
+	Blen         uint64  `json:"blen"`
+	Compacted    bool    `json:"compacted"`
+	Compressed   bool    `json:"compressed"`
+	Size         int64   `json:"size"`
+	LayerSizes   []int64 `json:"layer_sizes"`
+	AlphabetSize int64   `json:"alphabet_size"`
+}
+
+func runInfo(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: watrix info [-json] file.wm")
+		fmt.Fprintln(stderr, "Prints the header of a matrix and the bytes of each layer from the top.")
+		fs.PrintDefaults()
+	}
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	info, err := inspectFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "file\t%s\n", info.File)
+	fmt.Fprintf(tw, "format\t%s\n", info.Format)
+	fmt.Fprintf(tw, "backend\t%s\n", info.Backend)
+	fmt.Fprintf(tw, "num\t%d\n", info.Num)
+	fmt.Fprintf(tw, "dim\t%d\n", info.Dim)
+	fmt.Fprintf(tw, "blen\t%d\n", info.Blen)
+	fmt.Fprintf(tw, "compacted\t%t\n", info.Compacted)
+	fmt.Fprintf(tw, "compressed\t%t\n", info.Compressed)
+	fmt.Fprintf(tw, "size\t%d bytes\n", info.Size)
+	for depth, size := range info.LayerSizes {
+		fmt.Fprintf(tw, "layer %d\t%d bytes\n", depth, size)
+	}
+	if info.Compacted {
+		fmt.Fprintf(tw, "alphabet\t%d bytes\n", info.AlphabetSize)
+	}
+	return tw.Flush()
+}
+
+// inspectFile returns the info of the matrix at path.  A matrix in a form
+// without the index is loaded, and the layer sizes are those it would
+// have in the current container form.
+func inspectFile(path string) (*matrixInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	format := ""
+	ci, err := watrix.Inspect(f, fi.Size())
+	if errors.Is(err, watrix.ErrBadMagic) {
+		// Not a container; it may be in the legacy form.
+		wm := new(watrix.WaveletMatrix)
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := wm.ReadFrom(bufio.NewReader(f)); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if _, err := wm.WriteTo(&buf); err != nil {
+			return nil, err
+		}
+		if ci, err = watrix.Inspect(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+			return nil, err
+		}
+		format = "older form, sizes as rewritten"
+	} else if err != nil {
+		return nil, err
+	} else {
+		format = fmt.Sprintf("container v%d", ci.Version)
+	}
+	return &matrixInfo{
+		File:         path,
+		Format:       format,
+		Backend:      ci.Backend.String(),
+		Num:          ci.Num,
+		Dim:          ci.Dim,
+		Blen:         ci.Blen,
+		Compacted:    ci.Compacted,
+		Compressed:   ci.Compressed,
+		Size:         fi.Size(),
+		LayerSizes:   ci.LayerSizes,
+		AlphabetSize: ci.AlphabetSize,
+	}, nil
+}
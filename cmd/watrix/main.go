@@ -0,0 +1,91 @@
+// Command watrix builds, inspects and queries serialized wavelet matrices.
+//
+// Usage:
+//
+//	watrix build [flags] -o out.wm input    build a matrix from values
+//	watrix info [-json] file.wm             print the header and layer sizes
+//	watrix query [-json] file.wm op args... run a query
+//	watrix shell [flags] file.wm            run queries interactively
+//
+// Run a subcommand with -h for its flags, and see query -h for the queries.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// command is a subcommand run with its arguments.
+type command func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+
+var commands = map[string]command{
+	"build": runBuild,
+	"info":  runInfo,
+	"query": runQuery,
+	"shell": runShell,
+}
+
+// errUsage is returned after the usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if err == errUsage {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "watrix:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		usage(stderr)
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		if args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+			usage(stdout)
+			return nil
+		}
+		fmt.Fprintf(stderr, "watrix: unknown command %q\n", args[0])
+		usage(stderr)
+		return errUsage
+	}
+	err := cmd(args[1:], stdin, stdout, stderr)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	return err
+}
+
+// parseFlags parses args with fs.  It returns errUsage if they are invalid,
+// as fs has printed the error and the usage.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "usage: watrix <command> [flags] [args]")
+	fmt.Fprintln(w, "commands:")
+	for _, name := range names {
+		fmt.Fprintln(w, "  "+name)
+	}
+}
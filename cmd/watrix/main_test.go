@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	watrix "github.com/AlexWan0/go-watrix"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// runCommand runs watrix with args and returns the output.
+func runCommand(stdin string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	Convey("When a matrix is built from text", t, func() {
+		dir := t.TempDir()
+		vals := make([]uint64, 1000)
+		var text strings.Builder
+		wmb := watrix.NewBuilder()
+		for i := range vals {
+			vals[i] = uint64(i * 7 % 100)
+			text.WriteString(strconv.FormatUint(vals[i], 10) + "\n")
+			wmb.PushBack(vals[i])
+		}
+		want := wmb.Build()
+		path := filepath.Join(dir, "text.wm")
+		out, err := runCommand(text.String(), "build", "-o", path, "-backend", "plain", "-compress", "-")
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, "1000 values")
+
+		Convey("info should print the header and the layers", func() {
+			out, err := runCommand("", "info", path)
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "container v1")
+			So(out, ShouldContainSubstring, "plain")
+			So(out, ShouldContainSubstring, "layer 6")
+
+			out, err = runCommand("", "info", "-json", path)
+			So(err, ShouldBeNil)
+			var info matrixInfo
+			So(json.Unmarshal([]byte(out), &info), ShouldBeNil)
+			So(info.Num, ShouldEqual, 1000)
+			So(info.Dim, ShouldEqual, 100)
+			So(info.Compressed, ShouldBeTrue)
+			So(len(info.LayerSizes), ShouldEqual, 7)
+
+			out, err = runCommand("", "info", "../../testdata/legacy.bin")
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "older form")
+
+			// A container with a broken index is reported, not read as
+			// the older form.
+			in, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			in[len(in)-16]++
+			broken := filepath.Join(t.TempDir(), "broken.wm")
+			So(os.WriteFile(broken, in, 0644), ShouldBeNil)
+			_, err = runCommand("", "info", broken)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("query should answer like the library", func() {
+			cases := []struct {
+				args []string
+				want string
+			}{
+				{[]string{"lookup", "10"}, strconv.FormatUint(want.Lookup(10), 10)},
+				{[]string{"rank", "$", "42"}, strconv.FormatUint(want.Rank(1000, 42), 10)},
+				{[]string{"rank", "100", "$", "42"}, strconv.FormatUint(want.Rank(1000, 42)-want.Rank(100, 42), 10)},
+				{[]string{"select", "3", "42"}, strconv.FormatUint(want.Select(3, 42), 10)},
+				{[]string{"quantile", "100", "500", "7"}, strconv.FormatUint(want.Quantile(watrix.Range{Beg: 100, End: 500}, 7), 10)},
+				{[]string{"quantile", "0", "$", "50%"}, strconv.FormatUint(want.Quantile(watrix.Range{Beg: 0, End: 1000}, 499), 10)},
+				{[]string{"rangedrank", "0", "500", "10", "20"}, strconv.FormatUint(want.RangedRankRange(watrix.Range{Beg: 0, End: 500}, watrix.Range{Beg: 10, End: 20}), 10)},
+				{[]string{"intersect", "2", "0", "3", "100", "103"}, "0\n7\n14"},
+				{[]string{"topk", "0", "$", "1"}, "0\t10"},
+				{[]string{"hist", "0", "$", "3"}, "[0, 34)\t340\n[34, 68)\t340\n[68, 100)\t320"},
+			}
+			for _, c := range cases {
+				out, err := runCommand("", append([]string{"query", path}, c.args...)...)
+				So(err, ShouldBeNil)
+				So(strings.TrimSpace(out), ShouldEqual, c.want)
+			}
+
+			out, err := runCommand("", "query", path, "hist", "0", "10", "1000")
+			So(err, ShouldBeNil)
+			So(strings.Count(out, "\n"), ShouldEqual, 100)
+
+			out, err = runCommand("", "query", "-json", path, "topk", "0", "100", "2")
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, `{"op":"topk","result":[{"value":0,"count":1},{"value":1,"count":1}]}`+"\n")
+		})
+		Convey("invalid queries should be errors", func() {
+			for _, args := range [][]string{
+				{"lookup", "1000"},
+				{"rank", "1001", "1"},
+				{"rank", "1"},
+				{"rank", "5", "1", "1"},
+				{"rank", "0", "1", "1", "1"},
+				{"hist", "0", "$", "0"},
+				{"quantile", "5", "5", "0"},
+				{"quantile", "0", "10", "10"},
+				{"quantile", "0", "10", "101%"},
+				{"rangedrank", "0", "10", "5", "1"},
+				{"intersect", "0", "0", "10"},
+				{"intersect", "1", "0"},
+				{"topk", "10", "5", "1"},
+				{"missing"},
+			} {
+				_, err := runCommand("", append([]string{"query", path}, args...)...)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+
+	Convey("When a shell runs on a matrix", t, func() {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "shell.wm")
+		wmb := watrix.NewBuilder()
+		for i := uint64(0); i < 1000; i++ {
+			wmb.PushBack(i * 7 % 100)
+		}
+		want := wmb.Build()
+		So(want.MarshalBinaryFile(path), ShouldBeNil)
+
+		out, err := runCommand(`rank 0 1000 42
+mid = quantile 500 900 50%
+select 3 $2
+hist 0 $ 8
+lookup $mid
+!!
+!1
+history
+vars
+select 1 $4
+rank $missing 1
+lookup 1000
+exit
+lookup 0
+`, "shell", "-time=false", path)
+		So(err, ShouldBeNil)
+		mid := want.Quantile(watrix.Range{Beg: 500, End: 900}, 199)
+		So(out, ShouldStartWith, fmt.Sprintf(`$1 = %d
+$mid = %d
+$3 = %d
+$4 =
+`+"[0, 13)\t130\n", want.Rank(1000, 42), mid, want.Select(3, mid)))
+		So(out, ShouldContainSubstring, fmt.Sprintf("lookup $mid\n$6 = %d\nrank 0 1000 42\n$7 = %d\n", want.Lookup(mid), want.Rank(1000, 42)))
+		So(out, ShouldContainSubstring, "    7  rank 0 1000 42\n    8  history\n")
+		So(out, ShouldContainSubstring, "$7 = 10\n$mid = ")
+		So(out, ShouldContainSubstring, "error: $4 holds 8 values, not one")
+		So(out, ShouldContainSubstring, "error: undefined variable $missing")
+		So(out, ShouldContainSubstring, "error: position 1000 is out of 1000 values")
+		So(out, ShouldNotContainSubstring, "$8")
+
+		out, err = runCommand("lookup 1\n", "shell", path)
+		So(err, ShouldBeNil)
+		So(out, ShouldStartWith, "$1 = 7\n(")
+		So(out, ShouldEndWith, ")\n")
+
+		Convey("The history should be kept across sessions", func() {
+			history := filepath.Join(dir, "history")
+			_, err := runCommand("lookup 1\nrank $ 42\n", "shell", "-time=false", "-history", history, path)
+			So(err, ShouldBeNil)
+			out, err := runCommand("history\n!2\n", "shell", "-time=false", "-history", history, path)
+			So(err, ShouldBeNil)
+			So(out, ShouldStartWith, "    1  lookup 1\n    2  rank $ 42\n    3  history\n")
+			So(out, ShouldContainSubstring, fmt.Sprintf("rank $ 42\n$1 = %d\n", want.Rank(1000, 42)))
+			data, err := os.ReadFile(history)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "lookup 1\nrank $ 42\nhistory\nrank $ 42\n")
+		})
+	})
+
+	Convey("When a matrix is built from CSV and binary", t, func() {
+		dir := t.TempDir()
+		csvPath := filepath.Join(dir, "in.csv")
+		So(os.WriteFile(csvPath, []byte("id,score\n1,30\n2,10\n3,30\n"), 0o644), ShouldBeNil)
+		path := filepath.Join(dir, "csv.wm")
+		_, err := runCommand("", "build", "-format", "csv", "-header", "-column", "score", "-compact", "-o", path, csvPath)
+		So(err, ShouldBeNil)
+		out, err := runCommand("", "query", path, "rank", "$", "30")
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "2\n")
+
+		_, err = runCommand("", "build", "-format", "csv", "-column", "score", "-o", path, csvPath)
+		So(err, ShouldNotBeNil)
+		_, err = runCommand("", "build", "-format", "csv", "-header", "-column", "missing", "-o", path, csvPath)
+		So(err, ShouldNotBeNil)
+
+		in := make([]byte, 0)
+		for _, val := range []uint16{500, 3, 500, 65535} {
+			in = binary.LittleEndian.AppendUint16(in, val)
+		}
+		_, err = runCommand(string(in), "build", "-format", "binary", "-bits", "16", "-o", path, "-")
+		So(err, ShouldBeNil)
+		out, err = runCommand("", "query", path, "lookup", "3")
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "65535\n")
+
+		_, err = runCommand(string(in[:3]), "build", "-format", "binary", "-bits", "16", "-o", path, "-")
+		So(err, ShouldNotBeNil)
+		_, err = runCommand("256", "build", "-bits", "8", "-o", path, "-")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When the usage is wrong", t, func() {
+		_, err := runCommand("")
+		So(err, ShouldEqual, errUsage)
+		_, err = runCommand("", "unknown")
+		So(err, ShouldEqual, errUsage)
+		_, err = runCommand("", "build", "-nosuchflag")
+		So(err, ShouldEqual, errUsage)
+		_, err = runCommand("", "query", "-h")
+		So(err, ShouldBeNil)
+	})
+}
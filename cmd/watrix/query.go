@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	watrix "github.com/AlexWan0/go-watrix"
+)
+
+// query is an operation of the query command.
+type query struct {
+	args  string // the arguments for the usage
+	help  string
+	nargs int // the number of arguments, or the minimum if variadic
+	eval  func(p *argParser) any
+	// variadic allows more than nargs arguments.
+	variadic bool
+}
+
+var queries = map[string]query{
+	"lookup": {
+		args: "POS", help: "the value at POS", nargs: 1,
+		eval: func(p *argParser) any {
+			pos := p.pos(0)
+			if p.err == nil && pos >= p.wm.Num() {
+				p.fail("position %d is out of %d values", pos, p.wm.Num())
+				return nil
+			}
+			return p.wm.Lookup(pos)
+		},
+	},
+	"rank": {
+		args: "[BEG] END VAL", help: "the number of VAL in [BEG, END), where BEG defaults to 0", nargs: 2, variadic: true,
+		eval: func(p *argParser) any {
+			switch len(p.args) {
+			case 2:
+				return p.wm.Rank(p.pos(0), p.value(1))
+			case 3:
+				return p.wm.RangedRankOp(p.posRange(0), p.value(2), watrix.OpEqual)
+			}
+			p.fail("usage: rank [BEG] END VAL")
+			return nil
+		},
+	},
+	"select": {
+		args: "RANK VAL", help: "the position of the (RANK+1)-th VAL, or num if none", nargs: 2,
+		eval: func(p *argParser) any {
+			return p.wm.Select(p.value(0), p.value(1))
+		},
+	},
+	"quantile": {
+		args: "BEG END K", help: "the (K+1)-th smallest value in [BEG, END); K may be a percentage such as 50%", nargs: 3,
+		eval: func(p *argParser) any {
+			posRange := p.posRange(0)
+			k := p.rank(2, posRange.End-posRange.Beg)
+			if p.err != nil {
+				return nil
+			}
+			return p.wm.Quantile(posRange, k)
+		},
+	},
+	"rangedrank": {
+		args: "BEG END LO HI", help: "the number of values in [LO, HI) in [BEG, END)", nargs: 4,
+		eval: func(p *argParser) any {
+			valueRange := watrix.Range{Beg: p.value(2), End: p.value(3)}
+			if valueRange.Beg > valueRange.End {
+				p.fail("value range [%d, %d) is reversed", valueRange.Beg, valueRange.End)
+			}
+			if p.err != nil {
+				return nil
+			}
+			return p.wm.RangedRankRange(p.posRange(0), valueRange)
+		},
+	},
+	"intersect": {
+		args: "K BEG END [BEG END]...", help: "the values occurring in at least K of the ranges", nargs: 3, variadic: true,
+		eval: func(p *argParser) any {
+			if len(p.args)%2 != 1 {
+				p.fail("ranges need both BEG and END")
+				return nil
+			}
+			ranges := make([]watrix.Range, 0, len(p.args)/2)
+			for i := 1; i < len(p.args); i += 2 {
+				ranges = append(ranges, p.posRange(i))
+			}
+			k := p.value(0)
+			if p.err == nil && (k == 0 || k > uint64(len(ranges))) {
+				p.fail("K %d is out of 1 to %d ranges", k, len(ranges))
+			}
+			if p.err != nil {
+				return nil
+			}
+			return p.wm.Intersect(ranges, int(k))
+		},
+	},
+	"hist": {
+		args: "BEG END N", help: "the counts in [BEG, END) of N equal ranges of the values below dim", nargs: 3,
+		eval: func(p *argParser) any {
+			posRange, n := p.posRange(0), p.value(2)
+			if p.err == nil && (n == 0 || n > maxBuckets) {
+				p.fail("N %d is out of 1 to %d", n, maxBuckets)
+			}
+			if p.err != nil {
+				return nil
+			}
+			return histogram(p.wm, posRange, n)
+		},
+	},
+	"topk": {
+		args: "BEG END K", help: "the K most frequent values in [BEG, END) with their counts", nargs: 3,
+		eval: func(p *argParser) any {
+			return p.wm.TopK(p.posRange(0), int(p.value(2)))
+		},
+	},
+}
+
+// maxBuckets is the maximum number of ranges of hist.
+const maxBuckets = 1 << 16
+
+// bucket is the count of the values in [Lo, Hi).
+type bucket struct {
+	Lo    uint64 `json:"lo"`
+	Hi    uint64 `json:"hi"`
+	Count uint64 `json:"count"`
+}
+
+// histogram counts the values in posRange in n equal ranges of [0, dim),
+// or in dim ranges if n exceeds dim.
+func histogram(wm *watrix.WaveletMatrix, posRange watrix.Range, n uint64) []bucket {
+	dim := wm.Dim()
+	if n > dim {
+		n = dim
+	}
+	if n == 0 {
+		return nil
+	}
+	width := dim / n
+	if dim%n != 0 {
+		width++
+	}
+	buckets := make([]bucket, 0, n)
+	for lo := uint64(0); lo < dim; {
+		hi := lo + width
+		if hi < lo || hi > dim {
+			hi = dim
+		}
+		buckets = append(buckets, bucket{lo, hi, wm.RangedRankRange(posRange, watrix.Range{Beg: lo, End: hi})})
+		lo = hi
+	}
+	return buckets
+}
+
+func runQuery(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: watrix query [-json] file.wm op args...")
+		fmt.Fprintln(stderr, "A position may be $ for num.  The queries are")
+		queryUsage(stderr)
+		fs.PrintDefaults()
+	}
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errUsage
+	}
+	wm, err := loadMatrix(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	result, err := evalQuery(wm, fs.Arg(1), fs.Args()[2:])
+	if err != nil {
+		return err
+	}
+	return printResult(stdout, fs.Arg(1), result, *asJSON)
+}
+
+func queryUsage(w io.Writer) {
+	names := make([]string, 0, len(queries))
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		q := queries[name]
+		fmt.Fprintf(w, "  %s %s\n    \t%s\n", name, q.args, q.help)
+	}
+}
+
+// loadMatrix reads the matrix at path.
+func loadMatrix(path string) (*watrix.WaveletMatrix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	wm := new(watrix.WaveletMatrix)
+	if _, err := wm.ReadFrom(bufio.NewReader(f)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return wm, nil
+}
+
+// evalQuery runs the query op with args on wm.
+func evalQuery(wm *watrix.WaveletMatrix, op string, args []string) (any, error) {
+	q, ok := queries[op]
+	if !ok {
+		return nil, fmt.Errorf("unknown query %q", op)
+	}
+	if len(args) < q.nargs || (!q.variadic && len(args) > q.nargs) {
+		return nil, fmt.Errorf("usage: %s %s", op, q.args)
+	}
+	p := &argParser{wm: wm, args: args}
+	result := q.eval(p)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return result, nil
+}
+
+// argParser parses the arguments of a query, and keeps the first error.
+// An argument that fails to parse is 0, so that the query can still run
+// safely before its result is discarded.
+type argParser struct {
+	wm   *watrix.WaveletMatrix
+	args []string
+	err  error
+}
+
+func (p *argParser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, args...)
+	}
+}
+
+// value parses args[i] as an unsigned integer.
+func (p *argParser) value(i int) uint64 {
+	val, err := strconv.ParseUint(p.args[i], 10, 64)
+	if err != nil {
+		p.fail("argument %d: %q is not an unsigned integer", i+1, p.args[i])
+		return 0
+	}
+	return val
+}
+
+// pos parses args[i] as a position up to num, where $ is num.
+func (p *argParser) pos(i int) uint64 {
+	if p.args[i] == "$" {
+		return p.wm.Num()
+	}
+	pos := p.value(i)
+	if pos > p.wm.Num() {
+		p.fail("position %d is out of %d values", pos, p.wm.Num())
+		return 0
+	}
+	return pos
+}
+
+// posRange parses args[i] and args[i+1] as a range of positions.
+func (p *argParser) posRange(i int) watrix.Range {
+	posRange := watrix.Range{Beg: p.pos(i), End: p.pos(i + 1)}
+	if posRange.Beg > posRange.End {
+		p.fail("range [%d, %d) is reversed", posRange.Beg, posRange.End)
+		return watrix.Range{}
+	}
+	return posRange
+}
+
+// rank parses args[i] as a rank below width, or as a percentage of width
+// such as 50%.
+func (p *argParser) rank(i int, width uint64) uint64 {
+	if width == 0 {
+		p.fail("the range is empty")
+		return 0
+	}
+	if percent, ok := strings.CutSuffix(p.args[i], "%"); ok {
+		f, err := strconv.ParseFloat(percent, 64)
+		if err != nil || f < 0 || f > 100 {
+			p.fail("argument %d: %q is not a percentage", i+1, p.args[i])
+			return 0
+		}
+		return uint64(f / 100 * float64(width-1))
+	}
+	k := p.value(i)
+	if k >= width {
+		p.fail("rank %d is out of %d values", k, width)
+		return 0
+	}
+	return k
+}
+
+// valueCount is watrix.ValueCount in JSON.
+type valueCount struct {
+	Value uint64 `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// printResult prints the result of the query op, one value per line.
+func printResult(w io.Writer, op string, result any, asJSON bool) error {
+	if asJSON {
+		if vcs, ok := result.([]watrix.ValueCount); ok {
+			converted := make([]valueCount, len(vcs))
+			for i, vc := range vcs {
+				converted[i] = valueCount(vc)
+			}
+			result = converted
+		}
+		return json.NewEncoder(w).Encode(map[string]any{"op": op, "result": result})
+	}
+	var err error
+	switch result := result.(type) {
+	case []uint64:
+		for _, val := range result {
+			if _, err = fmt.Fprintln(w, val); err != nil {
+				break
+			}
+		}
+	case []watrix.ValueCount:
+		for _, vc := range result {
+			if _, err = fmt.Fprintf(w, "%d\t%d\n", vc.Value, vc.Count); err != nil {
+				break
+			}
+		}
+	case []bucket:
+		for _, b := range result {
+			if _, err = fmt.Fprintf(w, "[%d, %d)\t%d\n", b.Lo, b.Hi, b.Count); err != nil {
+				break
+			}
+		}
+	default:
+		_, err = fmt.Fprintln(w, result)
+	}
+	return err
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	watrix "github.com/AlexWan0/go-watrix"
+)
+
+func runShell(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("shell", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: watrix shell [flags] file.wm")
+		fmt.Fprintln(stderr, "Reads queries from the standard input; type help for the commands.")
+		fs.PrintDefaults()
+	}
+	timing := fs.Bool("time", true, "print the time each query takes")
+	historyFile := fs.String("history", "", "keep the history in `file` across sessions (default $HOME/.watrix_history on a terminal)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	wm, err := loadMatrix(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	sh := &shell{
+		wm:     wm,
+		vars:   make(map[string]any),
+		out:    stdout,
+		timing: *timing,
+	}
+	if isTerminal(stdin) {
+		sh.prompt = "watrix> "
+		fmt.Fprintf(stdout, "%s: %d values below %d; type help for the commands\n", fs.Arg(0), wm.Num(), wm.Dim())
+		if *historyFile == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				*historyFile = filepath.Join(home, ".watrix_history")
+			}
+		}
+	}
+	if *historyFile != "" {
+		if err := sh.loadHistory(*historyFile); err != nil {
+			return err
+		}
+	}
+	return sh.run(stdin)
+}
+
+// isTerminal reports whether r is an interactive terminal, to which the
+// shell prints a prompt.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// shell runs queries on a matrix line by line.  Each result is bound to
+// $1, $2, ..., and to $name after "name = query ...".
+type shell struct {
+	wm          *watrix.WaveletMatrix
+	history     []string
+	historyFile string // the file each line is appended to, if any
+	vars        map[string]any
+	results     int // the number of results bound so far
+	out         io.Writer
+	prompt      string
+	timing      bool
+}
+
+func (sh *shell) run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(sh.out, sh.prompt)
+		if !scanner.Scan() {
+			break
+		}
+		if err := sh.exec(scanner.Text()); err == io.EOF {
+			return nil
+		} else if err != nil {
+			fmt.Fprintln(sh.out, "error:", err)
+		}
+	}
+	if sh.prompt != "" {
+		fmt.Fprintln(sh.out)
+	}
+	return scanner.Err()
+}
+
+// exec runs a line, and returns io.EOF to leave the shell.
+func (sh *shell) exec(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	if strings.HasPrefix(line, "!") {
+		expanded, err := sh.expandHistory(line)
+		if err != nil {
+			return err
+		}
+		line = expanded
+		fmt.Fprintln(sh.out, line)
+	}
+	sh.history = append(sh.history, line)
+	sh.saveHistory(line)
+
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "exit", "quit":
+		return io.EOF
+	case "help":
+		sh.help()
+		return nil
+	case "history":
+		for i, entry := range sh.history {
+			fmt.Fprintf(sh.out, "%5d  %s\n", i+1, entry)
+		}
+		return nil
+	case "vars":
+		sh.printVars()
+		return nil
+	}
+
+	name := ""
+	if len(fields) >= 2 && fields[1] == "=" {
+		name = fields[0]
+		if !isVarName(name) {
+			return fmt.Errorf("%q is not a variable name", name)
+		}
+		fields = fields[2:]
+		if len(fields) == 0 {
+			return fmt.Errorf("usage: %s = query args...", name)
+		}
+	}
+	args, err := sh.substitute(fields[1:])
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	result, err := evalQuery(sh.wm, fields[0], args)
+	elapsed := time.Since(start)
+	if err != nil {
+		return err
+	}
+	sh.results++
+	sh.vars[strconv.Itoa(sh.results)] = result
+	label := "$" + strconv.Itoa(sh.results)
+	if name != "" {
+		sh.vars[name] = result
+		label = "$" + name
+	}
+	if val, ok := result.(uint64); ok {
+		fmt.Fprintf(sh.out, "%s = %d\n", label, val)
+	} else {
+		fmt.Fprintf(sh.out, "%s =\n", label)
+		if err := printResult(sh.out, fields[0], result, false); err != nil {
+			return err
+		}
+	}
+	if sh.timing {
+		fmt.Fprintf(sh.out, "(%v)\n", elapsed)
+	}
+	return nil
+}
+
+// expandHistory returns the entry of the history named by line: !! is the
+// last one and !N is the N-th.
+func (sh *shell) expandHistory(line string) (string, error) {
+	if len(sh.history) == 0 {
+		return "", fmt.Errorf("the history is empty")
+	}
+	if line == "!!" {
+		return sh.history[len(sh.history)-1], nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(sh.history) {
+		return "", fmt.Errorf("%s: no such entry in %d entries", line, len(sh.history))
+	}
+	return sh.history[n-1], nil
+}
+
+// loadHistory reads the lines of earlier sessions from path, which need
+// not exist, and appends the lines run from now on to it.
+func (sh *shell) loadHistory(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			sh.history = append(sh.history, line)
+		}
+	}
+	sh.historyFile = path
+	return nil
+}
+
+// saveHistory appends line to the history file.  After a failure, it
+// reports the error and stops saving.
+func (sh *shell) saveHistory(line string) {
+	if sh.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(sh.historyFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err == nil {
+		_, err = fmt.Fprintln(f, line)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(sh.out, "error: the history is no longer saved:", err)
+		sh.historyFile = ""
+	}
+}
+
+// substitute replaces the variables in args with their values.  A variable
+// must hold a single value; $ alone is left as num.
+func (sh *shell) substitute(args []string) ([]string, error) {
+	ret := make([]string, len(args))
+	for i, arg := range args {
+		name, ok := strings.CutPrefix(arg, "$")
+		if !ok || name == "" {
+			ret[i] = arg
+			continue
+		}
+		result, ok := sh.vars[name]
+		if !ok {
+			return nil, fmt.Errorf("undefined variable %s", arg)
+		}
+		val, ok := result.(uint64)
+		if !ok {
+			return nil, fmt.Errorf("%s holds %d values, not one", arg, resultLen(result))
+		}
+		ret[i] = strconv.FormatUint(val, 10)
+	}
+	return ret, nil
+}
+
+func (sh *shell) printVars() {
+	names := make([]string, 0, len(sh.vars))
+	for name := range sh.vars {
+		names = append(names, name)
+	}
+	// The numbered results come first, in order.
+	sort.Slice(names, func(i, j int) bool {
+		ni, erri := strconv.Atoi(names[i])
+		nj, errj := strconv.Atoi(names[j])
+		switch {
+		case erri == nil && errj == nil:
+			return ni < nj
+		case erri == nil || errj == nil:
+			return erri == nil
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		if val, ok := sh.vars[name].(uint64); ok {
+			fmt.Fprintf(sh.out, "$%s = %d\n", name, val)
+		} else {
+			fmt.Fprintf(sh.out, "$%s = (%d values)\n", name, resultLen(sh.vars[name]))
+		}
+	}
+}
+
+func (sh *shell) help() {
+	fmt.Fprintln(sh.out, `A line is a query, or name = query to bind its result to $name as well
+as to $1, $2, ...  Arguments may be variables holding a single value, and
+a position may be $ for num.  The queries are`)
+	queryUsage(sh.out)
+	fmt.Fprintln(sh.out, `The other commands are
+  history  	list the lines run so far, including those of earlier
+           	sessions kept in the file of -history
+  !N, !!   	run the N-th or the last line again
+  vars     	list the variables
+  exit     	leave the shell`)
+}
+
+// isVarName reports whether name can be bound to a result.  Numbers are
+// kept for the numbered results.
+func isVarName(name string) bool {
+	for i, c := range name {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// resultLen returns the number of values in a list result.
+func resultLen(result any) int {
+	switch result := result.(type) {
+	case []uint64:
+		return len(result)
+	case []watrix.ValueCount:
+		return len(result)
+	case []bucket:
+		return len(result)
+	}
+	return 1
+}
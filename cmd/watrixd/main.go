@@ -0,0 +1,167 @@
+// Command watrixd serves queries on serialized wavelet matrices over HTTP.
+//
+// Usage:
+//
+//	watrixd [flags] name=file.wm ...
+//
+// Each matrix is served under its name, as is each member of the bundles
+// given by -bundle.  The endpoints are
+//
+//	GET  /healthz                     the process is alive
+//	GET  /readyz                      the matrices are loaded (503 until then)
+//	GET  /v1/matrices                 the names, num and dim of the matrices
+//	POST /v1/matrices/{name}/{op}     a query; the body holds its arguments
+//	POST /v1/matrices/{name}/batch    {"queries": [...]}, each with "op"
+//
+// where op is one of lookup {pos}, rank {pos, value}, select {rank, value},
+// quantile {beg, end, k}, rangedrankrange {beg, end, lo, hi},
+// rangedrankignorelsbs {beg, end, value, ignore_bits} and
+// intersect {ranges: [{beg, end}...], k}.  A query responds
+// {"result": ...}, or {"error": ...} with status 400 if its arguments are
+// invalid; a batch responds {"results": [...]} holding either for each
+// query.  On SIGINT or SIGTERM, the server stops being ready and finishes
+// the requests in flight before exiting.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	watrix "github.com/AlexWan0/go-watrix"
+)
+
+// listFlag is a flag that can be given more than once.
+type listFlag []string
+
+func (lf *listFlag) String() string     { return strings.Join(*lf, ",") }
+func (lf *listFlag) Set(s string) error { *lf = append(*lf, s); return nil }
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "listen on `address`")
+	var bundles listFlag
+	flag.Var(&bundles, "bundle", "serve the members of the bundle `file`; may be repeated")
+	maxBody := flag.Int64("max-body", 1<<20, "the maximum `bytes` of a request body")
+	maxBatch := flag.Int("max-batch", 10000, "the maximum number of queries in a batch")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "how long to wait for the requests in flight on shutdown")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: watrixd [flags] name=file.wm ...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 && len(bundles) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	s := &server{maxBody: *maxBody, maxBatch: *maxBatch}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %s", ln.Addr())
+
+	// Serve health checks while loading, and stop if the loading fails.
+	loadErr := make(chan error, 1)
+	go func() {
+		matrices, err := loadMatrices(flag.Args(), bundles)
+		if err != nil {
+			loadErr <- err
+			stop()
+			return
+		}
+		s.setMatrices(matrices)
+		log.Printf("loaded %d matrices", len(matrices))
+	}()
+	if err := serve(ctx, ln, s, *shutdownTimeout); err != nil {
+		log.Fatal(err)
+	}
+	select {
+	case err := <-loadErr:
+		log.Fatal(err)
+	default:
+	}
+}
+
+// serve serves s on ln until ctx is done, and then shuts down gracefully
+// within timeout.
+func serve(ctx context.Context, ln net.Listener, s *server, timeout time.Duration) error {
+	hs := &http.Server{
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- hs.Serve(ln)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	s.ready.Store(false)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := hs.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// loadMatrices loads the matrices given as name=path and the members of
+// the bundles.
+func loadMatrices(specs []string, bundles []string) (map[string]*watrix.WaveletMatrix, error) {
+	matrices := make(map[string]*watrix.WaveletMatrix)
+	add := func(name string, wm *watrix.WaveletMatrix) error {
+		if _, ok := matrices[name]; ok {
+			return fmt.Errorf("duplicate matrix %q", name)
+		}
+		matrices[name] = wm
+		return nil
+	}
+	for _, spec := range specs {
+		name, path, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || path == "" {
+			return nil, fmt.Errorf("%q is not name=path", spec)
+		}
+		wm := new(watrix.WaveletMatrix)
+		if err := wm.UnmarshalBinaryFile(path); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := add(name, wm); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range bundles {
+		bf, err := watrix.OpenBundleFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, name := range bf.Names() {
+			wm, err := bf.Load(name)
+			if err == nil {
+				err = add(name, wm)
+			}
+			if err != nil {
+				bf.Close()
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		bf.Close()
+	}
+	return matrices, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+
+	watrix "github.com/AlexWan0/go-watrix"
+)
+
+// maxRanges is the maximum number of ranges of an intersect query.
+const maxRanges = 1024
+
+// queryRequest holds the arguments of a query.  Each op uses some of them;
+// Op names the query in a batch.
+type queryRequest struct {
+	Op         string      `json:"op,omitempty"`
+	Pos        *uint64     `json:"pos,omitempty"`
+	Value      *uint64     `json:"value,omitempty"`
+	Rank       *uint64     `json:"rank,omitempty"`
+	Beg        *uint64     `json:"beg,omitempty"`
+	End        *uint64     `json:"end,omitempty"`
+	K          *uint64     `json:"k,omitempty"`
+	Lo         *uint64     `json:"lo,omitempty"`
+	Hi         *uint64     `json:"hi,omitempty"`
+	IgnoreBits *uint64     `json:"ignore_bits,omitempty"`
+	Ranges     []rangeJSON `json:"ranges,omitempty"`
+}
+
+type rangeJSON struct {
+	Beg uint64 `json:"beg"`
+	End uint64 `json:"end"`
+}
+
+// ops evaluates the queries after validating their arguments.
+var ops = map[string]func(wm *watrix.WaveletMatrix, q *queryRequest) (any, error){
+	"lookup": func(wm *watrix.WaveletMatrix, q *queryRequest) (any, error) {
+		v := validator{wm: wm}
+		pos := v.required(q.Pos, "pos")
+		if v.err == nil && pos >= wm.Num() {
+			v.fail("pos %d is out of %d values", pos, wm.Num())
+		}
+		if v.err != nil {
+			return nil, v.err
+		}
+		return wm.Lookup(pos), nil
+	},
+	"rank": func(wm *watrix.WaveletMatrix, q *queryRequest) (any, error) {
+		v := validator{wm: wm}
+		pos, val := v.pos(q.Pos, "pos"), v.required(q.Value, "value")
+		if v.err != nil {
+			return nil, v.err
+		}
+		return wm.Rank(pos, val), nil
+	},
+	"select": func(wm *watrix.WaveletMatrix, q *queryRequest) (any, error) {
+		v := validator{wm: wm}
+		rank, val := v.required(q.Rank, "rank"), v.required(q.Value, "value")
+		if v.err != nil {
+			return nil, v.err
+		}
+		return wm.Select(rank, val), nil
+	},
+	"quantile": func(wm *watrix.WaveletMatrix, q *queryRequest) (any, error) {
+		v := validator{wm: wm}
+		posRange, k := v.posRange(q), v.required(q.K, "k")
+		if v.err == nil && k >= posRange.End-posRange.Beg {
+			v.fail("k %d is out of %d values", k, posRange.End-posRange.Beg)
+		}
+		if v.err != nil {
+			return nil, v.err
+		}
+		return wm.Quantile(posRange, k), nil
+	},
+	"rangedrankrange": func(wm *watrix.WaveletMatrix, q *queryRequest) (any, error) {
+		v := validator{wm: wm}
+		posRange := v.posRange(q)
+		valueRange := watrix.Range{Beg: v.required(q.Lo, "lo"), End: v.required(q.Hi, "hi")}
+		if v.err == nil && valueRange.Beg > valueRange.End {
+			v.fail("lo %d exceeds hi %d", valueRange.Beg, valueRange.End)
+		}
+		if v.err != nil {
+			return nil, v.err
+		}
+		return wm.RangedRankRange(posRange, valueRange), nil
+	},
+	"rangedrankignorelsbs": func(wm *watrix.WaveletMatrix, q *queryRequest) (any, error) {
+		v := validator{wm: wm}
+		posRange := v.posRange(q)
+		val, ignoreBits := v.required(q.Value, "value"), v.required(q.IgnoreBits, "ignore_bits")
+		if v.err == nil && ignoreBits > 64 {
+			v.fail("ignore_bits %d exceeds 64", ignoreBits)
+		}
+		if v.err != nil {
+			return nil, v.err
+		}
+		return wm.RangedRankIgnoreLSBs(posRange, val, ignoreBits), nil
+	},
+	"intersect": func(wm *watrix.WaveletMatrix, q *queryRequest) (any, error) {
+		v := validator{wm: wm}
+		k := v.required(q.K, "k")
+		if len(q.Ranges) == 0 || len(q.Ranges) > maxRanges {
+			v.fail("ranges needs 1 to %d ranges", maxRanges)
+		}
+		ranges := make([]watrix.Range, len(q.Ranges))
+		for i, r := range q.Ranges {
+			ranges[i] = v.checkRange(watrix.Range{Beg: r.Beg, End: r.End})
+		}
+		if v.err == nil && (k == 0 || k > uint64(len(ranges))) {
+			v.fail("k %d is out of 1 to %d ranges", k, len(ranges))
+		}
+		if v.err != nil {
+			return nil, v.err
+		}
+		return wm.Intersect(ranges, int(k)), nil
+	},
+}
+
+// validator checks the arguments of a query, and keeps the first error.
+type validator struct {
+	wm  *watrix.WaveletMatrix
+	err error
+}
+
+func (v *validator) fail(format string, args ...any) {
+	if v.err == nil {
+		v.err = fmt.Errorf(format, args...)
+	}
+}
+
+// required returns *p, which must be present.
+func (v *validator) required(p *uint64, name string) uint64 {
+	if p == nil {
+		v.fail("missing %s", name)
+		return 0
+	}
+	return *p
+}
+
+// pos returns *p, which must be a position up to num.
+func (v *validator) pos(p *uint64, name string) uint64 {
+	pos := v.required(p, name)
+	if pos > v.wm.Num() {
+		v.fail("%s %d is out of %d values", name, pos, v.wm.Num())
+		return 0
+	}
+	return pos
+}
+
+// posRange returns the range of q.Beg and q.End.
+func (v *validator) posRange(q *queryRequest) watrix.Range {
+	return v.checkRange(watrix.Range{Beg: v.pos(q.Beg, "beg"), End: v.pos(q.End, "end")})
+}
+
+// checkRange returns posRange, which must be within num.
+func (v *validator) checkRange(posRange watrix.Range) watrix.Range {
+	if posRange.Beg > posRange.End || posRange.End > v.wm.Num() {
+		v.fail("range [%d, %d) is out of %d values", posRange.Beg, posRange.End, v.wm.Num())
+		return watrix.Range{}
+	}
+	return posRange
+}
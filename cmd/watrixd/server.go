@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	watrix "github.com/AlexWan0/go-watrix"
+)
+
+// server serves the queries on the matrices.  It is not ready until the
+// matrices are set, and stops being ready when it shuts down.
+type server struct {
+	matrices atomic.Pointer[map[string]*watrix.WaveletMatrix]
+	ready    atomic.Bool
+	maxBody  int64 // the maximum bytes of a request body
+	maxBatch int   // the maximum number of queries in a batch
+}
+
+// setMatrices sets the matrices to serve and makes s ready.
+func (s *server) setMatrices(matrices map[string]*watrix.WaveletMatrix) {
+	s.matrices.Store(&matrices)
+	s.ready.Store(true)
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	mux.HandleFunc("GET /v1/matrices", s.handleList)
+	mux.HandleFunc("POST /v1/matrices/{name}/batch", s.handleBatch)
+	mux.HandleFunc("POST /v1/matrices/{name}/{op}", s.handleQuery)
+	return mux
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// matrixInfo describes a matrix in the list.
+type matrixInfo struct {
+	Name      string `json:"name"`
+	Num       uint64 `json:"num"`
+	Dim       uint64 `json:"dim"`
+	Backend   string `json:"backend"`
+	Compacted bool   `json:"compacted"`
+}
+
+func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
+	matrices := s.matrices.Load()
+	if matrices == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("the matrices are loading"))
+		return
+	}
+	list := make([]matrixInfo, 0, len(*matrices))
+	for name, wm := range *matrices {
+		list = append(list, matrixInfo{name, wm.Num(), wm.Dim(), wm.Backend().String(), wm.Compacted()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeJSON(w, http.StatusOK, map[string]any{"matrices": list})
+}
+
+func (s *server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	wm, status, err := s.matrix(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	op, ok := ops[r.PathValue("op")]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown query %q", r.PathValue("op")))
+		return
+	}
+	var q queryRequest
+	if status, err := s.decode(w, r, &q); err != nil {
+		writeError(w, status, err)
+		return
+	}
+	if q.Op != "" {
+		writeError(w, http.StatusBadRequest, errors.New("op is only for batches"))
+		return
+	}
+	result, err := op(wm, &q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, queryResult{Result: result})
+}
+
+// batchRequest is the body of a batch, whose queries name their op.
+type batchRequest struct {
+	Queries []queryRequest `json:"queries"`
+}
+
+// queryResult is the result of a query, or its error in a batch.
+type queryResult struct {
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (s *server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	wm, status, err := s.matrix(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	var batch batchRequest
+	if status, err := s.decode(w, r, &batch); err != nil {
+		writeError(w, status, err)
+		return
+	}
+	if len(batch.Queries) > s.maxBatch {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%d queries exceed the batch limit %d", len(batch.Queries), s.maxBatch))
+		return
+	}
+	results := make([]queryResult, len(batch.Queries))
+	for i := range batch.Queries {
+		q := &batch.Queries[i]
+		op, ok := ops[q.Op]
+		if !ok {
+			results[i].Error = fmt.Sprintf("unknown query %q", q.Op)
+			continue
+		}
+		result, err := op(wm, q)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Result = result
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}
+
+// matrix returns the matrix named in the path of r, or the status and the
+// error to respond.
+func (s *server) matrix(r *http.Request) (*watrix.WaveletMatrix, int, error) {
+	matrices := s.matrices.Load()
+	if matrices == nil || !s.ready.Load() {
+		return nil, http.StatusServiceUnavailable, errors.New("the server is not ready")
+	}
+	wm, ok := (*matrices)[r.PathValue("name")]
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("no matrix %q", r.PathValue("name"))
+	}
+	return wm, 0, nil
+}
+
+// decode decodes the JSON body of r into v, rejecting unknown fields and
+// bodies over maxBody.  It returns the status and the error to respond.
+func (s *server) decode(w http.ResponseWriter, r *http.Request, v any) (int, error) {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("the body exceeds %d bytes", tooLarge.Limit)
+		}
+		return http.StatusBadRequest, fmt.Errorf("invalid body: %w", err)
+	}
+	if dec.More() {
+		return http.StatusBadRequest, errors.New("invalid body: trailing data")
+	}
+	return 0, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, queryResult{Error: err.Error()})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	watrix "github.com/AlexWan0/go-watrix"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func buildMatrix(vals []uint64) *watrix.WaveletMatrix {
+	wmb := watrix.NewBuilder()
+	for _, val := range vals {
+		wmb.PushBack(val)
+	}
+	return wmb.Build()
+}
+
+// call posts body to path and returns the status and the decoded response.
+func call(ts *httptest.Server, method, path, body string) (int, map[string]any) {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	So(err, ShouldBeNil)
+	resp, err := ts.Client().Do(req)
+	So(err, ShouldBeNil)
+	defer resp.Body.Close()
+	var ret map[string]any
+	out, err := io.ReadAll(resp.Body)
+	So(err, ShouldBeNil)
+	json.Unmarshal(out, &ret)
+	return resp.StatusCode, ret
+}
+
+func TestServer(t *testing.T) {
+	Convey("When the server serves a matrix", t, func() {
+		vals := make([]uint64, 1000)
+		for i := range vals {
+			vals[i] = uint64(i * 7 % 100)
+		}
+		wm := buildMatrix(vals)
+		s := &server{maxBody: 1 << 10, maxBatch: 3}
+		ts := httptest.NewServer(s.handler())
+		defer ts.Close()
+
+		status, ret := call(ts, "GET", "/readyz", "")
+		So(status, ShouldEqual, http.StatusServiceUnavailable)
+		status, _ = call(ts, "POST", "/v1/matrices/v/lookup", `{"pos": 1}`)
+		So(status, ShouldEqual, http.StatusServiceUnavailable)
+		s.setMatrices(map[string]*watrix.WaveletMatrix{"v": wm})
+
+		status, ret = call(ts, "GET", "/healthz", "")
+		So(status, ShouldEqual, http.StatusOK)
+		status, ret = call(ts, "GET", "/readyz", "")
+		So(status, ShouldEqual, http.StatusOK)
+		So(ret["status"], ShouldEqual, "ready")
+		status, ret = call(ts, "GET", "/v1/matrices", "")
+		So(status, ShouldEqual, http.StatusOK)
+		So(ret["matrices"], ShouldResemble, []any{map[string]any{"name": "v", "num": 1000.0, "dim": 100.0, "backend": "rsdic", "compacted": false}})
+
+		Convey("Queries should answer like the library", func() {
+			cases := []struct {
+				op, body string
+				want     any
+			}{
+				{"lookup", `{"pos": 10}`, float64(wm.Lookup(10))},
+				{"rank", `{"pos": 1000, "value": 42}`, float64(wm.Rank(1000, 42))},
+				{"select", `{"rank": 3, "value": 42}`, float64(wm.Select(3, 42))},
+				{"quantile", `{"beg": 100, "end": 500, "k": 7}`, float64(wm.Quantile(watrix.Range{Beg: 100, End: 500}, 7))},
+				{"rangedrankrange", `{"beg": 0, "end": 500, "lo": 10, "hi": 20}`, float64(wm.RangedRankRange(watrix.Range{Beg: 0, End: 500}, watrix.Range{Beg: 10, End: 20}))},
+				{"rangedrankignorelsbs", `{"beg": 0, "end": 500, "value": 40, "ignore_bits": 3}`, float64(wm.RangedRankIgnoreLSBs(watrix.Range{Beg: 0, End: 500}, 40, 3))},
+				{"intersect", `{"ranges": [{"beg": 0, "end": 3}, {"beg": 100, "end": 103}], "k": 2}`, []any{0.0, 7.0, 14.0}},
+			}
+			for _, c := range cases {
+				status, ret := call(ts, "POST", "/v1/matrices/v/"+c.op, c.body)
+				So(status, ShouldEqual, http.StatusOK)
+				So(ret["result"], ShouldResemble, c.want)
+			}
+		})
+		Convey("Invalid queries should be rejected", func() {
+			cases := []struct {
+				path, body string
+				status     int
+			}{
+				{"/v1/matrices/missing/lookup", `{"pos": 1}`, http.StatusNotFound},
+				{"/v1/matrices/v/missing", `{"pos": 1}`, http.StatusNotFound},
+				{"/v1/matrices/v/lookup", `{"pos": 1000}`, http.StatusBadRequest},
+				{"/v1/matrices/v/lookup", `{}`, http.StatusBadRequest},
+				{"/v1/matrices/v/lookup", `{"pos": -1}`, http.StatusBadRequest},
+				{"/v1/matrices/v/lookup", `{"pos": 1, "bogus": 1}`, http.StatusBadRequest},
+				{"/v1/matrices/v/lookup", `{"pos": 1} {}`, http.StatusBadRequest},
+				{"/v1/matrices/v/lookup", `{"op": "lookup", "pos": 1}`, http.StatusBadRequest},
+				{"/v1/matrices/v/rank", `{"pos": 1001, "value": 1}`, http.StatusBadRequest},
+				{"/v1/matrices/v/quantile", `{"beg": 5, "end": 5, "k": 0}`, http.StatusBadRequest},
+				{"/v1/matrices/v/quantile", `{"beg": 6, "end": 5, "k": 0}`, http.StatusBadRequest},
+				{"/v1/matrices/v/rangedrankrange", `{"beg": 0, "end": 5, "lo": 3, "hi": 1}`, http.StatusBadRequest},
+				{"/v1/matrices/v/rangedrankignorelsbs", `{"beg": 0, "end": 5, "value": 3, "ignore_bits": 65}`, http.StatusBadRequest},
+				{"/v1/matrices/v/intersect", `{"ranges": [{"beg": 0, "end": 3}], "k": 0}`, http.StatusBadRequest},
+				{"/v1/matrices/v/intersect", `{"ranges": [{"beg": 0, "end": 3000}], "k": 1}`, http.StatusBadRequest},
+				{"/v1/matrices/v/intersect", `{"ranges": [], "k": 1}`, http.StatusBadRequest},
+				{"/v1/matrices/v/lookup", `{"pos": 1` + strings.Repeat(" ", 2000) + `}`, http.StatusRequestEntityTooLarge},
+			}
+			for _, c := range cases {
+				status, ret := call(ts, "POST", c.path, c.body)
+				So(status, ShouldEqual, c.status)
+				So(ret["error"], ShouldNotBeEmpty)
+			}
+			status, _ := call(ts, "GET", "/v1/matrices/v/lookup", "")
+			So(status, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+		Convey("A batch should answer each query", func() {
+			status, ret := call(ts, "POST", "/v1/matrices/v/batch", `{"queries": [
+				{"op": "lookup", "pos": 10},
+				{"op": "lookup", "pos": 1000},
+				{"op": "missing"}
+			]}`)
+			So(status, ShouldEqual, http.StatusOK)
+			results := ret["results"].([]any)
+			So(results, ShouldHaveLength, 3)
+			So(results[0], ShouldResemble, map[string]any{"result": float64(wm.Lookup(10))})
+			So(results[1].(map[string]any)["error"], ShouldContainSubstring, "out of")
+			So(results[2].(map[string]any)["error"], ShouldContainSubstring, "unknown")
+
+			status, _ = call(ts, "POST", "/v1/matrices/v/batch", `{"queries": [{}, {}, {}, {}]}`)
+			So(status, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+
+	Convey("When the server is shut down", t, func() {
+		s := &server{maxBody: 1 << 10, maxBatch: 10}
+		s.setMatrices(map[string]*watrix.WaveletMatrix{"v": buildMatrix([]uint64{1, 2, 3})})
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- serve(ctx, ln, s, time.Second)
+		}()
+		resp, err := http.Post("http://"+ln.Addr().String()+"/v1/matrices/v/lookup", "application/json", bytes.NewReader([]byte(`{"pos": 2}`)))
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		cancel()
+		So(<-done, ShouldBeNil)
+		So(s.ready.Load(), ShouldBeFalse)
+	})
+
+	Convey("When matrices and bundles are loaded", t, func() {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "a.wm")
+		So(buildMatrix([]uint64{1, 2, 3}).MarshalBinaryFile(path), ShouldBeNil)
+		bundlePath := filepath.Join(dir, "b.wmb")
+		f, err := os.Create(bundlePath)
+		So(err, ShouldBeNil)
+		bw := watrix.NewBundleWriter(f)
+		So(bw.Add("b", buildMatrix([]uint64{4, 5})), ShouldBeNil)
+		So(bw.Close(), ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		matrices, err := loadMatrices([]string{"a=" + path}, []string{bundlePath})
+		So(err, ShouldBeNil)
+		So(matrices, ShouldHaveLength, 2)
+		So(matrices["b"].Lookup(1), ShouldEqual, 5)
+
+		_, err = loadMatrices([]string{"a=" + path, "a=" + path}, nil)
+		So(err, ShouldNotBeNil)
+		_, err = loadMatrices([]string{path}, nil)
+		So(err, ShouldNotBeNil)
+		_, err = loadMatrices([]string{"a=" + bundlePath}, nil)
+		So(err, ShouldNotBeNil)
+	})
+}
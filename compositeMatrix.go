@@ -0,0 +1,310 @@
+package watrix
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Field is a named column of a composite key, stored in Bits bits.
+type Field struct {
+	Name string
+	Bits uint64
+}
+
+// Schema packs tuples of fields into uint64 keys.
+//
+// The first field occupies the most significant bits, so the keys sharing
+// the leading fields (a prefix) form a contiguous range of values.  This
+// lets WaveletMatrix answer "where service = X" with one prefix search.
+type Schema struct {
+	fields []Field
+	shifts []uint64 // shifts[i]: the position of the LSB of fields[i]
+}
+
+// NewSchema returns Schema of the fields, from the most significant one.
+// The total width must be at most 64 bits.
+func NewSchema(fields ...Field) (*Schema, error) {
+	s := &Schema{
+		fields: append([]Field(nil), fields...),
+		shifts: make([]uint64, len(fields)),
+	}
+	total := uint64(0)
+	names := make(map[string]bool)
+	for i := len(fields) - 1; i >= 0; i-- {
+		f := fields[i]
+		if f.Bits == 0 {
+			return nil, fmt.Errorf("watrix: field %q has no bits", f.Name)
+		}
+		if names[f.Name] {
+			return nil, fmt.Errorf("watrix: duplicate field %q", f.Name)
+		}
+		names[f.Name] = true
+		s.shifts[i] = total
+		total += f.Bits
+		if total > 64 {
+			return nil, fmt.Errorf("watrix: fields need %d bits or more, which exceeds 64", total)
+		}
+	}
+	return s, nil
+}
+
+// Fields returns the fields of the schema.
+func (s *Schema) Fields() []Field {
+	return s.fields
+}
+
+// Index returns the index of the named field, or -1 if there is none.
+func (s *Schema) Index(name string) int {
+	for i, f := range s.fields {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Schema) mask(i int) uint64 {
+	return ^uint64(0) >> (64 - s.fields[i].Bits)
+}
+
+// packPrefix packs the leading len(prefix) fields.  It also returns the
+// number of bits of the remaining fields, and false if a value does not
+// fit in its field.
+func (s *Schema) packPrefix(prefix []uint64) (key uint64, ignoreBits uint64, ok bool) {
+	if len(prefix) > len(s.fields) {
+		return 0, 0, false
+	}
+	for i, val := range prefix {
+		if val > s.mask(i) {
+			return 0, 0, false
+		}
+		key |= val << s.shifts[i]
+	}
+	if len(prefix) > 0 {
+		ignoreBits = s.shifts[len(prefix)-1]
+	} else if len(s.fields) > 0 {
+		ignoreBits = s.shifts[0] + s.fields[0].Bits
+	}
+	return key, ignoreBits, true
+}
+
+// Pack packs one value per field into a key.
+func (s *Schema) Pack(vals ...uint64) (uint64, error) {
+	if len(vals) != len(s.fields) {
+		return 0, fmt.Errorf("watrix: %d values for %d fields", len(vals), len(s.fields))
+	}
+	for i, val := range vals {
+		if val > s.mask(i) {
+			return 0, fmt.Errorf("watrix: %d does not fit in field %q of %d bits", val, s.fields[i].Name, s.fields[i].Bits)
+		}
+	}
+	key, _, _ := s.packPrefix(vals)
+	return key, nil
+}
+
+// Unpack returns the values of the fields of key.
+func (s *Schema) Unpack(key uint64) []uint64 {
+	vals := make([]uint64, len(s.fields))
+	for i := range s.fields {
+		vals[i] = (key >> s.shifts[i]) & s.mask(i)
+	}
+	return vals
+}
+
+// Get returns the value of the named field of key.
+// It panics if there is no such field.
+func (s *Schema) Get(key uint64, name string) uint64 {
+	i := s.Index(name)
+	if i < 0 {
+		panic(fmt.Sprintf("watrix: unknown field %q", name))
+	}
+	return (key >> s.shifts[i]) & s.mask(i)
+}
+
+// CompositeBuilder builds CompositeMatrix from tuples.
+// A user calls PushBack()s followed by Build().
+type CompositeBuilder struct {
+	schema *Schema
+	wmb    *WaveletMatrixBuilder
+}
+
+// NewCompositeBuilder returns CompositeBuilder of the schema.
+func NewCompositeBuilder(schema *Schema) *CompositeBuilder {
+	return &CompositeBuilder{
+		schema: schema,
+		wmb:    NewBuilder(),
+	}
+}
+
+// PushBack appends a tuple with one value per field.
+func (cb *CompositeBuilder) PushBack(vals ...uint64) error {
+	key, err := cb.schema.Pack(vals...)
+	if err != nil {
+		return err
+	}
+	cb.wmb.PushBack(key)
+	return nil
+}
+
+// Build constructs CompositeMatrix data structure
+func (cb *CompositeBuilder) Build(opts ...BuildOption) *CompositeMatrix {
+	return &CompositeMatrix{
+		schema: cb.schema,
+		wm:     cb.wmb.Build(opts...),
+	}
+}
+
+// CompositeMatrix is a WaveletMatrix of tuples packed by a Schema.
+//
+// The queries take a prefix, i.e. the values of the leading fields, and
+// consider the tuples whose leading fields equal it.  An empty prefix
+// matches every tuple.  A prefix value that does not fit in its field
+// matches nothing.
+type CompositeMatrix struct {
+	schema *Schema
+	wm     *WaveletMatrix
+}
+
+// Schema returns the schema of the tuples.
+func (cm *CompositeMatrix) Schema() *Schema {
+	return cm.schema
+}
+
+// WaveletMatrix returns the underlying matrix storing the packed keys.
+func (cm *CompositeMatrix) WaveletMatrix() *WaveletMatrix {
+	return cm.wm
+}
+
+// Num return the number of tuples in T
+func (cm *CompositeMatrix) Num() uint64 {
+	return cm.wm.Num()
+}
+
+// Lookup returns the tuple T[pos]
+func (cm *CompositeMatrix) Lookup(pos uint64) []uint64 {
+	return cm.schema.Unpack(cm.wm.Lookup(pos))
+}
+
+// LookupField returns the named field of T[pos].
+// It panics if there is no such field.
+func (cm *CompositeMatrix) LookupField(pos uint64, name string) uint64 {
+	return cm.schema.Get(cm.wm.Lookup(pos), name)
+}
+
+// RangedRankPrefix returns the number of tuples in T[posRange.Beg, posRange.End)
+// whose leading fields equal prefix.
+func (cm *CompositeMatrix) RangedRankPrefix(posRange Range, prefix ...uint64) uint64 {
+	key, ignoreBits, ok := cm.schema.packPrefix(prefix)
+	if !ok {
+		return 0
+	}
+	if ignoreBits == 64 {
+		return posRange.End - posRange.Beg
+	}
+	return cm.wm.RangedRankIgnoreLSBs(posRange, key, ignoreBits)
+}
+
+// RangedRankPrefixRange returns the number of tuples in
+// T[posRange.Beg, posRange.End) whose leading fields equal prefix and whose
+// next field falls within valueRange i.e. [valueRange.Beg, valueRange.End).
+func (cm *CompositeMatrix) RangedRankPrefixRange(posRange Range, prefix []uint64, valueRange Range) uint64 {
+	key, _, ok := cm.schema.packPrefix(prefix)
+	if !ok || len(prefix) >= len(cm.schema.fields) {
+		return 0
+	}
+	next := len(prefix)
+	mask := cm.schema.mask(next)
+	if valueRange.Beg >= valueRange.End || valueRange.Beg > mask {
+		return 0
+	}
+	last := valueRange.End - 1
+	if last > mask {
+		last = mask
+	}
+	shift := cm.schema.shifts[next]
+	lo := key | valueRange.Beg<<shift
+	hi := key | last<<shift | (1<<shift - 1)
+	return posRange.End - posRange.Beg - cm.wm.RangedRankOp(posRange, lo, OpLessThan) - cm.wm.RangedRankOp(posRange, hi, OpMoreThan)
+}
+
+// RangedSelectPrefix returns the position of the (rank+1)-th tuple in
+// T[posRange.Beg, posRange.End) whose leading fields equal prefix.
+// If no match has been found, it returns posRange.End.
+func (cm *CompositeMatrix) RangedSelectPrefix(posRange Range, rank uint64, prefix ...uint64) uint64 {
+	key, ignoreBits, ok := cm.schema.packPrefix(prefix)
+	if !ok {
+		return posRange.End
+	}
+	if ignoreBits == 64 {
+		if posRange.Beg+rank < posRange.End {
+			return posRange.Beg + rank
+		}
+		return posRange.End
+	}
+	return cm.wm.RangedSelectIgnoreLSBs(posRange, rank, key, ignoreBits)
+}
+
+// QuantilePrefix returns the (k+1)th smallest tuple in
+// T[posRange.Beg, posRange.End) among those whose leading fields equal
+// prefix.  For a prefix of one field, the second value of the result is
+// the quantile of the second field where the first field equals prefix.
+// It returns false if there are k or less such tuples.
+func (cm *CompositeMatrix) QuantilePrefix(posRange Range, k uint64, prefix ...uint64) ([]uint64, bool) {
+	if k >= cm.RangedRankPrefix(posRange, prefix...) {
+		return nil, false
+	}
+	key, _, _ := cm.schema.packPrefix(prefix)
+	less := cm.wm.RangedRankOp(posRange, key, OpLessThan)
+	return cm.schema.Unpack(cm.wm.Quantile(posRange, less+k)), true
+}
+
+// MarshalBinary encodes CompositeMatrix, i.e. the fields followed by
+// the matrix, into the framed form and returns the result.
+func (cm *CompositeMatrix) MarshalBinary() ([]byte, error) {
+	fw := newFramedWriter(compositeMagic)
+	var fields []byte
+	for _, f := range cm.schema.fields {
+		fields = appendString(fields, f.Name)
+		fields = binary.LittleEndian.AppendUint64(fields, f.Bits)
+	}
+	fw.section(fields)
+	if err := fw.matrix(cm.wm); err != nil {
+		return nil, err
+	}
+	return fw.bytes(), nil
+}
+
+// UnmarshalBinary decodes CompositeMatrix from a binary form generated MarshalBinary.
+func (cm *CompositeMatrix) UnmarshalBinary(in []byte) (err error) {
+	defer recoverCorrupt(&err)
+	fr, err := newFramedReader(in, compositeMagic)
+	if err != nil {
+		return err
+	}
+	payload, err := fr.section()
+	if err != nil {
+		return err
+	}
+	pr := payloadReader{buf: payload, what: "the fields"}
+	var fields []Field
+	for len(pr.buf) > 0 && pr.err == nil {
+		f := Field{Name: pr.string(), Bits: pr.uint64()}
+		fields = append(fields, f)
+	}
+	if pr.err != nil {
+		return pr.err
+	}
+	schema, err := NewSchema(fields...)
+	if err != nil {
+		return err
+	}
+	wm, err := fr.matrix()
+	if err != nil {
+		return err
+	}
+	if err := fr.finish(); err != nil {
+		return err
+	}
+	cm.schema, cm.wm = schema, wm
+	return nil
+}
@@ -0,0 +1,114 @@
+package watrix
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSchema(t *testing.T) {
+	Convey("When a schema is defined", t, func() {
+		s, err := NewSchema(Field{"service", 8}, Field{"status", 10})
+		So(err, ShouldBeNil)
+		key, err := s.Pack(3, 503)
+		So(err, ShouldBeNil)
+		So(key, ShouldEqual, 3<<10|503)
+		So(s.Unpack(key), ShouldResemble, []uint64{3, 503})
+		So(s.Get(key, "status"), ShouldEqual, 503)
+		So(s.Index("service"), ShouldEqual, 0)
+		So(s.Index("missing"), ShouldEqual, -1)
+
+		_, err = s.Pack(256, 0)
+		So(err, ShouldNotBeNil)
+		_, err = s.Pack(1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("When a schema is invalid", t, func() {
+		_, err := NewSchema(Field{"a", 40}, Field{"b", 25})
+		So(err, ShouldNotBeNil)
+		_, err = NewSchema(Field{"a", 0})
+		So(err, ShouldNotBeNil)
+		_, err = NewSchema(Field{"a", 1}, Field{"a", 1})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCompositeMatrix(t *testing.T) {
+	for _, widths := range [][]uint64{{4, 10, 3}, {32, 8, 24}} {
+		Convey("When tuples are built", t, func() {
+			schema, err := NewSchema(Field{"service", widths[0]}, Field{"status", widths[1]}, Field{"region", widths[2]})
+			So(err, ShouldBeNil)
+			num := uint64(2000)
+			services := []uint64{0, 1, 7, 15, 1<<widths[0] - 1}
+			orig := make([][]uint64, num)
+			cb := NewCompositeBuilder(schema)
+			for i := range orig {
+				orig[i] = []uint64{
+					services[rand.Intn(len(services))],
+					uint64(rand.Int63n(int64(1 << widths[1]))),
+					uint64(rand.Int63n(int64(1 << widths[2]))),
+				}
+				So(cb.PushBack(orig[i]...), ShouldBeNil)
+			}
+			cm := cb.Build()
+
+			check := func(cm *CompositeMatrix) {
+				for i := 0; i < 30; i++ {
+					ranze := generateRange(num)
+					service := services[rand.Intn(len(services))]
+					status := orig[rand.Intn(int(num))][1]
+					So(cm.Lookup(ranze.Beg), ShouldResemble, orig[ranze.Beg])
+					So(cm.LookupField(ranze.Beg, "region"), ShouldEqual, orig[ranze.Beg][2])
+
+					bySvc, bySvcStatus, within := uint64(0), uint64(0), uint64(0)
+					statuses := make([]uint64, 0)
+					matches := make([]uint64, 0)
+					for j := ranze.Beg; j < ranze.End; j++ {
+						if orig[j][0] != service {
+							continue
+						}
+						bySvc++
+						statuses = append(statuses, orig[j][1])
+						matches = append(matches, j)
+						if orig[j][1] == status {
+							bySvcStatus++
+						}
+						if orig[j][1] >= status/2 && orig[j][1] < status+3 {
+							within++
+						}
+					}
+					So(cm.RangedRankPrefix(ranze), ShouldEqual, ranze.End-ranze.Beg)
+					So(cm.RangedRankPrefix(ranze, service), ShouldEqual, bySvc)
+					So(cm.RangedRankPrefix(ranze, service, status), ShouldEqual, bySvcStatus)
+					So(cm.RangedRankPrefixRange(ranze, []uint64{service}, Range{status / 2, status + 3}), ShouldEqual, within)
+					So(cm.RangedRankPrefixRange(ranze, []uint64{service}, Range{0, ^uint64(0)}), ShouldEqual, bySvc)
+					if bySvc > 0 {
+						k := uint64(rand.Int63n(int64(bySvc)))
+						So(cm.RangedSelectPrefix(ranze, k, service), ShouldEqual, matches[k])
+						sort.Slice(statuses, func(a, b int) bool { return statuses[a] < statuses[b] })
+						tuple, ok := cm.QuantilePrefix(ranze, k, service)
+						So(ok, ShouldBeTrue)
+						So(tuple[0], ShouldEqual, service)
+						So(tuple[1], ShouldEqual, statuses[k])
+					}
+					So(cm.RangedSelectPrefix(ranze, bySvc, service), ShouldEqual, ranze.End)
+					_, ok := cm.QuantilePrefix(ranze, bySvc, service)
+					So(ok, ShouldBeFalse)
+				}
+				So(cm.RangedRankPrefix(Range{0, num}, 1<<widths[0]), ShouldEqual, 0)
+			}
+			check(cm)
+
+			Convey("The schema and the matrix should be marshaled together", func() {
+				out, err := cm.MarshalBinary()
+				So(err, ShouldBeNil)
+				loaded := new(CompositeMatrix)
+				So(loaded.UnmarshalBinary(out), ShouldBeNil)
+				So(loaded.Schema().Fields(), ShouldResemble, schema.Fields())
+				check(loaded)
+			})
+		})
+	}
+}
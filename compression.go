@@ -0,0 +1,56 @@
+package watrix
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// EncodeOption configures Encode and MarshalBinaryFile.
+type EncodeOption func(*encodeConfig)
+
+type encodeConfig struct {
+	compress bool
+	level    int
+}
+
+// WithCompression compresses each layer with compress/flate at the given
+// level, such as flate.BestCompression or flate.DefaultCompression.
+// Each layer is compressed separately, so OpenLazy still reads only the
+// layers it touches.  Decoding detects the compression by itself.
+func WithCompression(level int) EncodeOption {
+	return func(conf *encodeConfig) {
+		conf.compress = true
+		conf.level = level
+	}
+}
+
+func compressLayer(payload []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressLayer decompresses a layer of at most max bytes.
+func decompressLayer(payload []byte, max int64) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(payload))
+	defer fr.Close()
+	out, err := io.ReadAll(io.LimitReader(fr, max+1))
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if int64(len(out)) > max {
+		return nil, fmt.Errorf("%w: a layer decompresses to more than %d bytes", ErrLimit, max)
+	}
+	return out, nil
+}
@@ -0,0 +1,155 @@
+package watrix
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// denseBitVector is an uncompressed BitVector that keeps the number of ones
+// before every word, so that Rank is one lookup and one popcount.
+// Select is a binary search over the counts.
+type denseBitVector struct {
+	words []uint64
+	ranks []uint64 // ranks[i]: the number of ones in words[0...i)
+	num   uint64
+}
+
+func newDenseBitVector() *denseBitVector {
+	return &denseBitVector{
+		words: make([]uint64, 0),
+		ranks: []uint64{0},
+	}
+}
+
+// PushBack appends the bit to the end of B
+func (bv *denseBitVector) PushBack(bit bool) {
+	if bv.num%64 == 0 {
+		bv.words = append(bv.words, 0)
+		bv.ranks = append(bv.ranks, bv.ranks[len(bv.ranks)-1])
+	}
+	if bit {
+		bv.words[bv.num/64] |= 1 << (bv.num % 64)
+		bv.ranks[len(bv.ranks)-1]++
+	}
+	bv.num++
+}
+
+// Build returns bv itself.
+func (bv *denseBitVector) Build() BitVector {
+	return bv
+}
+
+func (bv *denseBitVector) Num() uint64 {
+	return bv.num
+}
+
+func (bv *denseBitVector) OneNum() uint64 {
+	return bv.ranks[len(bv.ranks)-1]
+}
+
+func (bv *denseBitVector) ZeroNum() uint64 {
+	return bv.num - bv.OneNum()
+}
+
+func (bv *denseBitVector) Bit(pos uint64) bool {
+	return (bv.words[pos/64]>>(pos%64))&1 == 1
+}
+
+func (bv *denseBitVector) Rank(pos uint64, bit bool) uint64 {
+	ones := bv.ranks[pos/64]
+	if r := pos % 64; r > 0 {
+		ones += uint64(bits.OnesCount64(bv.words[pos/64] & ((1 << r) - 1)))
+	}
+	if bit {
+		return ones
+	}
+	return pos - ones
+}
+
+func (bv *denseBitVector) Select(rank uint64, bit bool) uint64 {
+	count := func(i int) uint64 {
+		if bit {
+			return bv.ranks[i]
+		}
+		return uint64(i)*64 - bv.ranks[i]
+	}
+	if bit && rank >= bv.OneNum() || !bit && rank >= bv.ZeroNum() {
+		return bv.num
+	}
+	// the last word i with count(i) <= rank
+	lo, hi := 0, len(bv.words)
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if count(mid) <= rank {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	word := bv.words[lo]
+	if !bit {
+		word = ^word
+	}
+	return uint64(lo)*64 + selectInWord(word, rank-count(lo))
+}
+
+// selectInWord returns the position of the (rank+1)-th one in word.
+func selectInWord(word uint64, rank uint64) uint64 {
+	for ; rank > 0; rank-- {
+		word &= word - 1
+	}
+	return uint64(bits.TrailingZeros64(word))
+}
+
+// MarshalBinary encodes the bit vector as little-endian num followed by
+// the words.  The counts are rebuilt by UnmarshalBinary.
+func (bv *denseBitVector) MarshalBinary() ([]byte, error) {
+	return marshalWords(bv.num, bv.words), nil
+}
+
+// UnmarshalBinary decodes the bit vector from a binary form generated MarshalBinary.
+func (bv *denseBitVector) UnmarshalBinary(in []byte) error {
+	num, words, err := unmarshalWords(in)
+	if err != nil {
+		return err
+	}
+	bv.num = num
+	bv.words = words
+	bv.ranks = make([]uint64, len(words)+1)
+	for i, word := range words {
+		bv.ranks[i+1] = bv.ranks[i] + uint64(bits.OnesCount64(word))
+	}
+	return nil
+}
+
+// marshalWords encodes num bits in words as little-endian num followed by
+// the words.
+func marshalWords(num uint64, words []uint64) []byte {
+	out := make([]byte, 8+8*len(words))
+	binary.LittleEndian.PutUint64(out, num)
+	for i, word := range words {
+		binary.LittleEndian.PutUint64(out[8+8*i:], word)
+	}
+	return out
+}
+
+// unmarshalWords decodes a binary form generated by marshalWords.
+// The bits after num in the last word are cleared.
+func unmarshalWords(in []byte) (uint64, []uint64, error) {
+	if len(in) < 8 {
+		return 0, nil, errors.New("watrix: bit vector is too short")
+	}
+	num := binary.LittleEndian.Uint64(in)
+	if uint64(len(in)-8)/8 != (num+63)/64 || len(in)%8 != 0 {
+		return 0, nil, errors.New("watrix: bit vector has a wrong length")
+	}
+	words := make([]uint64, (num+63)/64)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(in[8+8*i:])
+	}
+	if r := num % 64; r > 0 {
+		words[len(words)-1] &= (1 << r) - 1
+	}
+	return num, words, nil
+}
@@ -0,0 +1,179 @@
+package watrix
+
+import (
+	"math"
+)
+
+// canonicalNaN is the single NaN value stored by FloatMatrix.
+var canonicalNaN = math.Float64frombits(0x7FF8000000000001)
+
+// encodeFloat maps f to uint64 so that the order of the results matches
+// the numeric order of the floats.
+//
+// Both zeros are encoded as +0, and every NaN is encoded as a single value
+// that orders after +Inf.
+func encodeFloat(f float64) uint64 {
+	if f != f {
+		f = canonicalNaN
+	} else if f == 0 {
+		f = 0 // -0 == 0, so this replaces -0 with +0
+	}
+	bits := math.Float64bits(f)
+	if bits>>63 == 1 {
+		return ^bits
+	}
+	return bits | (1 << 63)
+}
+
+// decodeFloat is the inverse of encodeFloat.
+func decodeFloat(code uint64) float64 {
+	if code>>63 == 1 {
+		return math.Float64frombits(code &^ (1 << 63))
+	}
+	return math.Float64frombits(^code)
+}
+
+// FloatBuilder builds FloatMatrix from a float64 array.
+// A user calls PushBack()s followed by Build().
+type FloatBuilder struct {
+	wmb *WaveletMatrixBuilder
+}
+
+// NewFloatBuilder returns FloatBuilder
+func NewFloatBuilder() *FloatBuilder {
+	return &FloatBuilder{NewBuilder()}
+}
+
+// PushBack append a value to the builder.
+func (b *FloatBuilder) PushBack(val float64) {
+	b.wmb.PushBack(encodeFloat(val))
+}
+
+// Build constructs FloatMatrix data structure.
+// Since encoded floats use all 64 bits, WithCompactAlphabet is usually
+// worth passing when the number of distinct values is small.
+func (b *FloatBuilder) Build(opts ...BuildOption) *FloatMatrix {
+	return &FloatMatrix{b.wmb.Build(opts...)}
+}
+
+// FloatMatrix is a WaveletMatrix storing float64 values.
+//
+// Values are stored with an order-preserving transform of their IEEE-754
+// bits, with the following rules:
+//
+//   - -0 is stored as +0, so Lookup never returns -0 and Rank(pos, 0) counts both zeros.
+//   - Every NaN is stored as a single canonical NaN that orders after +Inf.
+//     Rank(pos, NaN) counts NaNs, RankLessThan(pos, NaN) counts every non-NaN
+//     value, and Quantile returns NaN only after all the other values.
+type FloatMatrix struct {
+	wm *WaveletMatrix
+}
+
+// NewFloatMatrix wraps wm, whose values must have been encoded by a FloatBuilder.
+func NewFloatMatrix(wm *WaveletMatrix) *FloatMatrix {
+	return &FloatMatrix{wm}
+}
+
+// WaveletMatrix returns the underlying matrix storing the encoded values.
+func (fm *FloatMatrix) WaveletMatrix() *WaveletMatrix {
+	return fm.wm
+}
+
+// Num return the number of values in T
+func (fm *FloatMatrix) Num() uint64 {
+	return fm.wm.Num()
+}
+
+// Lookup returns T[pos]
+func (fm *FloatMatrix) Lookup(pos uint64) float64 {
+	return decodeFloat(fm.wm.Lookup(pos))
+}
+
+// Rank returns the number of c (== val) in T[0...pos)
+func (fm *FloatMatrix) Rank(pos uint64, val float64) uint64 {
+	return fm.wm.Rank(pos, encodeFloat(val))
+}
+
+// RankLessThan returns the number of c (< val) in T[0...pos)
+func (fm *FloatMatrix) RankLessThan(pos uint64, val float64) uint64 {
+	return fm.wm.RankLessThan(pos, encodeFloat(val))
+}
+
+// RankMoreThan returns the number of c (> val) in T[0...pos)
+func (fm *FloatMatrix) RankMoreThan(pos uint64, val float64) uint64 {
+	return fm.wm.RankMoreThan(pos, encodeFloat(val))
+}
+
+// RangedRankRange searches T[posRange.Beg, posRange.End) and
+// returns the number of c that falls within [beg, end).
+func (fm *FloatMatrix) RangedRankRange(posRange Range, beg, end float64) uint64 {
+	b, e := encodeFloat(beg), encodeFloat(end)
+	if e <= b {
+		return 0
+	}
+	return fm.wm.RangedRankRange(posRange, Range{b, e})
+}
+
+// Select returns the position of (rank+1)-th val in T.
+// If no match has been found, it returns Num().
+func (fm *FloatMatrix) Select(rank uint64, val float64) uint64 {
+	return fm.wm.Select(rank, encodeFloat(val))
+}
+
+// Quantile returns (k+1)th smallest value in T[posRange.Beg, posRange.End).
+func (fm *FloatMatrix) Quantile(posRange Range, k uint64) float64 {
+	return decodeFloat(fm.wm.Quantile(posRange, k))
+}
+
+// Predecessor returns the largest value in T[posRange) that is less than or
+// equal to val.  ok is false if there is no such value.
+func (fm *FloatMatrix) Predecessor(posRange Range, val float64) (pred float64, ok bool) {
+	code := encodeFloat(val)
+	count := posRange.End - posRange.Beg
+	if code < math.MaxUint64 {
+		count = fm.wm.RangedRankOp(posRange, code+1, OpLessThan)
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return fm.Quantile(posRange, count-1), true
+}
+
+// Successor returns the smallest value in T[posRange) that is greater than or
+// equal to val.  ok is false if there is no such value.
+func (fm *FloatMatrix) Successor(posRange Range, val float64) (succ float64, ok bool) {
+	count := fm.wm.RangedRankOp(posRange, encodeFloat(val), OpLessThan)
+	if count >= posRange.End-posRange.Beg {
+		return 0, false
+	}
+	return fm.Quantile(posRange, count), true
+}
+
+// Extract decodes T[posRange) into dst and returns dst[:posRange.End-posRange.Beg].
+// If dst is too short, a new slice is allocated.
+func (fm *FloatMatrix) Extract(posRange Range, dst []float64) []float64 {
+	codes := fm.wm.Extract(posRange, nil)
+	if dst == nil || cap(dst) < len(codes) {
+		dst = make([]float64, len(codes))
+	}
+	dst = dst[:len(codes)]
+	for i, code := range codes {
+		dst[i] = decodeFloat(code)
+	}
+	return dst
+}
+
+// MarshalBinary encodes FloatMatrix into a binary form and returns the result.
+func (fm *FloatMatrix) MarshalBinary() ([]byte, error) {
+	return fm.wm.MarshalBinary()
+}
+
+// UnmarshalBinary decodes FloatMatrix from a binary form generated MarshalBinary.
+func (fm *FloatMatrix) UnmarshalBinary(in []byte) error {
+	wm := new(WaveletMatrix)
+	if err := wm.UnmarshalBinary(in); err != nil {
+		return err
+	}
+	fm.wm = wm
+	return nil
+}
@@ -0,0 +1,111 @@
+package watrix
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFloatEncoding(t *testing.T) {
+	Convey("The encoding should preserve the order of floats", t, func() {
+		vals := []float64{math.Inf(-1), -math.MaxFloat64, -1.5, -math.SmallestNonzeroFloat64, 0,
+			math.SmallestNonzeroFloat64, 1, 2.5, math.MaxFloat64, math.Inf(1)}
+		for i := 1; i < len(vals); i++ {
+			So(encodeFloat(vals[i-1]), ShouldBeLessThan, encodeFloat(vals[i]))
+		}
+		for _, v := range vals {
+			So(decodeFloat(encodeFloat(v)), ShouldEqual, v)
+		}
+		So(encodeFloat(math.Copysign(0, -1)), ShouldEqual, encodeFloat(0))
+		So(encodeFloat(math.NaN()), ShouldBeGreaterThan, encodeFloat(math.Inf(1)))
+		So(encodeFloat(math.Float64frombits(0xFFF8000000000000)), ShouldEqual, encodeFloat(math.NaN()))
+		So(math.IsNaN(decodeFloat(encodeFloat(math.NaN()))), ShouldBeTrue)
+	})
+}
+
+func TestFloatMatrix(t *testing.T) {
+	Convey("When float values are built", t, func() {
+		num := uint64(1000)
+		orig := make([]float64, num)
+		b := NewFloatBuilder()
+		for i := range orig {
+			orig[i] = math.Round(rand.NormFloat64()*100) / 10
+			b.PushBack(orig[i])
+		}
+		fm := b.Build()
+		sorted := func(r Range) []float64 {
+			vs := append([]float64(nil), orig[r.Beg:r.End]...)
+			sort.Float64s(vs)
+			return vs
+		}
+		for i := 0; i < 20; i++ {
+			ranze := generateRange(num)
+			x := orig[rand.Intn(int(num))]
+			vs := sorted(ranze)
+			So(fm.Lookup(ranze.Beg), ShouldEqual, orig[ranze.Beg])
+			less := uint64(sort.SearchFloat64s(vs, x))
+			So(fm.RangedRankRange(ranze, math.Inf(-1), x), ShouldEqual, less)
+			within := uint64(sort.SearchFloat64s(vs, 1) - sort.SearchFloat64s(vs, -1))
+			So(fm.RangedRankRange(ranze, -1, 1), ShouldEqual, within)
+			if len(vs) == 0 {
+				continue
+			}
+			k := uint64(rand.Intn(len(vs)))
+			So(fm.Quantile(ranze, k), ShouldEqual, vs[k])
+
+			pred, ok := fm.Predecessor(ranze, x)
+			idx := sort.Search(len(vs), func(i int) bool { return vs[i] > x })
+			So(ok, ShouldEqual, idx > 0)
+			if ok {
+				So(pred, ShouldEqual, vs[idx-1])
+			}
+			succ, ok := fm.Successor(ranze, x)
+			idx = sort.SearchFloat64s(vs, x)
+			So(ok, ShouldEqual, idx < len(vs))
+			if ok {
+				So(succ, ShouldEqual, vs[idx])
+			}
+		}
+		So(fm.Extract(Range{0, num}, nil), ShouldResemble, orig)
+	})
+	Convey("When special values are built", t, func() {
+		b := NewFloatBuilder()
+		for _, v := range []float64{math.NaN(), -2, math.Copysign(0, -1), math.Inf(1), 0, math.NaN()} {
+			b.PushBack(v)
+		}
+		fm := b.Build(WithCompactAlphabet())
+		So(fm.Rank(6, 0), ShouldEqual, 2)
+		So(math.Signbit(fm.Lookup(2)), ShouldBeFalse)
+		So(fm.Rank(6, math.NaN()), ShouldEqual, 2)
+		So(fm.RankLessThan(6, math.NaN()), ShouldEqual, 4)
+		So(fm.RankLessThan(6, math.Inf(1)), ShouldEqual, 3)
+		So(fm.RankMoreThan(6, math.Inf(1)), ShouldEqual, 2)
+		So(fm.Quantile(Range{0, 6}, 3), ShouldEqual, math.Inf(1))
+		So(math.IsNaN(fm.Quantile(Range{0, 6}, 4)), ShouldBeTrue)
+		So(fm.Select(1, math.NaN()), ShouldEqual, 5)
+
+		pred, ok := fm.Predecessor(Range{0, 6}, -3)
+		So(ok, ShouldBeFalse)
+		pred, ok = fm.Predecessor(Range{0, 6}, 1)
+		So(ok, ShouldBeTrue)
+		So(pred, ShouldEqual, 0)
+		_, ok = fm.Successor(Range{1, 5}, 1e300)
+		So(ok, ShouldBeTrue)
+		_, ok = fm.Successor(Range{1, 3}, 1e300)
+		So(ok, ShouldBeFalse)
+	})
+	Convey("When only negative values are built", t, func() {
+		b := NewFloatBuilder()
+		for _, v := range []float64{-3, -1, -2} {
+			b.PushBack(v)
+		}
+		fm := b.Build()
+		So(fm.RankLessThan(3, 1), ShouldEqual, 3)
+		So(fm.RankMoreThan(3, 1), ShouldEqual, 0)
+		So(fm.Rank(3, 1), ShouldEqual, 0)
+		So(fm.Select(0, 1), ShouldEqual, 3)
+	})
+}
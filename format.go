@@ -0,0 +1,326 @@
+package watrix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// The container form of WaveletMatrix is
+//
+//	magic    [8]byte  formatMagic
+//	version  uint32   formatVersion
+//	header   section  flags uint32, backend uint32, dim, num, blen, layerNum uint64
+//	layers   section  each layer as marshalPortable, layerNum times,
+//	                  compressed with compress/flate if flagFlate is set
+//	alphabet section  the sorted values as uint64s, if flagCompacted is set
+//	index    section  the offsets of the layers and the alphabet as uint64s
+//	trailer  uint64   the offset of the index, followed by formatTrailerMagic
+//
+// where a section is its length as uint64, the payload and the CRC-32
+// (IEEE) of the payload as uint32.  The offsets are from the magic, and the
+// offset of the alphabet is 0 if there is none.  All integers are
+// little-endian.  See FORMAT.md for the details.
+
+var (
+	formatMagic        = [8]byte{0x89, 'W', 'M', 'X', '\r', '\n', 0x1a, '\n'}
+	formatTrailerMagic = [8]byte{'W', 'M', 'X', 'I', 'N', 'D', 'E', 'X'}
+)
+
+const (
+	formatVersion = 1
+
+	formatPreambleLen = 12
+	formatHeaderLen   = 40
+	formatTrailerLen  = 16
+
+	flagCompacted = 1 << 0 // the alphabet section follows the layers
+	flagFlate     = 1 << 1 // the layers are compressed with compress/flate
+	knownFlags    = flagCompacted | flagFlate
+)
+
+var (
+	// ErrBadMagic is returned when the input is not a serialized WaveletMatrix.
+	ErrBadMagic = errors.New("watrix: bad magic number")
+	// ErrUnsupportedVersion is returned when the input was written in a
+	// format version this package cannot read.
+	ErrUnsupportedVersion = errors.New("watrix: unsupported format version")
+	// ErrChecksum is returned when a section does not match its checksum.
+	ErrChecksum = errors.New("watrix: checksum mismatch")
+)
+
+// WriteTo writes wm in the container form to w.
+// It returns the number of bytes written.
+func (wm *WaveletMatrix) WriteTo(w io.Writer) (int64, error) {
+	return wm.Encode(w)
+}
+
+// Encode writes wm in the container form to w with the options.
+// It returns the number of bytes written.
+func (wm *WaveletMatrix) Encode(w io.Writer, opts ...EncodeOption) (int64, error) {
+	var conf encodeConfig
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	cw := &countingWriter{w: w}
+	err := wm.writeContainer(cw, conf)
+	return cw.n, err
+}
+
+// ReadFrom reads wm written by WriteTo from r, and validates it.
+// It returns the number of bytes read.  wm is left unchanged on an error.
+//
+// It also accepts the legacy form written by earlier versions, which has
+// no length and is read to the end of r.  The input is bounded by
+// DefaultDecodeLimits; see ReadFromLimited.
+func (wm *WaveletMatrix) ReadFrom(r io.Reader) (int64, error) {
+	return wm.ReadFromLimited(r, DefaultDecodeLimits)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// writeContainer writes wm in the container form to w.
+func (wm *WaveletMatrix) writeContainer(w *countingWriter, conf encodeConfig) error {
+	base := w.n
+	preamble := make([]byte, formatPreambleLen)
+	copy(preamble, formatMagic[:])
+	binary.LittleEndian.PutUint32(preamble[len(formatMagic):], formatVersion)
+	if _, err := w.Write(preamble); err != nil {
+		return err
+	}
+
+	flags := uint32(0)
+	if wm.alphabet != nil {
+		flags |= flagCompacted
+	}
+	if conf.compress {
+		flags |= flagFlate
+	}
+	header := make([]byte, formatHeaderLen)
+	binary.LittleEndian.PutUint32(header[0:], flags)
+	binary.LittleEndian.PutUint32(header[4:], uint32(wm.backend))
+	binary.LittleEndian.PutUint64(header[8:], wm.dim)
+	binary.LittleEndian.PutUint64(header[16:], wm.num)
+	binary.LittleEndian.PutUint64(header[24:], wm.blen)
+	binary.LittleEndian.PutUint64(header[32:], uint64(len(wm.layers)))
+	if err := writeSection(w, header); err != nil {
+		return err
+	}
+
+	index := make([]byte, 0, 8*(len(wm.layers)+1))
+	for _, layer := range wm.layers {
+		payload, err := marshalPortable(layer)
+		if err != nil {
+			return err
+		}
+		if conf.compress {
+			if payload, err = compressLayer(payload, conf.level); err != nil {
+				return err
+			}
+		}
+		index = binary.LittleEndian.AppendUint64(index, uint64(w.n-base))
+		if err := writeSection(w, payload); err != nil {
+			return err
+		}
+	}
+
+	if wm.alphabet != nil {
+		index = binary.LittleEndian.AppendUint64(index, uint64(w.n-base))
+		payload := make([]byte, 8*len(wm.alphabet))
+		for i, val := range wm.alphabet {
+			binary.LittleEndian.PutUint64(payload[8*i:], val)
+		}
+		if err := writeSection(w, payload); err != nil {
+			return err
+		}
+	} else {
+		index = binary.LittleEndian.AppendUint64(index, 0)
+	}
+
+	trailer := make([]byte, formatTrailerLen)
+	binary.LittleEndian.PutUint64(trailer, uint64(w.n-base))
+	copy(trailer[8:], formatTrailerMagic[:])
+	if err := writeSection(w, index); err != nil {
+		return err
+	}
+	_, err := w.Write(trailer)
+	return err
+}
+
+// readContainer reads wm in the container form from r.
+func (wm *WaveletMatrix) readContainer(r io.Reader, limits DecodeLimits) error {
+	if err := readPreamble(r); err != nil {
+		return err
+	}
+	header, err := readSection(r)
+	if err != nil {
+		return err
+	}
+	flags, layerNum, err := wm.parseHeader(header, limits)
+	if err != nil {
+		return err
+	}
+	maxLayerBytes := maxLayerBytes(wm.num)
+
+	rawLayers := make([][]byte, layerNum)
+	for i := range rawLayers {
+		rawLayers[i], err = readSection(r)
+		if err != nil {
+			return err
+		}
+		if flags&flagFlate != 0 {
+			if rawLayers[i], err = decompressLayer(rawLayers[i], maxLayerBytes); err != nil {
+				return err
+			}
+		}
+	}
+
+	wm.alphabet = nil
+	if flags&flagCompacted != 0 {
+		payload, err := readSection(r)
+		if err != nil {
+			return err
+		}
+		if wm.alphabet, err = parseAlphabet(payload); err != nil {
+			return err
+		}
+	}
+
+	// The index is for random access; skip it.
+	if _, err := readSection(r); err != nil {
+		return err
+	}
+	trailer := make([]byte, formatTrailerLen)
+	if _, err := io.ReadFull(r, trailer); err != nil {
+		return unexpectedEOF(err)
+	}
+	if !bytes.Equal(trailer[8:], formatTrailerMagic[:]) {
+		return ErrBadMagic
+	}
+	return wm.unmarshalLayers(rawLayers, true)
+}
+
+// readPreamble reads the magic and checks the version.
+func readPreamble(r io.Reader) error {
+	return readMagic(r, formatMagic)
+}
+
+// readMagic reads magic followed by formatVersion.
+func readMagic(r io.Reader, magic [8]byte) error {
+	preamble := make([]byte, formatPreambleLen)
+	if _, err := io.ReadFull(r, preamble); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrBadMagic
+		}
+		return err
+	}
+	if !bytes.Equal(preamble[:len(magic)], magic[:]) {
+		return ErrBadMagic
+	}
+	if version := binary.LittleEndian.Uint32(preamble[len(magic):]); version != formatVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	return nil
+}
+
+// parseHeader sets the fields of wm but the layers and the alphabet from
+// the header within limits, and returns the flags and the number of layers.
+func (wm *WaveletMatrix) parseHeader(header []byte, limits DecodeLimits) (uint32, uint64, error) {
+	if len(header) != formatHeaderLen {
+		return 0, 0, fmt.Errorf("watrix: header has %d bytes, want %d", len(header), formatHeaderLen)
+	}
+	flags := binary.LittleEndian.Uint32(header[0:])
+	if flags&^knownFlags != 0 {
+		return 0, 0, fmt.Errorf("watrix: unknown flags %#x", flags&^knownFlags)
+	}
+	wm.backend = Backend(binary.LittleEndian.Uint32(header[4:]))
+	wm.dim = binary.LittleEndian.Uint64(header[8:])
+	wm.num = binary.LittleEndian.Uint64(header[16:])
+	wm.blen = binary.LittleEndian.Uint64(header[24:])
+	layerNum := binary.LittleEndian.Uint64(header[32:])
+	if layerNum != wm.blen {
+		return 0, 0, fmt.Errorf("watrix: %d layers for blen %d", layerNum, wm.blen)
+	}
+	if err := limits.check(layerNum, wm.num); err != nil {
+		return 0, 0, err
+	}
+	return flags, layerNum, nil
+}
+
+func parseAlphabet(payload []byte) ([]uint64, error) {
+	if len(payload)%8 != 0 {
+		return nil, fmt.Errorf("watrix: alphabet has %d bytes", len(payload))
+	}
+	alphabet := make([]uint64, len(payload)/8)
+	for i := range alphabet {
+		alphabet[i] = binary.LittleEndian.Uint64(payload[8*i:])
+	}
+	return alphabet, nil
+}
+
+// writeSection writes the length, payload and checksum of a section to w.
+func writeSection(w io.Writer, payload []byte) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(payload)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(buf[:], crc32.ChecksumIEEE(payload))
+	_, err := w.Write(buf[:4])
+	return err
+}
+
+// readSection reads a section written by writeSection from r and returns
+// its payload.
+func readSection(r io.Reader) ([]byte, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	payload, err := readPayload(r, binary.LittleEndian.Uint64(buf[:]))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if binary.LittleEndian.Uint32(buf[:4]) != crc32.ChecksumIEEE(payload) {
+		return nil, ErrChecksum
+	}
+	return payload, nil
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF; a section never
+// ends the input before it is complete.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+package watrix
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFormat(t *testing.T) {
+	Convey("When a matrix is marshaled", t, func() {
+		orig := make([]uint64, 1000)
+		for i := range orig {
+			orig[i] = uint64(i) * 7 % 100
+		}
+		wm := buildFromSlice(orig)
+		out, err := wm.MarshalBinary()
+		So(err, ShouldBeNil)
+		So(out[:len(formatMagic)], ShouldResemble, formatMagic[:])
+
+		Convey("It should be read back", func() {
+			loaded := new(WaveletMatrix)
+			So(loaded.UnmarshalBinary(out), ShouldBeNil)
+			So(loaded, shouldEqualMatrix, wm)
+		})
+		Convey("A foreign input should be rejected", func() {
+			So(new(WaveletMatrix).UnmarshalBinary([]byte("\x89PNG\r\n\x1a\n....")), ShouldEqual, ErrBadMagic)
+			So(new(WaveletMatrix).UnmarshalBinary(out[:3]), ShouldEqual, ErrBadMagic)
+		})
+		Convey("A newer version should be rejected", func() {
+			bad := append([]byte(nil), out...)
+			binary.LittleEndian.PutUint32(bad[len(formatMagic):], formatVersion+1)
+			So(errors.Is(new(WaveletMatrix).UnmarshalBinary(bad), ErrUnsupportedVersion), ShouldBeTrue)
+		})
+		Convey("A corrupt section should be detected", func() {
+			bad := append([]byte(nil), out...)
+			// the first byte of the first layer, after the preamble, the
+			// header section and the length of the layer section
+			bad[len(formatMagic)+4+8+formatHeaderLen+4+8] ^= 0x10
+			So(new(WaveletMatrix).UnmarshalBinary(bad), ShouldEqual, ErrChecksum)
+		})
+		Convey("A truncated input should be detected", func() {
+			So(new(WaveletMatrix).UnmarshalBinary(out[:len(out)-1]), ShouldEqual, io.ErrUnexpectedEOF)
+		})
+		Convey("A failed read should leave the receiver unchanged", func() {
+			other := buildFromSlice([]uint64{5, 3, 1 << 40})
+			bad := append([]byte(nil), out...)
+			bad[len(formatMagic)+4+8+formatHeaderLen+4+8] ^= 0x10
+			So(other.UnmarshalBinary(bad), ShouldEqual, ErrChecksum)
+			So(other, shouldEqualMatrix, buildFromSlice([]uint64{5, 3, 1 << 40}))
+			So(other.UnmarshalBinary(out[:len(out)-1]), ShouldNotBeNil)
+			So(other.Compacted(), ShouldBeFalse)
+			So(other.Dim(), ShouldEqual, 1<<40+1)
+		})
+	})
+	Convey("When a file in the legacy form is read", t, func() {
+		name := "testdata/legacy.bin"
+		in, err := os.ReadFile(name)
+		So(err, ShouldBeNil)
+		fromBytes := new(WaveletMatrix)
+		So(fromBytes.UnmarshalBinary(in), ShouldBeNil)
+		fromFile := new(WaveletMatrix)
+		So(fromFile.UnmarshalBinaryFile(name), ShouldBeNil)
+		So(fromFile, shouldEqualMatrix, fromBytes)
+		So(fromBytes.Compacted(), ShouldBeFalse)
+		So(fromBytes.backend, ShouldEqual, BackendRSDic)
+		for i := uint64(0); i < 1000; i++ {
+			So(fromBytes.Lookup(i), ShouldEqual, i*7%100)
+		}
+	})
+}
+
+type failingWriter struct {
+	left int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.left {
+		n := fw.left
+		fw.left = 0
+		return n, errors.New("failingWriter: no space")
+	}
+	fw.left -= len(p)
+	return len(p), nil
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	Convey("When matrices are streamed", t, func() {
+		a := buildFromSlice([]uint64{3, 1, 4, 1, 5, 9, 2, 6})
+		wmb := NewBuilder()
+		for _, val := range []uint64{1 << 50, 7, 1 << 50, 8} {
+			wmb.PushBack(val)
+		}
+		b := wmb.Build(WithCompactAlphabet())
+
+		Convey("They should be read back one after another through gzip", func() {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			na, err := a.WriteTo(zw)
+			So(err, ShouldBeNil)
+			nb, err := b.WriteTo(zw)
+			So(err, ShouldBeNil)
+			So(zw.Close(), ShouldBeNil)
+
+			zr, err := gzip.NewReader(&buf)
+			So(err, ShouldBeNil)
+			loadedA, loadedB := new(WaveletMatrix), new(WaveletMatrix)
+			n, err := loadedA.ReadFrom(zr)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, na)
+			n, err = loadedB.ReadFrom(zr)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, nb)
+			So(loadedA, shouldEqualMatrix, a)
+			So(loadedB, shouldEqualMatrix, b)
+			So(loadedB.Lookup(0), ShouldEqual, 1<<50)
+		})
+		Convey("The count should match MarshalBinary", func() {
+			out, err := a.MarshalBinary()
+			So(err, ShouldBeNil)
+			n, err := a.WriteTo(io.Discard)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(out))
+		})
+		Convey("Every write error should be reported", func() {
+			out, _ := a.MarshalBinary()
+			for _, left := range []int{0, 5, len(out) / 2, len(out) - 1} {
+				n, err := a.WriteTo(&failingWriter{left})
+				So(err, ShouldNotBeNil)
+				So(n, ShouldEqual, left)
+			}
+			if _, err := os.Stat("/dev/full"); err == nil {
+				So(a.MarshalBinaryFile("/dev/full"), ShouldNotBeNil)
+			}
+		})
+		Convey("An empty input should be rejected", func() {
+			_, err := new(WaveletMatrix).ReadFrom(bytes.NewReader(nil))
+			So(err, ShouldEqual, ErrBadMagic)
+		})
+	})
+}
+
+func TestCompression(t *testing.T) {
+	Convey("When a skewed matrix is compressed", t, func() {
+		wmb := NewBuilder()
+		orig := make([]uint64, 20000)
+		for i := range orig {
+			// mostly small values with a few large ones
+			orig[i] = uint64(rand.ExpFloat64() * 3)
+			if rand.Intn(100) == 0 {
+				orig[i] = uint64(rand.Int63n(1 << 20))
+			}
+			wmb.PushBack(orig[i])
+		}
+		for _, backend := range []Backend{BackendRSDic, BackendPlain} {
+			wm := wmb.Build(WithBackend(backend))
+			var plain, compressed bytes.Buffer
+			_, err := wm.WriteTo(&plain)
+			So(err, ShouldBeNil)
+			_, err = wm.Encode(&compressed, WithCompression(flate.BestCompression))
+			So(err, ShouldBeNil)
+			if backend == BackendRSDic {
+				// rsdic is compressed already
+				So(compressed.Len(), ShouldBeLessThan, plain.Len())
+			} else {
+				So(compressed.Len()*2, ShouldBeLessThan, plain.Len())
+			}
+
+			loaded := new(WaveletMatrix)
+			So(loaded.UnmarshalBinary(compressed.Bytes()), ShouldBeNil)
+			So(loaded, shouldEqualMatrix, wm)
+
+			lazy, err := OpenLazy(bytes.NewReader(compressed.Bytes()), int64(compressed.Len()))
+			So(err, ShouldBeNil)
+			loaded, err = lazy.Load()
+			So(err, ShouldBeNil)
+			So(loaded, shouldEqualMatrix, wm)
+
+			path := filepath.Join(t.TempDir(), "compressed.bin")
+			So(wm.MarshalBinaryFile(path, WithCompression(flate.DefaultCompression)), ShouldBeNil)
+			loaded = new(WaveletMatrix)
+			So(loaded.UnmarshalBinaryFile(path), ShouldBeNil)
+			So(loaded, shouldEqualMatrix, wm)
+		}
+	})
+	Convey("When the level is invalid", t, func() {
+		wm := buildFromSlice([]uint64{1, 2, 3})
+		_, err := wm.Encode(io.Discard, WithCompression(42))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPortableFormat(t *testing.T) {
+	Convey("When a matrix on rsdic is marshaled", t, func() {
+		orig := []uint64{3, 1, 4, 1, 5, 9, 2, 6}
+		wm := buildFromSlice(orig)
+		out, err := wm.MarshalBinary()
+		So(err, ShouldBeNil)
+
+		Convey("Its layers should be stored as raw bits", func() {
+			layer := out[formatPreambleLen+8+formatHeaderLen+4:]
+			So(binary.LittleEndian.Uint64(layer), ShouldEqual, 16) // the length of the payload
+			So(binary.LittleEndian.Uint64(layer[8:]), ShouldEqual, len(orig))
+			top := uint64(0)
+			for i, val := range orig {
+				if getMSB(val, 0, wm.blen) {
+					top |= 1 << i
+				}
+			}
+			So(binary.LittleEndian.Uint64(layer[16:]), ShouldEqual, top)
+		})
+	})
+	Convey("When legacy files are converted", t, func() {
+		name := "testdata/legacy.bin"
+		legacy := new(WaveletMatrix)
+		So(legacy.UnmarshalBinaryFile(name), ShouldBeNil)
+
+		in, err := os.Open(name)
+		So(err, ShouldBeNil)
+		var out bytes.Buffer
+		n, err := ConvertLegacy(&out, in)
+		in.Close()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, out.Len())
+		So(binary.LittleEndian.Uint32(out.Bytes()[len(formatMagic):]), ShouldEqual, formatVersion)
+		converted := new(WaveletMatrix)
+		So(converted.UnmarshalBinary(out.Bytes()), ShouldBeNil)
+		So(converted, shouldEqualMatrix, legacy)
+
+		path := filepath.Join(t.TempDir(), "converted.bin")
+		So(ConvertLegacyFile(name, path), ShouldBeNil)
+		converted = new(WaveletMatrix)
+		So(converted.UnmarshalBinaryFile(path), ShouldBeNil)
+		So(converted, shouldEqualMatrix, legacy)
+	})
+}
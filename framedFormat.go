@@ -0,0 +1,123 @@
+package watrix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// StringMatrix, CompositeMatrix, MultiMatrix and HuffmanMatrix are
+// serialized in the framed form
+//
+//	magic    [8]byte  the magic of the type
+//	version  uint32   formatVersion
+//	sections          the sections of the type, as in the container form
+//
+// A WaveletMatrix inside is a section holding its container form.  The
+// sections of each type are listed in FORMAT.md.
+
+var (
+	stringMagic    = [8]byte{0x89, 'W', 'M', 'S', '\r', '\n', 0x1a, '\n'}
+	compositeMagic = [8]byte{0x89, 'W', 'M', 'C', '\r', '\n', 0x1a, '\n'}
+	multiMagic     = [8]byte{0x89, 'W', 'M', 'K', '\r', '\n', 0x1a, '\n'}
+	huffmanMagic   = [8]byte{0x89, 'W', 'M', 'H', '\r', '\n', 0x1a, '\n'}
+)
+
+// framedWriter writes the framed form into a buffer.
+type framedWriter struct {
+	buf bytes.Buffer
+}
+
+func newFramedWriter(magic [8]byte) *framedWriter {
+	fw := new(framedWriter)
+	fw.buf.Write(magic[:])
+	binary.Write(&fw.buf, binary.LittleEndian, uint32(formatVersion))
+	return fw
+}
+
+func (fw *framedWriter) section(payload []byte) {
+	// Writing to bytes.Buffer does not fail.
+	writeSection(&fw.buf, payload)
+}
+
+// matrix writes wm in the container form as a section.
+func (fw *framedWriter) matrix(wm *WaveletMatrix) error {
+	var buf bytes.Buffer
+	if _, err := wm.WriteTo(&buf); err != nil {
+		return err
+	}
+	fw.section(buf.Bytes())
+	return nil
+}
+
+func (fw *framedWriter) bytes() []byte {
+	return fw.buf.Bytes()
+}
+
+// framedReader reads the framed form from a byte slice within
+// DefaultDecodeLimits.
+type framedReader struct {
+	r      *bytes.Reader
+	limits DecodeLimits
+}
+
+// newFramedReader checks the size, the magic and the version of in.
+func newFramedReader(in []byte, magic [8]byte) (*framedReader, error) {
+	fr := &framedReader{r: bytes.NewReader(in), limits: DefaultDecodeLimits}
+	if int64(len(in)) > fr.limits.MaxBytes {
+		return nil, fmt.Errorf("%w: %d bytes", ErrLimit, len(in))
+	}
+	if err := readMagic(fr.r, magic); err != nil {
+		return nil, err
+	}
+	return fr, nil
+}
+
+func (fr *framedReader) section() ([]byte, error) {
+	return readSection(fr.r)
+}
+
+// matrix reads a section holding a WaveletMatrix in the container form,
+// and validates it.
+func (fr *framedReader) matrix() (*WaveletMatrix, error) {
+	payload, err := fr.section()
+	if err != nil {
+		return nil, err
+	}
+	wm := new(WaveletMatrix)
+	if err := wm.readContainer(bytes.NewReader(payload), fr.limits); err != nil {
+		return nil, err
+	}
+	if err := wm.Validate(); err != nil {
+		return nil, err
+	}
+	return wm, nil
+}
+
+// finish checks that nothing follows the last section.
+func (fr *framedReader) finish() error {
+	if fr.r.Len() != 0 {
+		return corruptf("%d bytes after the last section", fr.r.Len())
+	}
+	return nil
+}
+
+// uint64s decodes a payload of little-endian uint64s.
+func uint64s(payload []byte) ([]uint64, error) {
+	if len(payload)%8 != 0 {
+		return nil, corruptf("%d bytes are not a multiple of 8", len(payload))
+	}
+	vals := make([]uint64, len(payload)/8)
+	for i := range vals {
+		vals[i] = binary.LittleEndian.Uint64(payload[8*i:])
+	}
+	return vals, nil
+}
+
+// appendUint64s appends vals as little-endian uint64s to out.
+func appendUint64s(out []byte, vals ...uint64) []byte {
+	for _, val := range vals {
+		out = binary.LittleEndian.AppendUint64(out, val)
+	}
+	return out
+}
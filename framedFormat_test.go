@@ -0,0 +1,60 @@
+package watrix
+
+import (
+	"encoding"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFramedForms(t *testing.T) {
+	sb := NewStringBuilder()
+	for _, s := range []string{"b", "a", "c", "a"} {
+		sb.PushBack(s)
+	}
+	schema, err := NewSchema(Field{"service", 8}, Field{"status", 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := NewCompositeBuilder(schema)
+	cb.PushBack(3, 1)
+	cb.PushBack(1, 2)
+	wmb := NewBuilder()
+	for _, val := range []uint64{3, 1, 4, 1, 5, 9, 2, 6} {
+		wmb.PushBack(val)
+	}
+	cases := []struct {
+		name string
+		m    encoding.BinaryMarshaler
+		new  func() encoding.BinaryUnmarshaler
+	}{
+		{"StringMatrix", sb.Build(), func() encoding.BinaryUnmarshaler { return new(StringMatrix) }},
+		{"CompositeMatrix", cb.Build(), func() encoding.BinaryUnmarshaler { return new(CompositeMatrix) }},
+		{"MultiMatrix", wmb.BuildMultiary(2), func() encoding.BinaryUnmarshaler { return new(MultiMatrix) }},
+		{"HuffmanMatrix", wmb.BuildHuffman(), func() encoding.BinaryUnmarshaler { return new(HuffmanMatrix) }},
+	}
+	for _, c := range cases {
+		Convey("When a "+c.name+" is framed", t, func() {
+			out, err := c.m.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(c.new().UnmarshalBinary(out), ShouldBeNil)
+
+			Convey("A damaged section should fail its checksum", func() {
+				bad := append([]byte(nil), out...)
+				bad[formatPreambleLen+8] ^= 0x01
+				So(c.new().UnmarshalBinary(bad), ShouldEqual, ErrChecksum)
+			})
+			Convey("Other forms should be rejected", func() {
+				wm := buildFromSlice([]uint64{1, 2})
+				other, err := wm.MarshalBinary()
+				So(err, ShouldBeNil)
+				So(c.new().UnmarshalBinary(other), ShouldEqual, ErrBadMagic)
+			})
+			Convey("Trailing and missing bytes should be errors", func() {
+				So(errors.Is(c.new().UnmarshalBinary(append(out, 0)), ErrCorrupt), ShouldBeTrue)
+				So(c.new().UnmarshalBinary(out[:len(out)-1]), ShouldNotBeNil)
+			})
+		})
+	}
+}
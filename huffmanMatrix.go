@@ -0,0 +1,525 @@
+package watrix
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"github.com/hillbig/rsdic"
+)
+
+// maxHuffmanCodeLen is the maximum code length, so that a code fits in uint64.
+const maxHuffmanCodeLen = 64
+
+// huffmanCode is a code of blen bits; the bit at depth d is
+// (bits >> (blen - d - 1)) & 1, as with getMSB.
+type huffmanCode struct {
+	bits uint64
+	blen uint64
+}
+
+// HuffmanMatrix is a Huffman-shaped wavelet matrix.
+//
+// Each distinct value is given a prefix code whose length follows the Huffman
+// code lengths of the value frequencies, so frequent values have short paths.
+// The total number of bits approaches n times the zero-order entropy, and
+// Lookup/Rank/Select on frequent values visit fewer layers than in
+// WaveletMatrix.
+//
+// Layer d stores the d-th code bit of the values whose code is longer than d.
+// Codes are assigned canonically so that, in every layer, the values whose
+// code ends there are placed after all the values that continue; a value has
+// ended when its position in the next layer is beyond the end of that layer.
+//
+// Codes do not preserve the order of the values, so order-based queries
+// such as RankLessThan, RangedRankRange or Quantile would have to visit
+// every distinct value of the range; on data with many rare values that is
+// far slower than WaveletMatrix.  They are left off; build a WaveletMatrix
+// for them.
+type HuffmanMatrix struct {
+	layers []rsdic.RSDic
+	dim    uint64
+	num    uint64
+
+	// symbols and codeLens are the values sorted by (code length, value),
+	// from which the codes are assigned.
+	symbols  []uint64
+	codeLens []uint64
+	codes    map[uint64]huffmanCode
+	values   map[huffmanCode]uint64
+}
+
+// BuildHuffman constructs a HuffmanMatrix from the values pushed so far.
+func (wmb *WaveletMatrixBuilder) BuildHuffman() *HuffmanMatrix {
+	freqs := make(map[uint64]uint64)
+	for _, val := range wmb.vals {
+		freqs[val]++
+	}
+	symbols, codeLens := huffmanCodeLens(freqs)
+	hm := &HuffmanMatrix{
+		dim:      wmb.dim,
+		num:      uint64(len(wmb.vals)),
+		symbols:  symbols,
+		codeLens: codeLens,
+	}
+	hm.assignCodes()
+	hm.buildLayers(wmb.vals)
+	return hm
+}
+
+type huffmanNode struct {
+	freq    uint64
+	symbols []uint64
+}
+
+type huffmanHeap []huffmanNode
+
+func (h huffmanHeap) Len() int           { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool { return h[i].freq < h[j].freq }
+func (h huffmanHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x any)        { *h = append(*h, x.(huffmanNode)) }
+func (h *huffmanHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// huffmanCodeLens returns the distinct values sorted by (code length, value)
+// and their Huffman code lengths.  Lengths are limited to maxHuffmanCodeLen
+// by flattening the frequencies until the limit is met.
+func huffmanCodeLens(freqs map[uint64]uint64) (symbols []uint64, codeLens []uint64) {
+	symbols = make([]uint64, 0, len(freqs))
+	for val := range freqs {
+		symbols = append(symbols, val)
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i] < symbols[j] })
+	lens := make(map[uint64]uint64, len(symbols))
+	if len(symbols) == 1 {
+		lens[symbols[0]] = 1
+	}
+	for shift := uint(0); len(symbols) > 1; shift++ {
+		h := make(huffmanHeap, 0, len(symbols))
+		for _, val := range symbols {
+			freq := freqs[val] >> shift
+			if freq == 0 {
+				freq = 1
+			}
+			h = append(h, huffmanNode{freq, []uint64{val}})
+		}
+		heap.Init(&h)
+		for val := range lens {
+			lens[val] = 0
+		}
+		for h.Len() > 1 {
+			a := heap.Pop(&h).(huffmanNode)
+			b := heap.Pop(&h).(huffmanNode)
+			for _, val := range a.symbols {
+				lens[val]++
+			}
+			for _, val := range b.symbols {
+				lens[val]++
+			}
+			heap.Push(&h, huffmanNode{a.freq + b.freq, append(a.symbols, b.symbols...)})
+		}
+		maxLen := uint64(0)
+		for _, l := range lens {
+			if l > maxLen {
+				maxLen = l
+			}
+		}
+		if maxLen <= maxHuffmanCodeLen {
+			break
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool { return lens[symbols[i]] < lens[symbols[j]] })
+	codeLens = make([]uint64, len(symbols))
+	for i, val := range symbols {
+		codeLens[i] = lens[val]
+	}
+	return symbols, codeLens
+}
+
+// assignCodes assigns canonical codes from hm.symbols and hm.codeLens.
+//
+// The nodes of depth d are ordered as in layer d, i.e. by the last bit and
+// then by the order of their parents.  The first nodes are kept as internal
+// nodes and the last ones become the leaves of the codes of length d.
+func (hm *HuffmanMatrix) assignCodes() {
+	hm.codes = make(map[uint64]huffmanCode, len(hm.symbols))
+	hm.values = make(map[huffmanCode]uint64, len(hm.symbols))
+	internal := []uint64{0} // prefixes of the internal nodes of depth d-1 in layer order
+	next := 0
+	for depth := uint64(1); next < len(hm.symbols); depth++ {
+		children := make([]uint64, 0, 2*len(internal))
+		for bit := uint64(0); bit < 2; bit++ {
+			for _, prefix := range internal {
+				children = append(children, prefix<<1|bit)
+			}
+		}
+		leafNum := 0
+		for next+leafNum < len(hm.symbols) && hm.codeLens[next+leafNum] == depth {
+			leafNum++
+		}
+		internalNum := len(children) - leafNum
+		for i, prefix := range children[internalNum:] {
+			code := huffmanCode{prefix, depth}
+			hm.codes[hm.symbols[next+i]] = code
+			hm.values[code] = hm.symbols[next+i]
+		}
+		next += leafNum
+		internal = children[:internalNum]
+	}
+}
+
+func (hm *HuffmanMatrix) buildLayers(vals []uint64) {
+	cur := make([]huffmanCode, len(vals))
+	for i, val := range vals {
+		cur[i] = hm.codes[val]
+	}
+	hm.layers = make([]rsdic.RSDic, 0)
+	for depth := uint64(0); len(cur) > 0; depth++ {
+		rsd := rsdic.New()
+		zeros := make([]huffmanCode, 0, len(cur))
+		ones := make([]huffmanCode, 0, len(cur))
+		for _, code := range cur {
+			bit := getMSB(code.bits, depth, code.blen)
+			rsd.PushBack(bit)
+			if code.blen == depth+1 {
+				continue
+			}
+			if bit {
+				ones = append(ones, code)
+			} else {
+				zeros = append(zeros, code)
+			}
+		}
+		hm.layers = append(hm.layers, *rsd)
+		cur = append(zeros, ones...)
+	}
+}
+
+// Num return the number of values in T
+func (hm *HuffmanMatrix) Num() uint64 {
+	return hm.num
+}
+
+// Dim returns (max. of T[0...Num) + 1)
+func (hm *HuffmanMatrix) Dim() uint64 {
+	return hm.dim
+}
+
+// layerNum returns the number of bits stored in layer depth.
+func (hm *HuffmanMatrix) layerNum(depth uint64) uint64 {
+	if depth >= uint64(len(hm.layers)) {
+		return 0
+	}
+	return hm.layers[depth].Num()
+}
+
+// Lookup returns T[pos]
+func (hm *HuffmanMatrix) Lookup(pos uint64) uint64 {
+	if len(hm.layers) == 0 {
+		return 0
+	}
+	bits := uint64(0)
+	for depth := uint64(0); ; depth++ {
+		rsd := hm.layers[depth]
+		bits <<= 1
+		if !rsd.Bit(pos) {
+			pos = rsd.Rank(pos, false)
+		} else {
+			bits |= 1
+			pos = rsd.ZeroNum() + rsd.Rank(pos, true)
+		}
+		if pos >= hm.layerNum(depth+1) {
+			return hm.values[huffmanCode{bits, depth + 1}]
+		}
+	}
+}
+
+// LookupAndRank returns T[pos] and Rank(pos, T[pos]) in one call.
+// Faster than calling Lookup and Rank separately.
+func (hm *HuffmanMatrix) LookupAndRank(pos uint64) (uint64, uint64) {
+	if len(hm.layers) == 0 {
+		return 0, 0
+	}
+	bits := uint64(0)
+	bpos := uint64(0)
+	epos := pos
+	for depth := uint64(0); ; depth++ {
+		rsd := hm.layers[depth]
+		bit := rsd.Bit(epos)
+		bpos = rsd.Rank(bpos, bit)
+		epos = rsd.Rank(epos, bit)
+		bits <<= 1
+		if bit {
+			bpos += rsd.ZeroNum()
+			epos += rsd.ZeroNum()
+			bits |= 1
+		}
+		if epos >= hm.layerNum(depth+1) {
+			return hm.values[huffmanCode{bits, depth + 1}], epos - bpos
+		}
+	}
+}
+
+// codeRange returns the range of the last layer of code that the values of
+// T[posRange) equal to code are moved to.
+func (hm *HuffmanMatrix) codeRange(posRange Range, code huffmanCode) Range {
+	for depth := uint64(0); depth < code.blen; depth++ {
+		bit := getMSB(code.bits, depth, code.blen)
+		rsd := hm.layers[depth]
+		if bit {
+			posRange.Beg = rsd.ZeroNum() + rsd.Rank(posRange.Beg, bit)
+			posRange.End = rsd.ZeroNum() + rsd.Rank(posRange.End, bit)
+		} else {
+			posRange.Beg = rsd.Rank(posRange.Beg, bit)
+			posRange.End = rsd.Rank(posRange.End, bit)
+		}
+	}
+	return posRange
+}
+
+// Rank returns the number of c (== val) in T[0...pos)
+func (hm *HuffmanMatrix) Rank(pos uint64, val uint64) uint64 {
+	code, ok := hm.codes[val]
+	if !ok {
+		return 0
+	}
+	r := hm.codeRange(Range{0, pos}, code)
+	return r.End - r.Beg
+}
+
+// walkSymbols descends the code tree with ranges and calls fn for every
+// value that occurs in at least k of them, with the ranges of the last
+// layer of its code that are not empty.
+func (hm *HuffmanMatrix) walkSymbols(ranges []Range, k int, fn func(val uint64, ranges []Range)) {
+	hm.walkSymbolsHelper(ranges, k, 0, 0, fn)
+}
+
+func (hm *HuffmanMatrix) walkSymbolsHelper(ranges []Range, k int, depth uint64, prefix uint64, fn func(val uint64, ranges []Range)) {
+	if depth > 0 {
+		if val, ok := hm.values[huffmanCode{prefix, depth}]; ok {
+			fn(val, ranges)
+			return
+		}
+	}
+	if depth >= uint64(len(hm.layers)) {
+		return
+	}
+	rsd := hm.layers[depth]
+	zeroRanges := make([]Range, 0, len(ranges))
+	oneRanges := make([]Range, 0, len(ranges))
+	for _, posRange := range ranges {
+		nzBeg := rsd.Rank(posRange.Beg, false)
+		nzEnd := rsd.Rank(posRange.End, false)
+		noBeg := posRange.Beg - nzBeg + rsd.ZeroNum()
+		noEnd := posRange.End - nzEnd + rsd.ZeroNum()
+		if nzEnd > nzBeg {
+			zeroRanges = append(zeroRanges, Range{nzBeg, nzEnd})
+		}
+		if noEnd > noBeg {
+			oneRanges = append(oneRanges, Range{noBeg, noEnd})
+		}
+	}
+	if len(zeroRanges) >= k {
+		hm.walkSymbolsHelper(zeroRanges, k, depth+1, prefix<<1, fn)
+	}
+	if len(oneRanges) >= k {
+		hm.walkSymbolsHelper(oneRanges, k, depth+1, (prefix<<1)|1, fn)
+	}
+}
+
+// Select returns the position of (rank+1)-th val in T.
+// If no match has been found, it returns Num().
+func (hm *HuffmanMatrix) Select(rank uint64, val uint64) uint64 {
+	return hm.RangedSelect(Range{0, hm.num}, rank, val)
+}
+
+// RangedSelect takes T[posRange) and returns the position of rank+1'th val.
+// If no match has been found, it returns posRange.End.
+func (hm *HuffmanMatrix) RangedSelect(posRange Range, rank uint64, val uint64) uint64 {
+	code, ok := hm.codes[val]
+	if !ok {
+		return posRange.End
+	}
+	r := hm.codeRange(posRange, code)
+	pos := r.Beg + rank
+	if r.End <= pos {
+		return posRange.End
+	}
+	for depth := code.blen; depth > 0; depth-- {
+		bit := getMSB(code.bits, depth-1, code.blen)
+		rsd := hm.layers[depth-1]
+		if bit {
+			pos = rsd.Select(pos-rsd.ZeroNum(), bit)
+		} else {
+			pos = rsd.Select(pos, bit)
+		}
+	}
+	return pos
+}
+
+// Intersect returns values that occur at least k ranges.
+func (hm *HuffmanMatrix) Intersect(ranges []Range, k int) []uint64 {
+	ret := make([]uint64, 0)
+	hm.walkSymbols(ranges, k, func(val uint64, _ []Range) {
+		ret = append(ret, val)
+	})
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// CodeLen returns the length of the code of val, i.e. the number of layers
+// visited by queries on val, or 0 if val does not occur.
+func (hm *HuffmanMatrix) CodeLen(val uint64) uint64 {
+	return hm.codes[val].blen
+}
+
+// MarshalBinary encodes HuffmanMatrix into the framed form and returns the
+// result.  The layers are stored as their bits; the rank and select
+// directories are rebuilt by UnmarshalBinary.
+func (hm *HuffmanMatrix) MarshalBinary() ([]byte, error) {
+	fw := newFramedWriter(huffmanMagic)
+	fw.section(appendUint64s(nil, hm.dim, hm.num, uint64(len(hm.layers))))
+	for i := range hm.layers {
+		fw.section(marshalWords(hm.layers[i].Num(), bitVectorWords(&hm.layers[i])))
+	}
+	codes := make([]byte, 0, 16*len(hm.symbols))
+	for i, val := range hm.symbols {
+		codes = appendUint64s(codes, val, hm.codeLens[i])
+	}
+	fw.section(codes)
+	return fw.bytes(), nil
+}
+
+// UnmarshalBinary decodes HuffmanMatrix from a binary form generated MarshalBinary.
+func (hm *HuffmanMatrix) UnmarshalBinary(in []byte) (err error) {
+	defer recoverCorrupt(&err)
+	fr, err := newFramedReader(in, huffmanMagic)
+	if err != nil {
+		return err
+	}
+	payload, err := fr.section()
+	if err != nil {
+		return err
+	}
+	header, err := uint64s(payload)
+	if err != nil {
+		return err
+	}
+	if len(header) != 3 {
+		return corruptf("the header has %d fields, want 3", len(header))
+	}
+	decoded := &HuffmanMatrix{dim: header[0], num: header[1]}
+	layerNum := header[2]
+	// There is a layer per bit of the longest code.
+	if layerNum > maxHuffmanCodeLen {
+		return fmt.Errorf("%w: %d layers", ErrLimit, layerNum)
+	}
+	if err := fr.limits.check(layerNum, decoded.num); err != nil {
+		return err
+	}
+	for depth := uint64(0); depth < layerNum; depth++ {
+		payload, err := fr.section()
+		if err != nil {
+			return err
+		}
+		num, words, err := unmarshalWords(payload)
+		if err != nil {
+			return err
+		}
+		// Layer 0 holds every value, and each layer the values that
+		// continue from the one above.
+		if (depth == 0 && num != decoded.num) || (depth > 0 && num > decoded.layerNum(depth-1)) {
+			return corruptf("layer %d has %d bits", depth, num)
+		}
+		rsd := rsdic.New()
+		for pos := uint64(0); pos < num; pos++ {
+			rsd.PushBack((words[pos/64]>>(pos%64))&1 == 1)
+		}
+		decoded.layers = append(decoded.layers, *rsd)
+	}
+	if layerNum == 0 && decoded.num != 0 {
+		return corruptf("no layers for %d values", decoded.num)
+	}
+	payload, err = fr.section()
+	if err != nil {
+		return err
+	}
+	codes, err := uint64s(payload)
+	if err != nil {
+		return err
+	}
+	if len(codes)%2 != 0 {
+		return corruptf("the codes have %d fields", len(codes))
+	}
+	for i := 0; i < len(codes); i += 2 {
+		decoded.symbols = append(decoded.symbols, codes[i])
+		decoded.codeLens = append(decoded.codeLens, codes[i+1])
+	}
+	if err := fr.finish(); err != nil {
+		return err
+	}
+	if err := validateCodeLens(decoded.symbols, decoded.codeLens); err != nil {
+		return err
+	}
+	decoded.assignCodes()
+	*hm = *decoded
+	return nil
+}
+
+// validateCodeLens checks that codeLens can be the code lengths of symbols
+// as given by huffmanCodeLens: one length of 1 to maxHuffmanCodeLen per
+// distinct symbol, sorted, and forming a complete code (Kraft's inequality
+// holds with equality) unless there is a single symbol of length 1.
+// assignCodes relies on this to terminate.
+func validateCodeLens(symbols, codeLens []uint64) error {
+	if len(codeLens) != len(symbols) {
+		return corruptf("%d code lengths for %d symbols", len(codeLens), len(symbols))
+	}
+	seen := make(map[uint64]struct{}, len(symbols))
+	for i, val := range symbols {
+		if _, ok := seen[val]; ok {
+			return corruptf("duplicate symbol %d", val)
+		}
+		seen[val] = struct{}{}
+		if l := codeLens[i]; l == 0 || l > maxHuffmanCodeLen {
+			return corruptf("code length %d of symbol %d is out of 1 to %d", l, val, maxHuffmanCodeLen)
+		}
+		if i > 0 && codeLens[i] < codeLens[i-1] {
+			return corruptf("code lengths are not sorted")
+		}
+	}
+	switch len(symbols) {
+	case 0:
+		return nil
+	case 1:
+		if codeLens[0] != 1 {
+			return corruptf("code length %d of a single symbol", codeLens[0])
+		}
+		return nil
+	}
+	// free is the number of unused nodes of depth; a complete code uses
+	// all of them, so it never exceeds the number of remaining symbols.
+	free := uint64(1)
+	next := 0
+	for depth := uint64(1); next < len(codeLens); depth++ {
+		free *= 2
+		for next < len(codeLens) && codeLens[next] == depth {
+			if free == 0 {
+				return corruptf("code lengths exceed Kraft's inequality")
+			}
+			free--
+			next++
+		}
+		if free > uint64(len(codeLens)-next) {
+			return corruptf("code lengths do not form a complete code")
+		}
+	}
+	if free != 0 {
+		return corruptf("code lengths do not form a complete code")
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package watrix
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHuffmanMatrix(t *testing.T) {
+	Convey("When a vector is empty", t, func() {
+		hm := NewBuilder().BuildHuffman()
+		So(hm.Num(), ShouldEqual, 0)
+		So(hm.Rank(0, 3), ShouldEqual, 0)
+		So(hm.Lookup(0), ShouldEqual, 0)
+		v, rank := hm.LookupAndRank(0)
+		So(v, ShouldEqual, 0)
+		So(rank, ShouldEqual, 0)
+		So(hm.Select(0, 3), ShouldEqual, 0)
+		So(hm.Intersect([]Range{{0, 0}}, 1), ShouldBeEmpty)
+	})
+	Convey("When a vector has a single distinct value", t, func() {
+		wmb := NewBuilder()
+		for i := 0; i < 10; i++ {
+			wmb.PushBack(7)
+		}
+		hm := wmb.BuildHuffman()
+		So(hm.Lookup(3), ShouldEqual, 7)
+		So(hm.Rank(5, 7), ShouldEqual, 5)
+		So(hm.Select(4, 7), ShouldEqual, 4)
+		So(hm.Select(10, 7), ShouldEqual, 10)
+	})
+	Convey("When a skewed random vector is generated", t, func() {
+		num := uint64(6000)
+		orig := make([]uint64, num)
+		wmb := NewBuilder()
+		for i := range orig {
+			switch r := rand.Intn(100); {
+			case r < 70:
+				orig[i] = 1 << 40
+			case r < 90:
+				orig[i] = 3
+			default:
+				orig[i] = uint64(rand.Int63n(300))
+			}
+			wmb.PushBack(orig[i])
+		}
+		hm := wmb.BuildHuffman()
+		plain := wmb.Build()
+
+		So(hm.Dim(), ShouldEqual, plain.Dim())
+		So(hm.CodeLen(1<<40), ShouldEqual, 1)
+		So(hm.CodeLen(3), ShouldEqual, 2)
+		So(hm.CodeLen(1<<41), ShouldEqual, 0)
+
+		check := func(hm *HuffmanMatrix) {
+			for i := 0; i < 30; i++ {
+				ranze := generateRange(num)
+				x := orig[rand.Intn(int(num))]
+				So(hm.Lookup(ranze.Beg), ShouldEqual, orig[ranze.Beg])
+				c, rank := hm.LookupAndRank(ranze.Beg)
+				So(c, ShouldEqual, orig[ranze.Beg])
+				So(rank, ShouldEqual, plain.Rank(ranze.Beg, c))
+				So(hm.Select(rank, c), ShouldEqual, ranze.Beg)
+				for _, val := range []uint64{x, x + 1000, 0} {
+					So(hm.Rank(ranze.End, val), ShouldEqual, plain.Rank(ranze.End, val))
+					So(hm.Select(2, val), ShouldEqual, plain.Select(2, val))
+					So(hm.RangedSelect(ranze, 1, val), ShouldEqual, plain.RangedSelect(ranze, 1, val))
+				}
+				ranges := []Range{generateRange(num), generateRange(num), generateRange(num)}
+				So(hm.Intersect(ranges, 2), ShouldResemble, origIntersect(orig, ranges, 2))
+			}
+		}
+		check(hm)
+
+		Convey("The matrix should survive marshaling", func() {
+			out, err := hm.MarshalBinary()
+			So(err, ShouldBeNil)
+			loaded := new(HuffmanMatrix)
+			So(loaded.UnmarshalBinary(out), ShouldBeNil)
+			check(loaded)
+		})
+	})
+	Convey("When code lengths would exceed 64 bits", t, func() {
+		freqs := make(map[uint64]uint64)
+		a, b := uint64(1), uint64(1)
+		for i := uint64(0); i < 80; i++ {
+			freqs[i] = a
+			a, b = b, a+b
+		}
+		symbols, codeLens := huffmanCodeLens(freqs)
+		So(codeLens[len(codeLens)-1], ShouldBeLessThanOrEqualTo, maxHuffmanCodeLen)
+		So(validateCodeLens(symbols, codeLens), ShouldBeNil)
+	})
+	Convey("When the code lengths of a binary form are invalid", t, func() {
+		wmb := NewBuilder()
+		for _, val := range []uint64{5, 5, 5, 1, 2, 9} {
+			wmb.PushBack(val)
+		}
+		hm := wmb.BuildHuffman()
+		So(validateCodeLens(hm.symbols, hm.codeLens), ShouldBeNil)
+		for _, codeLens := range [][]uint64{
+			{0, 0, 0, 0},
+			{3, 2, 2, 1},
+			{1, 2, 3, 65},
+			{1, 1, 2, 2},
+			{1, 2, 3, 4},
+			{64, 64, 64, 64},
+		} {
+			forged := *hm
+			forged.codeLens = codeLens
+			out, err := forged.MarshalBinary()
+			So(err, ShouldBeNil)
+			loaded := new(HuffmanMatrix)
+			So(errors.Is(loaded.UnmarshalBinary(out), ErrCorrupt), ShouldBeTrue)
+		}
+		So(validateCodeLens(hm.symbols, []uint64{1, 2, 3}), ShouldNotBeNil)
+		So(validateCodeLens([]uint64{4}, []uint64{2}), ShouldNotBeNil)
+		So(validateCodeLens([]uint64{4, 4}, []uint64{1, 1}), ShouldNotBeNil)
+		So(validateCodeLens([]uint64{4}, []uint64{1}), ShouldBeNil)
+		So(validateCodeLens(nil, nil), ShouldBeNil)
+	})
+}
@@ -0,0 +1,282 @@
+package watrix
+
+import (
+	"fmt"
+	"math/bits"
+	"unsafe"
+)
+
+const (
+	interleavedBlockWords = 8 // one 64-byte cache line
+	interleavedDataWords  = 6 // 384 bits per block
+	interleavedBlockBits  = interleavedDataWords * 64
+)
+
+// interleavedBitVector is an uncompressed BitVector whose rank counters are
+// interleaved with the bits.
+//
+// Each block is one cache line of 8 words: the number of ones before the
+// block, five 9-bit counts of ones before each data word within the block,
+// and 6 data words.  The blocks are aligned to 64 bytes, so that Rank and
+// Bit touch exactly one cache line.  The index takes 1/3 of the raw bits.
+type interleavedBitVector struct {
+	words       []uint64 // the bits while building; nil after Build
+	data        []uint64 // the blocks; has a sentinel
+	selectOnes  []uint32 // selectOnes[i]: the block of the (i*plainSelectSample)-th one
+	selectZeros []uint32 // selectZeros[i]: the block of the (i*plainSelectSample)-th zero
+	num         uint64
+	oneNum      uint64
+}
+
+func newInterleavedBitVector() *interleavedBitVector {
+	return &interleavedBitVector{
+		words: make([]uint64, 0),
+	}
+}
+
+// alignedWords returns n words whose first word is aligned to 64 bytes.
+func alignedWords(n int) []uint64 {
+	buf := make([]uint64, n+interleavedBlockWords-1)
+	off := int(uintptr(unsafe.Pointer(&buf[0])) % 64 / 8)
+	if off > 0 {
+		off = interleavedBlockWords - off
+	}
+	return buf[off : off+n : off+n]
+}
+
+// PushBack appends the bit to the end of B
+func (bv *interleavedBitVector) PushBack(bit bool) {
+	if bv.num%64 == 0 {
+		bv.words = append(bv.words, 0)
+	}
+	if bit {
+		bv.words[bv.num/64] |= 1 << (bv.num % 64)
+	}
+	bv.num++
+}
+
+// Build lays out the blocks and returns bv itself.
+func (bv *interleavedBitVector) Build() BitVector {
+	bv.buildBlocks(bv.words)
+	bv.words = nil
+	return bv
+}
+
+func (bv *interleavedBitVector) blockNum() uint64 {
+	return uint64(len(bv.data)) / interleavedBlockWords
+}
+
+func (bv *interleavedBitVector) buildBlocks(words []uint64) {
+	blockNum := bv.num/interleavedBlockBits + 1
+	bv.data = alignedWords(int(blockNum * interleavedBlockWords))
+	ones := uint64(0)
+	for b := uint64(0); b < blockNum; b++ {
+		block := bv.data[b*interleavedBlockWords : (b+1)*interleavedBlockWords]
+		block[0] = ones
+		inBlock := uint64(0)
+		for j := uint64(0); j < interleavedDataWords; j++ {
+			if j > 0 {
+				block[1] |= inBlock << (9 * (j - 1))
+			}
+			if i := b*interleavedDataWords + j; i < uint64(len(words)) {
+				block[2+j] = words[i]
+				inBlock += uint64(bits.OnesCount64(words[i]))
+			}
+		}
+		ones += inBlock
+	}
+	bv.oneNum = ones
+
+	bv.selectOnes = make([]uint32, 0, ones/plainSelectSample+1)
+	bv.selectZeros = make([]uint32, 0, (bv.num-ones)/plainSelectSample+1)
+	for b := uint64(0); b+1 < blockNum; b++ {
+		for uint64(len(bv.selectOnes))*plainSelectSample < bv.blockCount(b+1, true) {
+			bv.selectOnes = append(bv.selectOnes, uint32(b))
+		}
+		for uint64(len(bv.selectZeros))*plainSelectSample < bv.blockCount(b+1, false) {
+			bv.selectZeros = append(bv.selectZeros, uint32(b))
+		}
+	}
+	for uint64(len(bv.selectOnes))*plainSelectSample < ones {
+		bv.selectOnes = append(bv.selectOnes, uint32(blockNum-1))
+	}
+	for uint64(len(bv.selectZeros))*plainSelectSample < bv.num-ones {
+		bv.selectZeros = append(bv.selectZeros, uint32(blockNum-1))
+	}
+}
+
+// blockCount returns the number of bit before block b.
+func (bv *interleavedBitVector) blockCount(b uint64, bit bool) uint64 {
+	ones := bv.data[b*interleavedBlockWords]
+	if bit {
+		return ones
+	}
+	return b*interleavedBlockBits - ones
+}
+
+// wordCount returns the number of bit before data word j within block b.
+func (bv *interleavedBitVector) wordCount(b uint64, j uint64, bit bool) uint64 {
+	ones := uint64(0)
+	if j > 0 {
+		ones = (bv.data[b*interleavedBlockWords+1] >> (9 * (j - 1))) & 0x1ff
+	}
+	if bit {
+		return ones
+	}
+	return j*64 - ones
+}
+
+func (bv *interleavedBitVector) Num() uint64 {
+	return bv.num
+}
+
+func (bv *interleavedBitVector) OneNum() uint64 {
+	return bv.oneNum
+}
+
+func (bv *interleavedBitVector) ZeroNum() uint64 {
+	return bv.num - bv.oneNum
+}
+
+func (bv *interleavedBitVector) Bit(pos uint64) bool {
+	b, r := pos/interleavedBlockBits, pos%interleavedBlockBits
+	return (bv.data[b*interleavedBlockWords+2+r/64]>>(r%64))&1 == 1
+}
+
+func (bv *interleavedBitVector) Rank(pos uint64, bit bool) uint64 {
+	b, r := pos/interleavedBlockBits, pos%interleavedBlockBits
+	block := bv.data[b*interleavedBlockWords : (b+1)*interleavedBlockWords]
+	ones := block[0]
+	if j := r / 64; j > 0 {
+		ones += (block[1] >> (9 * (j - 1))) & 0x1ff
+	}
+	if m := r % 64; m > 0 {
+		ones += uint64(bits.OnesCount64(block[2+r/64] & ((1 << m) - 1)))
+	}
+	if bit {
+		return ones
+	}
+	return pos - ones
+}
+
+func (bv *interleavedBitVector) Select(rank uint64, bit bool) uint64 {
+	hints := bv.selectZeros
+	if bit {
+		hints = bv.selectOnes
+		if rank >= bv.OneNum() {
+			return bv.num
+		}
+	} else if rank >= bv.ZeroNum() {
+		return bv.num
+	}
+	// the last block b in [lo, hi) with blockCount(b) <= rank
+	i := rank / plainSelectSample
+	lo, hi := uint64(hints[i]), bv.blockNum()
+	if i+1 < uint64(len(hints)) {
+		hi = uint64(hints[i+1]) + 1
+	}
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if bv.blockCount(mid, bit) <= rank {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	b := lo
+	rank -= bv.blockCount(b, bit)
+	j := uint64(0)
+	for j+1 < interleavedDataWords && bv.wordCount(b, j+1, bit) <= rank {
+		j++
+	}
+	rank -= bv.wordCount(b, j, bit)
+	word := bv.data[b*interleavedBlockWords+2+j]
+	if !bit {
+		word = ^word
+	}
+	return b*interleavedBlockBits + j*64 + selectInWord(word, rank)
+}
+
+// validateBlocks checks the counters of the blocks against the bits, and
+// the select hints against the counters, as Build lays them out.  Blocks
+// that do not come from buildBlocks, such as mapped ones, are checked by
+// it in ValidateDeep.  It reads every block once.
+func (bv *interleavedBitVector) validateBlocks() error {
+	blockNum := bv.num/interleavedBlockBits + 1
+	if uint64(len(bv.data)) != blockNum*interleavedBlockWords {
+		return fmt.Errorf("%d words for %d bits", len(bv.data), bv.num)
+	}
+	ones := uint64(0)
+	for b := uint64(0); b < blockNum; b++ {
+		block := bv.data[b*interleavedBlockWords : (b+1)*interleavedBlockWords]
+		if block[0] != ones {
+			return fmt.Errorf("block %d counts %d ones before it, want %d", b, block[0], ones)
+		}
+		if block[1]>>(9*(interleavedDataWords-1)) != 0 {
+			return fmt.Errorf("block %d has stray counter bits", b)
+		}
+		inBlock := uint64(0)
+		for j := uint64(0); j < interleavedDataWords; j++ {
+			if j > 0 && (block[1]>>(9*(j-1)))&0x1ff != inBlock {
+				return fmt.Errorf("block %d counts %d ones before word %d, want %d", b, (block[1]>>(9*(j-1)))&0x1ff, j, inBlock)
+			}
+			word := block[2+j]
+			if pos := b*interleavedBlockBits + j*64; pos >= bv.num && word != 0 || pos < bv.num && bv.num-pos < 64 && word>>(bv.num-pos) != 0 {
+				return fmt.Errorf("block %d has bits after %d", b, bv.num)
+			}
+			inBlock += uint64(bits.OnesCount64(word))
+		}
+		ones += inBlock
+	}
+	if ones != bv.oneNum {
+		return fmt.Errorf("%d ones, want %d", ones, bv.oneNum)
+	}
+	for _, bit := range []bool{true, false} {
+		hints, n := bv.selectOnes, bv.oneNum
+		if !bit {
+			hints, n = bv.selectZeros, bv.num-bv.oneNum
+		}
+		if uint64(len(hints)) != (n+plainSelectSample-1)/plainSelectSample {
+			return fmt.Errorf("%d select hints for %d bits %v", len(hints), n, bit)
+		}
+		for i, hint := range hints {
+			b, rank := uint64(hint), uint64(i)*plainSelectSample
+			next := n
+			if b+1 < blockNum {
+				next = bv.blockCount(b+1, bit)
+			}
+			if b >= blockNum || bv.blockCount(b, bit) > rank || next <= rank {
+				return fmt.Errorf("select hint %d of bits %v is not the block of rank %d", i, bit, rank)
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalBinary encodes the bit vector in the same form as denseBitVector.
+// The blocks are rebuilt by UnmarshalBinary.
+func (bv *interleavedBitVector) MarshalBinary() ([]byte, error) {
+	return marshalWords(bv.num, bv.rawWords()), nil
+}
+
+// rawWords returns the bits without the counters.
+func (bv *interleavedBitVector) rawWords() []uint64 {
+	words := make([]uint64, (bv.num+63)/64)
+	for i := range words {
+		b, j := uint64(i)/interleavedDataWords, uint64(i)%interleavedDataWords
+		words[i] = bv.data[b*interleavedBlockWords+2+j]
+	}
+	return words
+}
+
+// UnmarshalBinary decodes the bit vector from a binary form generated MarshalBinary.
+func (bv *interleavedBitVector) UnmarshalBinary(in []byte) error {
+	num, words, err := unmarshalWords(in)
+	if err != nil {
+		return err
+	}
+	bv.num = num
+	bv.words = nil
+	bv.buildBlocks(words)
+	return nil
+}
@@ -0,0 +1,360 @@
+package watrix
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// LazyMatrix is WaveletMatrix whose layers are read the first time each of
+// them is touched.  Queries that only visit the top layers, such as
+// RangedRankIgnoreLSBs with large ignoreBits, then read only those layers.
+//
+// A layer that cannot be read, fails its checksum or has other than Num()
+// bits makes the query that touches it fail, and is read again by the next
+// one.  Load reads every layer up front and returns a plain WaveletMatrix,
+// which is the way to go unless only a few layers are ever needed.
+type LazyMatrix struct {
+	wm *WaveletMatrix
+}
+
+// lazyLoadError carries the error of a layer out of a query.
+type lazyLoadError struct {
+	err error
+}
+
+func (le lazyLoadError) Error() string {
+	return le.err.Error()
+}
+
+// OpenLazy returns LazyMatrix reading its layers from r, which holds size
+// bytes written by WriteTo.  The header, the index and the alphabet are
+// read by OpenLazy.  r must stay readable while the matrix is used.  It is
+// safe to query the matrix from multiple goroutines; each layer is read
+// once.
+func OpenLazy(r io.ReaderAt, size int64) (*LazyMatrix, error) {
+	ci, err := readContainerIndex(r, size)
+	if err != nil {
+		return nil, err
+	}
+	wm := ci.wm
+	info, err := wm.backend.info()
+	if err != nil {
+		return nil, err
+	}
+	wm.layers = make([]BitVector, ci.layerNum)
+	for i := range wm.layers {
+		wm.layers[i] = &lazyBitVector{
+			num:        wm.num,
+			r:          r,
+			off:        ci.offsets[i],
+			size:       size,
+			compressed: ci.flags&flagFlate != 0,
+			maxBytes:   maxLayerBytes(wm.num),
+			info:       info,
+		}
+	}
+	if ci.flags&flagCompacted != 0 {
+		payload, err := readSectionAt(r, ci.offsets[ci.layerNum], size)
+		if err != nil {
+			return nil, err
+		}
+		if wm.alphabet, err = parseAlphabet(payload); err != nil {
+			return nil, err
+		}
+	}
+	if err := wm.validateHeader(); err != nil {
+		return nil, err
+	}
+	return &LazyMatrix{wm: wm}, nil
+}
+
+// Num returns the number of values.
+func (lm *LazyMatrix) Num() uint64 {
+	return lm.wm.num
+}
+
+// Dim returns the maximum value + 1.
+func (lm *LazyMatrix) Dim() uint64 {
+	return lm.wm.dim
+}
+
+// Compacted reports whether the matrix stores dense codes.
+func (lm *LazyMatrix) Compacted() bool {
+	return lm.wm.alphabet != nil
+}
+
+// Load reads the layers that are not read yet and returns WaveletMatrix
+// holding them, or the first error met.  The returned matrix does not
+// touch r again.
+func (lm *LazyMatrix) Load() (*WaveletMatrix, error) {
+	layers := make([]BitVector, len(lm.wm.layers))
+	for i, layer := range lm.wm.layers {
+		bv, err := layer.(*lazyBitVector).tryLoad()
+		if err != nil {
+			return nil, err
+		}
+		layers[i] = bv
+	}
+	wm := *lm.wm
+	wm.layers = layers
+	return &wm, nil
+}
+
+// Do runs fn on the lazy matrix, and returns the error of a layer that
+// fails to load during fn.  The queries of fn after the failure are not
+// run.  fn must not keep the matrix.
+func (lm *LazyMatrix) Do(fn func(wm *WaveletMatrix)) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			le, ok := p.(lazyLoadError)
+			if !ok {
+				panic(p)
+			}
+			err = le.err
+		}
+	}()
+	fn(lm.wm)
+	return nil
+}
+
+// Unchecked returns the lazy matrix as WaveletMatrix, whose queries panic
+// when a layer fails to load.  Prefer Load or Do.
+func (lm *LazyMatrix) Unchecked() *WaveletMatrix {
+	return lm.wm
+}
+
+// Lookup is WaveletMatrix.Lookup, failing if a layer fails to load.
+func (lm *LazyMatrix) Lookup(pos uint64) (val uint64, err error) {
+	err = lm.Do(func(wm *WaveletMatrix) { val = wm.Lookup(pos) })
+	return val, err
+}
+
+// Rank is WaveletMatrix.Rank, failing if a layer fails to load.
+func (lm *LazyMatrix) Rank(pos uint64, val uint64) (rank uint64, err error) {
+	err = lm.Do(func(wm *WaveletMatrix) { rank = wm.Rank(pos, val) })
+	return rank, err
+}
+
+// Select is WaveletMatrix.Select, failing if a layer fails to load.
+func (lm *LazyMatrix) Select(rank uint64, val uint64) (pos uint64, err error) {
+	err = lm.Do(func(wm *WaveletMatrix) { pos = wm.Select(rank, val) })
+	return pos, err
+}
+
+// Quantile is WaveletMatrix.Quantile, failing if a layer fails to load.
+func (lm *LazyMatrix) Quantile(posRange Range, k uint64) (val uint64, err error) {
+	err = lm.Do(func(wm *WaveletMatrix) { val = wm.Quantile(posRange, k) })
+	return val, err
+}
+
+// RangedRankIgnoreLSBs is WaveletMatrix.RangedRankIgnoreLSBs, failing if a
+// layer fails to load.
+func (lm *LazyMatrix) RangedRankIgnoreLSBs(posRange Range, val, ignoreBits uint64) (rank uint64, err error) {
+	err = lm.Do(func(wm *WaveletMatrix) { rank = wm.RangedRankIgnoreLSBs(posRange, val, ignoreBits) })
+	return rank, err
+}
+
+// containerIndex is the header and the index of a container.
+type containerIndex struct {
+	wm       *WaveletMatrix // the fields from the header, without the layers
+	flags    uint32
+	layerNum uint64
+	// offsets holds the offsets of the layers, the alphabet (0 if none)
+	// and the index.
+	offsets []int64
+}
+
+// readContainerIndex reads the header and the index of the container in
+// r, which holds size bytes.
+func readContainerIndex(r io.ReaderAt, size int64) (*containerIndex, error) {
+	sr := io.NewSectionReader(r, 0, size)
+	if err := readPreamble(sr); err != nil {
+		return nil, err
+	}
+	header, err := readSection(sr)
+	if err != nil {
+		return nil, err
+	}
+	ci := &containerIndex{wm: &WaveletMatrix{}}
+	ci.flags, ci.layerNum, err = ci.wm.parseHeader(header, DefaultDecodeLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < formatTrailerLen {
+		return nil, io.ErrUnexpectedEOF
+	}
+	trailer := make([]byte, formatTrailerLen)
+	if _, err := r.ReadAt(trailer, size-formatTrailerLen); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if string(trailer[8:]) != string(formatTrailerMagic[:]) {
+		return nil, ErrBadMagic
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer))
+	index, err := readSectionAt(r, indexOffset, size)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(index)) != 8*(ci.layerNum+1) {
+		return nil, fmt.Errorf("watrix: index has %d bytes for %d layers", len(index), ci.layerNum)
+	}
+	ci.offsets = make([]int64, ci.layerNum+2)
+	for i := range ci.offsets[:ci.layerNum+1] {
+		ci.offsets[i] = int64(binary.LittleEndian.Uint64(index[8*i:]))
+	}
+	ci.offsets[ci.layerNum+1] = indexOffset
+	return ci, nil
+}
+
+// readSectionAt reads the section at off of r holding size bytes.
+func readSectionAt(r io.ReaderAt, off int64, size int64) ([]byte, error) {
+	if off < 0 || off >= size {
+		return nil, fmt.Errorf("watrix: section offset %d is out of %d bytes", off, size)
+	}
+	return readSection(io.NewSectionReader(r, off, size-off))
+}
+
+// lazyBitVector is a layer of OpenLazy read on the first touch.
+type lazyBitVector struct {
+	num        uint64 // the bits the layer must have
+	r          io.ReaderAt
+	off        int64
+	size       int64
+	compressed bool
+	maxBytes   int64
+	info       backendInfo
+
+	mu     sync.Mutex
+	loaded atomic.Bool // bv is set
+	bv     BitVector
+}
+
+// load returns the layer, panicking with lazyLoadError for Do if it cannot
+// be read.
+func (lv *lazyBitVector) load() BitVector {
+	bv, err := lv.tryLoad()
+	if err != nil {
+		panic(lazyLoadError{err})
+	}
+	return bv
+}
+
+// tryLoad returns the layer, reading it if it is not read yet.  A failed
+// read is not remembered, so the next call tries again.
+func (lv *lazyBitVector) tryLoad() (BitVector, error) {
+	if lv.loaded.Load() {
+		return lv.bv, nil
+	}
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	if lv.loaded.Load() {
+		return lv.bv, nil
+	}
+	payload, err := readSectionAt(lv.r, lv.off, lv.size)
+	if err == nil && lv.compressed {
+		payload, err = decompressLayer(payload, lv.maxBytes)
+	}
+	var bv BitVector
+	if err == nil {
+		bv, err = lv.info.unmarshalPortable(payload)
+	}
+	if err == nil && bv.Num() != lv.num {
+		err = corruptf("%d bits, want %d", bv.Num(), lv.num)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("watrix: cannot load the layer at %d: %w", lv.off, err)
+	}
+	lv.bv = bv
+	lv.loaded.Store(true)
+	return bv, nil
+}
+
+func (lv *lazyBitVector) Num() uint64 {
+	return lv.num
+}
+
+func (lv *lazyBitVector) ZeroNum() uint64 {
+	return lv.load().ZeroNum()
+}
+
+func (lv *lazyBitVector) Bit(pos uint64) bool {
+	return lv.load().Bit(pos)
+}
+
+func (lv *lazyBitVector) Rank(pos uint64, bit bool) uint64 {
+	return lv.load().Rank(pos, bit)
+}
+
+func (lv *lazyBitVector) Select(rank uint64, bit bool) uint64 {
+	return lv.load().Select(rank, bit)
+}
+
+func (lv *lazyBitVector) MarshalBinary() ([]byte, error) {
+	return lv.load().MarshalBinary()
+}
+
+// UnmarshalBinary is not supported; a lazy layer is read-only.
+func (lv *lazyBitVector) UnmarshalBinary(in []byte) error {
+	return errors.New("watrix: a lazily loaded layer is read-only")
+}
+
+// ContainerInfo describes a matrix in the container form.
+type ContainerInfo struct {
+	Version    uint32
+	Backend    Backend
+	Compacted  bool
+	Compressed bool
+	Dim        uint64
+	Num        uint64
+	Blen       uint64
+	// LayerSizes holds the bytes of each layer section from the top layer,
+	// including its length and checksum.
+	LayerSizes []int64
+	// AlphabetSize is the bytes of the alphabet section, or 0 if none.
+	AlphabetSize int64
+	Size         int64
+}
+
+// Inspect reads the header and the index of the matrix in r, which holds
+// size bytes written by WriteTo, without reading the layers.
+func Inspect(r io.ReaderAt, size int64) (*ContainerInfo, error) {
+	ci, err := readContainerIndex(r, size)
+	if err != nil {
+		return nil, err
+	}
+	info := &ContainerInfo{
+		Version:    formatVersion,
+		Backend:    ci.wm.backend,
+		Compacted:  ci.flags&flagCompacted != 0,
+		Compressed: ci.flags&flagFlate != 0,
+		Dim:        ci.wm.dim,
+		Num:        ci.wm.num,
+		Blen:       ci.wm.blen,
+		LayerSizes: make([]int64, ci.layerNum),
+		Size:       size,
+	}
+	// Each section ends where the next one present begins.
+	end := ci.offsets[ci.layerNum+1]
+	if info.Compacted {
+		info.AlphabetSize = end - ci.offsets[ci.layerNum]
+		end = ci.offsets[ci.layerNum]
+	}
+	for i := int(ci.layerNum) - 1; i >= 0; i-- {
+		info.LayerSizes[i] = end - ci.offsets[i]
+		end = ci.offsets[i]
+	}
+	if info.AlphabetSize < 0 || (ci.layerNum > 0 && ci.offsets[0] < formatPreambleLen) {
+		return nil, corruptf("the index is out of order")
+	}
+	for _, size := range info.LayerSizes {
+		if size < 0 {
+			return nil, corruptf("the index is out of order")
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,212 @@
+package watrix
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingReaderAt counts the reads at each offset.
+type countingReaderAt struct {
+	r     *bytes.Reader
+	mu    sync.Mutex
+	reads map[int64]int
+}
+
+func (cr *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	cr.mu.Lock()
+	cr.reads[off]++
+	cr.mu.Unlock()
+	return cr.r.ReadAt(p, off)
+}
+
+// failingReaderAt fails the first fails reads.
+type failingReaderAt struct {
+	r     io.ReaderAt
+	fails int
+}
+
+func (fr *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if fr.fails > 0 {
+		fr.fails--
+		return 0, errors.New("flaky read")
+	}
+	return fr.r.ReadAt(p, off)
+}
+
+func loadedLayers(lm *LazyMatrix) int {
+	n := 0
+	for _, layer := range lm.wm.layers {
+		if layer.(*lazyBitVector).bv != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestOpenLazy(t *testing.T) {
+	Convey("When a matrix is opened lazily", t, func() {
+		num := uint64(14000)
+		dim := uint64(100)
+		testNum := uint64(10)
+		orig := make([]uint64, num)
+		ranks := make([][]uint64, dim)
+		ranksLessThan := make([][]uint64, dim)
+		ranksMoreThan := make([][]uint64, dim)
+
+		wm := buildWaveletHelper(t, num, testNum, dim, orig, ranks, ranksLessThan, ranksMoreThan)
+		out, err := wm.MarshalBinary()
+		So(err, ShouldBeNil)
+		cr := &countingReaderAt{r: bytes.NewReader(out), reads: make(map[int64]int)}
+		lazy, err := OpenLazy(cr, int64(len(out)))
+		So(err, ShouldBeNil)
+		So(loadedLayers(lazy), ShouldEqual, 0)
+
+		Convey("A coarse query should load only the top layers", func() {
+			rank, err := lazy.RangedRankIgnoreLSBs(Range{0, num}, 64, wm.blen-2)
+			So(err, ShouldBeNil)
+			So(rank, ShouldEqual, wm.RangedRankIgnoreLSBs(Range{0, num}, 64, wm.blen-2))
+			So(loadedLayers(lazy), ShouldEqual, 2)
+		})
+		Convey("Queries should match the reference counts", func() {
+			So(lazy.Do(func(wm *WaveletMatrix) {
+				testWaveletHelper(t, wm, num, testNum, dim, orig, ranks, ranksLessThan, ranksMoreThan)
+			}), ShouldBeNil)
+			loaded, err := lazy.Load()
+			So(err, ShouldBeNil)
+			So(loaded, shouldEqualMatrix, wm)
+			for _, layer := range loaded.layers {
+				_, ok := layer.(*lazyBitVector)
+				So(ok, ShouldBeFalse)
+			}
+		})
+		Convey("Concurrent queries should load each layer once", func() {
+			var wg sync.WaitGroup
+			results := make([][]uint64, 16)
+			for g := range results {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for pos := uint64(g); pos < num; pos += 97 {
+						val, err := lazy.Lookup(pos)
+						if err != nil {
+							panic(err)
+						}
+						results[g] = append(results[g], val)
+					}
+				}(g)
+			}
+			wg.Wait()
+			for g, vals := range results {
+				for i, val := range vals {
+					So(val, ShouldEqual, orig[uint64(g)+uint64(i)*97])
+				}
+			}
+			So(loadedLayers(lazy), ShouldEqual, len(lazy.wm.layers))
+			for _, layer := range lazy.wm.layers {
+				// the length of the section is read at its offset
+				So(cr.reads[layer.(*lazyBitVector).off], ShouldEqual, 1)
+			}
+		})
+	})
+	Convey("When a lazily opened matrix is compacted", t, func() {
+		wmb := NewBuilder()
+		for i := uint64(0); i < 3000; i++ {
+			wmb.PushBack(i % 11 << 45)
+		}
+		wm := wmb.Build(WithCompactAlphabet())
+		out, err := wm.MarshalBinary()
+		So(err, ShouldBeNil)
+		lazy, err := OpenLazy(bytes.NewReader(out), int64(len(out)))
+		So(err, ShouldBeNil)
+		So(lazy.Compacted(), ShouldBeTrue)
+		So(lazy.Unchecked(), shouldEqualMatrix, wm)
+	})
+	Convey("When a layer is corrupt", t, func() {
+		wm := buildFromSlice([]uint64{3, 1, 4, 1, 5, 9, 2, 6})
+		out, err := wm.MarshalBinary()
+		So(err, ShouldBeNil)
+		out[formatPreambleLen+8+formatHeaderLen+4+8] ^= 0x10
+		lazy, err := OpenLazy(bytes.NewReader(out), int64(len(out)))
+		So(err, ShouldBeNil)
+		_, err = lazy.Lookup(0)
+		So(errors.Is(err, ErrChecksum), ShouldBeTrue)
+		_, err = lazy.Load()
+		So(errors.Is(err, ErrChecksum), ShouldBeTrue)
+		So(func() { lazy.Unchecked().Lookup(0) }, ShouldPanic)
+	})
+	Convey("When a layer has other than num bits", t, func() {
+		wm := buildFromSlice([]uint64{3, 1, 4, 1, 5, 9, 2, 6})
+		out, err := wm.MarshalBinary()
+		So(err, ShouldBeNil)
+		lazy, err := OpenLazy(bytes.NewReader(out), int64(len(out)))
+		So(err, ShouldBeNil)
+		lazy.wm.layers[1].(*lazyBitVector).num = 7
+		_, err = lazy.Load()
+		So(errors.Is(err, ErrCorrupt), ShouldBeTrue)
+		_, err = lazy.Rank(8, 1)
+		So(errors.Is(err, ErrCorrupt), ShouldBeTrue)
+	})
+	Convey("When a layer fails to be read", t, func() {
+		wm := buildFromSlice([]uint64{3, 1, 4, 1, 5, 9, 2, 6})
+		out, err := wm.MarshalBinary()
+		So(err, ShouldBeNil)
+		fr := &failingReaderAt{r: bytes.NewReader(out)}
+		lazy, err := OpenLazy(fr, int64(len(out)))
+		So(err, ShouldBeNil)
+		fr.fails = 1
+		_, err = lazy.Load()
+		So(err, ShouldNotBeNil)
+		val, err := lazy.Lookup(0)
+		So(err, ShouldBeNil)
+		So(val, ShouldEqual, 3)
+		loaded, err := lazy.Load()
+		So(err, ShouldBeNil)
+		So(loadedLayers(lazy), ShouldEqual, len(lazy.wm.layers))
+		So(loaded, shouldEqualMatrix, wm)
+	})
+}
+
+func TestLazyDo(t *testing.T) {
+	Convey("Do should pass on panics other than a failed load", t, func() {
+		wm := buildFromSlice([]uint64{3, 1, 4})
+		out, err := wm.MarshalBinary()
+		So(err, ShouldBeNil)
+		lazy, err := OpenLazy(bytes.NewReader(out), int64(len(out)))
+		So(err, ShouldBeNil)
+		So(func() { lazy.Do(func(*WaveletMatrix) { panic("boom") }) }, ShouldPanicWith, "boom")
+	})
+}
+
+func TestInspect(t *testing.T) {
+	Convey("When a matrix is inspected", t, func() {
+		wmb := NewBuilder()
+		for i := uint64(0); i < 3000; i++ {
+			wmb.PushBack(i % 11 << 45)
+		}
+		wm := wmb.Build(WithCompactAlphabet())
+		out, err := wm.MarshalBinary()
+		So(err, ShouldBeNil)
+		info, err := Inspect(bytes.NewReader(out), int64(len(out)))
+		So(err, ShouldBeNil)
+		So(info.Version, ShouldEqual, formatVersion)
+		So(info.Compacted, ShouldBeTrue)
+		So(info.Compressed, ShouldBeFalse)
+		So(info.Num, ShouldEqual, wm.Num())
+		So(info.Dim, ShouldEqual, wm.Dim())
+		So(info.Blen, ShouldEqual, wm.blen)
+		So(info.Size, ShouldEqual, len(out))
+		So(info.AlphabetSize, ShouldEqual, 8+8*11+4)
+		So(len(info.LayerSizes), ShouldEqual, wm.blen)
+		for _, size := range info.LayerSizes {
+			So(size, ShouldEqual, 8+8+8*((3000+63)/64)+4)
+		}
+
+		_, err = Inspect(bytes.NewReader(out[:len(out)-1]), int64(len(out)-1))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,112 @@
+package watrix
+
+import (
+	"io"
+
+	"github.com/ugorji/go/codec"
+)
+
+// The legacy form is a sequence of msgpack values: the number of layers,
+// the layers, dim, num and blen.  It starts with a msgpack positive fixint, i.e. a byte less
+// than 0x80, while the container form starts with formatMagic.
+
+// isLegacyForm reports whether a serialized matrix starting with b is in
+// the legacy form.
+func isLegacyForm(b byte) bool {
+	return b < 0x80
+}
+
+// unmarshalLegacyReader decodes WaveletMatrix from the legacy msgpack form
+// read from r within limits.  The layers are rsdic, and the matrix is
+// not compacted.
+func (wm *WaveletMatrix) unmarshalLegacyReader(r io.Reader, limits DecodeLimits) error {
+	var bh codec.MsgpackHandle
+	dec := codec.NewDecoder(r, &bh)
+
+	layerNum := uint64(0)
+	err := dec.Decode(&layerNum)
+	if err != nil {
+		return err
+	}
+	if err := limits.check(layerNum, 0); err != nil {
+		return err
+	}
+	rawLayers := make([][]byte, layerNum)
+	for i := range rawLayers {
+		err = dec.Decode(&rawLayers[i])
+		if err != nil {
+			return err
+		}
+	}
+	err = dec.Decode(&wm.dim)
+	if err != nil {
+		return err
+	}
+	err = dec.Decode(&wm.num)
+	if err != nil {
+		return err
+	}
+	err = dec.Decode(&wm.blen)
+	if err != nil {
+		return err
+	}
+	if err := limits.check(layerNum, wm.num); err != nil {
+		return err
+	}
+	wm.alphabet = nil
+	wm.backend = BackendRSDic
+	return wm.unmarshalLayers(rawLayers, false)
+}
+
+// unmarshalLayers decodes the layers in the backend of wm.  The layers are
+// in the form of marshalPortable if portable.
+//
+// A layer in the own form of a backend that is not portable, i.e. rsdic,
+// carries rank and select directories that the queries trust, so it is
+// rebuilt from its bits.
+func (wm *WaveletMatrix) unmarshalLayers(rawLayers [][]byte, portable bool) error {
+	info, err := wm.backend.info()
+	if err != nil {
+		return err
+	}
+	wm.layers = make([]BitVector, len(rawLayers))
+	for i, raw := range rawLayers {
+		layer, err := info.unmarshalLayer(raw, portable)
+		if err != nil {
+			return err
+		}
+		if layer.Num() != wm.num {
+			return corruptf("layer %d has %d bits, want %d", i, layer.Num(), wm.num)
+		}
+		if !portable && !info.portable {
+			builder := info.newBuilder()
+			for pos := uint64(0); pos < wm.num; pos++ {
+				builder.PushBack(layer.Bit(pos))
+			}
+			layer = builder.Build()
+		}
+		wm.layers[i] = layer
+	}
+	return nil
+}
+
+// ConvertLegacy reads a matrix in any form ReadFrom accepts, such as the
+// legacy msgpack form, from r and writes it in the current container form
+// to w with the options.  It returns the number of bytes written.
+func ConvertLegacy(w io.Writer, r io.Reader, opts ...EncodeOption) (int64, error) {
+	wm := new(WaveletMatrix)
+	if _, err := wm.ReadFrom(r); err != nil {
+		return 0, err
+	}
+	return wm.Encode(w, opts...)
+}
+
+// ConvertLegacyFile converts the file at inpath like ConvertLegacy and
+// writes the result to outpath.
+func ConvertLegacyFile(inpath, outpath string, opts ...EncodeOption) error {
+	wm := new(WaveletMatrix)
+	if err := wm.UnmarshalBinaryFile(inpath); err != nil {
+		return err
+	}
+	return wm.MarshalBinaryFile(outpath, opts...)
+}
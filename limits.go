@@ -0,0 +1,154 @@
+package watrix
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DecodeLimits bounds the resources spent on decoding a matrix, so that
+// hostile or corrupt input fails with an error wrapping ErrLimit rather
+// than exhausting the memory.  A zero field takes the value of
+// DefaultDecodeLimits.
+type DecodeLimits struct {
+	// MaxLayers is the maximum number of layers, i.e. blen.
+	MaxLayers uint64
+	// MaxBits is the maximum number of values, i.e. bits in a layer.
+	MaxBits uint64
+	// MaxBytes is the maximum number of bytes read from the input.
+	MaxBytes int64
+}
+
+// DefaultDecodeLimits are the limits of ReadFrom, UnmarshalBinary and
+// UnmarshalBinaryFile.
+var DefaultDecodeLimits = DecodeLimits{
+	MaxLayers: 64,
+	MaxBits:   1 << 40,
+	MaxBytes:  1 << 40,
+}
+
+// ErrLimit is returned when the input exceeds DecodeLimits.
+var ErrLimit = errors.New("watrix: decode limit exceeded")
+
+// withDefaults fills the zero fields of limits from DefaultDecodeLimits.
+func (limits DecodeLimits) withDefaults() DecodeLimits {
+	if limits.MaxLayers == 0 {
+		limits.MaxLayers = DefaultDecodeLimits.MaxLayers
+	}
+	if limits.MaxBits == 0 {
+		limits.MaxBits = DefaultDecodeLimits.MaxBits
+	}
+	if limits.MaxBytes == 0 {
+		limits.MaxBytes = DefaultDecodeLimits.MaxBytes
+	}
+	return limits
+}
+
+// check returns an error if layerNum layers of num bits exceed limits.
+func (limits DecodeLimits) check(layerNum, num uint64) error {
+	if layerNum > limits.MaxLayers || layerNum > 64 {
+		return fmt.Errorf("%w: %d layers", ErrLimit, layerNum)
+	}
+	if num > limits.MaxBits {
+		return fmt.Errorf("%w: %d bits", ErrLimit, num)
+	}
+	return nil
+}
+
+// maxLayerBytes returns the size of a decompressed layer of num bits in
+// the form of marshalPortable.
+func maxLayerBytes(num uint64) int64 {
+	return int64(8 + 8*((num+63)/64))
+}
+
+// ReadFromLimited is ReadFrom with limits instead of DefaultDecodeLimits.
+func (wm *WaveletMatrix) ReadFromLimited(r io.Reader, limits DecodeLimits) (int64, error) {
+	limits = limits.withDefaults()
+	cr := &countingReader{r: &limitedReader{r: r, left: limits.MaxBytes}}
+	// Decode aside, so that wm is left as it was if the input is bad.
+	decoded := new(WaveletMatrix)
+	if err := decoded.readLimited(cr, limits); err != nil {
+		return cr.n, err
+	}
+	*wm = *decoded
+	return cr.n, nil
+}
+
+func (wm *WaveletMatrix) readLimited(r io.Reader, limits DecodeLimits) (err error) {
+	defer recoverCorrupt(&err)
+	var head [1]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if err == io.EOF {
+			return ErrBadMagic
+		}
+		return err
+	}
+	r = io.MultiReader(bytes.NewReader(head[:]), r)
+	if isLegacyForm(head[0]) {
+		err = wm.unmarshalLegacyReader(r, limits)
+	} else {
+		err = wm.readContainer(r, limits)
+	}
+	if err != nil {
+		return err
+	}
+	return wm.Validate()
+}
+
+// recoverCorrupt turns a panic while decoding into an error wrapping
+// ErrCorrupt.  It must be deferred directly.
+func recoverCorrupt(err *error) {
+	if p := recover(); p != nil {
+		*err = corruptf("decoding panicked: %v", p)
+	}
+}
+
+// limitedReader reads at most left bytes from r, and fails with ErrLimit
+// after them.  Unlike io.LimitedReader, it tells the limit from the end of
+// the input.
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.left <= 0 {
+		// Find whether the input is longer than the limit.
+		var probe [1]byte
+		if n, err := lr.r.Read(probe[:]); n == 0 {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: the input is too long", ErrLimit)
+	}
+	if int64(len(p)) > lr.left {
+		p = p[:lr.left]
+	}
+	n, err := lr.r.Read(p)
+	lr.left -= int64(n)
+	return n, err
+}
+
+// readChunk is the size up to which readPayload allocates a payload at
+// once.  Longer payloads grow as they are read, so a forged length does
+// not allocate more than the input holds.
+const readChunk = 1 << 20
+
+// readPayload reads n bytes from r.
+func readPayload(r io.Reader, n uint64) ([]byte, error) {
+	if n <= readChunk {
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return payload, nil
+	}
+	if int64(n) < 0 {
+		return nil, fmt.Errorf("%w: a section of %d bytes", ErrLimit, n)
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,264 @@
+package watrix
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDecodeLimits(t *testing.T) {
+	Convey("When a matrix exceeds the limits", t, func() {
+		vals := make([]uint64, 1000)
+		for i := range vals {
+			vals[i] = uint64(i * 7 % 100)
+		}
+		out, err := buildFromSlice(vals).MarshalBinary()
+		So(err, ShouldBeNil)
+		legacy, err := os.ReadFile("testdata/legacy.bin")
+		So(err, ShouldBeNil)
+
+		for _, in := range [][]byte{out, legacy} {
+			wm := new(WaveletMatrix)
+			_, err = wm.ReadFromLimited(bytes.NewReader(in), DecodeLimits{MaxBits: 999})
+			So(errors.Is(err, ErrLimit), ShouldBeTrue)
+			_, err = wm.ReadFromLimited(bytes.NewReader(in), DecodeLimits{MaxLayers: 6})
+			So(errors.Is(err, ErrLimit), ShouldBeTrue)
+			_, err = wm.ReadFromLimited(bytes.NewReader(in), DecodeLimits{MaxBytes: int64(len(in) - 1)})
+			So(errors.Is(err, ErrLimit), ShouldBeTrue)
+
+			n, err := wm.ReadFromLimited(bytes.NewReader(in), DecodeLimits{MaxBits: 1000, MaxLayers: 7, MaxBytes: int64(len(in))})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(in))
+			So(wm.Lookup(999), ShouldEqual, vals[999])
+		}
+	})
+
+	Convey("When the input is forged", t, func() {
+		wm := new(WaveletMatrix)
+		// A legacy form claiming 127 layers
+		So(errors.Is(wm.UnmarshalBinary([]byte{0x7f}), ErrLimit), ShouldBeTrue)
+
+		// A section claiming 2^62 bytes
+		in := append([]byte(nil), formatMagic[:]...)
+		in = binary.LittleEndian.AppendUint32(in, formatVersion)
+		in = binary.LittleEndian.AppendUint64(in, 1<<62)
+		in = append(in, make([]byte, 100)...)
+		So(wm.UnmarshalBinary(in), ShouldEqual, io.ErrUnexpectedEOF)
+
+		// A layer of 64 bits decompressing to 1MB
+		out, err := buildFromSlice([]uint64{1}).MarshalBinary()
+		So(err, ShouldBeNil)
+		bomb, err := compressLayer(make([]byte, 1<<20), flate.BestCompression)
+		So(err, ShouldBeNil)
+		in = out[:formatPreambleLen]
+		header := make([]byte, formatHeaderLen)
+		binary.LittleEndian.PutUint32(header[0:], flagFlate)
+		binary.LittleEndian.PutUint64(header[16:], 64)
+		binary.LittleEndian.PutUint64(header[24:], 1)
+		binary.LittleEndian.PutUint64(header[32:], 1)
+		var buf bytes.Buffer
+		buf.Write(in)
+		So(writeSection(&buf, header), ShouldBeNil)
+		So(writeSection(&buf, bomb), ShouldBeNil)
+		So(errors.Is(wm.UnmarshalBinary(buf.Bytes()), ErrLimit), ShouldBeTrue)
+
+		// A legacy layer that is not a valid rsdic
+		in = []byte{0x01, 0xc4, 0x03, 0xff, 0xff, 0xff, 0x01, 0x01, 0x01}
+		So(wm.UnmarshalBinary(in), ShouldNotBeNil)
+	})
+
+	Convey("When the header of a framed form is forged", t, func() {
+		framed := func(magic [8]byte, header ...uint64) []byte {
+			fw := newFramedWriter(magic)
+			fw.section(appendUint64s(nil, header...))
+			return fw.bytes()
+		}
+		mm := new(MultiMatrix)
+		So(errors.Is(mm.UnmarshalBinary(framed(multiMagic, 2, 4, 4, 1<<40)), ErrLimit), ShouldBeTrue)
+		So(errors.Is(mm.UnmarshalBinary(framed(multiMagic, 4, 4, 4, 17)), ErrLimit), ShouldBeTrue)
+		So(errors.Is(mm.UnmarshalBinary(framed(multiMagic, 4, 4, 1<<50, 1)), ErrLimit), ShouldBeTrue)
+		So(mm.UnmarshalBinary(framed(multiMagic, 4, 4, 4, 16)), ShouldEqual, io.ErrUnexpectedEOF)
+
+		hm := new(HuffmanMatrix)
+		So(errors.Is(hm.UnmarshalBinary(framed(huffmanMagic, 4, 4, 1<<40)), ErrLimit), ShouldBeTrue)
+		So(errors.Is(hm.UnmarshalBinary(framed(huffmanMagic, 4, 4, 65)), ErrLimit), ShouldBeTrue)
+		So(errors.Is(hm.UnmarshalBinary(framed(huffmanMagic, 4, 1<<50, 1)), ErrLimit), ShouldBeTrue)
+		So(hm.UnmarshalBinary(framed(huffmanMagic, 4, 4, 64)), ShouldEqual, io.ErrUnexpectedEOF)
+	})
+}
+
+func fuzzSeeds(f *testing.F) {
+	in, err := os.ReadFile("testdata/legacy.bin")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(in)
+	vals := []uint64{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5}
+	for backend := range backends {
+		wmb := NewBuilder()
+		for _, val := range vals {
+			wmb.PushBack(val << 30)
+		}
+		for _, wm := range []*WaveletMatrix{wmb.Build(WithBackend(backend)), wmb.Build(WithBackend(backend), WithCompactAlphabet())} {
+			var buf bytes.Buffer
+			if _, err := wm.Encode(&buf, WithCompression(flate.BestSpeed)); err != nil {
+				f.Fatal(err)
+			}
+			f.Add(buf.Bytes())
+			out, err := wm.MarshalBinary()
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(out)
+		}
+	}
+}
+
+// exerciseDecoded runs queries on wm decoded from fuzzed input, which
+// must not panic, and checks that it survives a round trip.
+func exerciseDecoded(t *testing.T, wm *WaveletMatrix) {
+	num := wm.Num()
+	for pos := uint64(0); pos < num && pos < 64; pos++ {
+		val, rank := wm.LookupAndRank(pos)
+		if got := wm.Select(rank, val); got != pos {
+			t.Fatalf("Select(%d, %d) = %d, want %d", rank, val, got, pos)
+		}
+		wm.RangedRankOp(Range{pos, num}, val, OpLessThan)
+		wm.Quantile(Range{pos, num}, 0)
+	}
+	out, err := wm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := new(WaveletMatrix)
+	if err := loaded.UnmarshalBinary(out); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Num() != num || (num > 0 && loaded.Lookup(num-1) != wm.Lookup(num-1)) {
+		t.Fatal("the round trip changed the matrix")
+	}
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, in []byte) {
+		wm := new(WaveletMatrix)
+		if err := wm.UnmarshalBinary(in); err != nil {
+			return
+		}
+		exerciseDecoded(t, wm)
+	})
+}
+
+func FuzzUnmarshalBinaryFile(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, in []byte) {
+		path := filepath.Join(t.TempDir(), "fuzz.bin")
+		if err := os.WriteFile(path, in, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		wm := new(WaveletMatrix)
+		if err := wm.UnmarshalBinaryFile(path); err != nil {
+			return
+		}
+		exerciseDecoded(t, wm)
+	})
+}
+
+// framedSeeds adds the framed forms of small matrices of each type.
+func framedSeeds(f *testing.F, m encoding.BinaryMarshaler) {
+	out, err := m.MarshalBinary()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(out)
+}
+
+func fuzzBuilder() *WaveletMatrixBuilder {
+	wmb := NewBuilder()
+	for _, val := range []uint64{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 1 << 40} {
+		wmb.PushBack(val)
+	}
+	return wmb
+}
+
+func FuzzStringMatrix(f *testing.F) {
+	sb := NewStringBuilder()
+	for _, s := range []string{"b", "a", "", "c", "a"} {
+		sb.PushBack(s)
+	}
+	framedSeeds(f, sb.Build())
+	f.Fuzz(func(t *testing.T, in []byte) {
+		sm := new(StringMatrix)
+		if err := sm.UnmarshalBinary(in); err != nil {
+			return
+		}
+		for pos := uint64(0); pos < sm.Num() && pos < 64; pos++ {
+			s := sm.Lookup(pos)
+			if got := sm.Select(sm.Rank(pos, s), s); got != pos {
+				t.Fatalf("Select of %q at %d is %d", s, pos, got)
+			}
+		}
+	})
+}
+
+func FuzzCompositeMatrix(f *testing.F) {
+	schema, err := NewSchema(Field{"service", 8}, Field{"status", 4})
+	if err != nil {
+		f.Fatal(err)
+	}
+	cb := NewCompositeBuilder(schema)
+	cb.PushBack(3, 1)
+	cb.PushBack(1, 2)
+	framedSeeds(f, cb.Build())
+	f.Fuzz(func(t *testing.T, in []byte) {
+		cm := new(CompositeMatrix)
+		if err := cm.UnmarshalBinary(in); err != nil {
+			return
+		}
+		for pos := uint64(0); pos < cm.Num() && pos < 64; pos++ {
+			cm.Lookup(pos)
+		}
+	})
+}
+
+func FuzzMultiMatrix(f *testing.F) {
+	framedSeeds(f, fuzzBuilder().BuildMultiary(2))
+	framedSeeds(f, fuzzBuilder().BuildMultiary(4))
+	f.Fuzz(func(t *testing.T, in []byte) {
+		mm := new(MultiMatrix)
+		if err := mm.UnmarshalBinary(in); err != nil {
+			return
+		}
+		for pos := uint64(0); pos < mm.Num() && pos < 64; pos++ {
+			val, rank := mm.LookupAndRank(pos)
+			if got := mm.Select(rank, val); got != pos {
+				t.Fatalf("Select(%d, %d) = %d, want %d", rank, val, got, pos)
+			}
+		}
+	})
+}
+
+func FuzzHuffmanMatrix(f *testing.F) {
+	framedSeeds(f, fuzzBuilder().BuildHuffman())
+	f.Fuzz(func(t *testing.T, in []byte) {
+		hm := new(HuffmanMatrix)
+		if err := hm.UnmarshalBinary(in); err != nil {
+			return
+		}
+		for pos := uint64(0); pos < hm.Num() && pos < 64; pos++ {
+			val, rank := hm.LookupAndRank(pos)
+			if got := hm.Select(rank, val); got != pos {
+				t.Fatalf("Select(%d, %d) = %d, want %d", rank, val, got, pos)
+			}
+		}
+	})
+}
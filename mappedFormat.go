@@ -0,0 +1,339 @@
+package watrix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// The mapped form of WaveletMatrix is a flat layout that OpenMapped serves
+// queries from without decoding.  Every layer is stored as an
+// interleavedBitVector, i.e. its blocks and select hints, each array
+// starting at a multiple of 64 bytes:
+//
+//	magic    [8]byte  mappedMagic
+//	version  uint32   mappedVersion
+//	flags    uint32   flagCompacted
+//	header   uint64s  dim, num, blen, layerNum, alphabetOff, alphabetLen
+//	table    uint64s  num, oneNum, dataOff, dataLen, onesOff, onesLen,
+//	                  zerosOff, zerosLen for each layer
+//	arrays   the blocks and the alphabet as uint64s, the hints as uint32s
+//
+// All integers are little-endian.  There are no checksums.  OpenMapped
+// checks the header and the table, and runs Validate, without reading the
+// blocks; ValidateDeep checks every block against its bits.
+
+var mappedMagic = [8]byte{0x89, 'W', 'M', 'M', '\r', '\n', 0x1a, '\n'}
+
+const (
+	mappedVersion     = 1
+	mappedAlign       = 64
+	mappedHeaderLen   = 64
+	mappedTableFields = 8
+)
+
+// mappedLayer is the place of a layer in the mapped form.
+type mappedLayer struct {
+	bv                         *interleavedBitVector
+	dataOff, onesOff, zerosOff uint64
+	dataLen, onesLen, zerosLen uint64
+	num, oneNum                uint64
+}
+
+// WriteMapped writes wm in the mapped form, which OpenMapped reads, to w.
+// It returns the number of bytes written.
+func (wm *WaveletMatrix) WriteMapped(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := wm.writeMapped(cw)
+	return cw.n, err
+}
+
+// MarshalMappedFile writes wm in the mapped form to a file.
+func (wm *WaveletMatrix) MarshalMappedFile(outpath string) (err error) {
+	f, err := os.Create(outpath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	bufWriter := bufio.NewWriter(f)
+	if _, err = wm.WriteMapped(bufWriter); err != nil {
+		return err
+	}
+	return bufWriter.Flush()
+}
+
+func alignUp(off uint64) uint64 {
+	return (off + mappedAlign - 1) / mappedAlign * mappedAlign
+}
+
+func (wm *WaveletMatrix) writeMapped(w *countingWriter) error {
+	layers := make([]mappedLayer, len(wm.layers))
+	off := alignUp(mappedHeaderLen + uint64(len(layers))*mappedTableFields*8)
+	for i, layer := range wm.layers {
+		bv, ok := layer.(*interleavedBitVector)
+		if !ok {
+			bv = newInterleavedBitVector()
+			bv.num = layer.Num()
+			bv.buildBlocks(bitVectorWords(layer))
+		}
+		l := mappedLayer{
+			bv:       bv,
+			num:      bv.num,
+			oneNum:   bv.oneNum,
+			dataLen:  uint64(len(bv.data)),
+			onesLen:  uint64(len(bv.selectOnes)),
+			zerosLen: uint64(len(bv.selectZeros)),
+		}
+		l.dataOff = off
+		l.onesOff = alignUp(l.dataOff + 8*l.dataLen)
+		l.zerosOff = alignUp(l.onesOff + 4*l.onesLen)
+		off = alignUp(l.zerosOff + 4*l.zerosLen)
+		layers[i] = l
+	}
+	alphabetOff := off
+
+	flags := uint32(0)
+	if wm.alphabet != nil {
+		flags |= flagCompacted
+	}
+	header := make([]byte, mappedHeaderLen, mappedHeaderLen+len(layers)*mappedTableFields*8)
+	copy(header, mappedMagic[:])
+	binary.LittleEndian.PutUint32(header[8:], mappedVersion)
+	binary.LittleEndian.PutUint32(header[12:], flags)
+	for i, val := range []uint64{wm.dim, wm.num, wm.blen, uint64(len(layers)), alphabetOff, uint64(len(wm.alphabet))} {
+		binary.LittleEndian.PutUint64(header[16+8*i:], val)
+	}
+	for _, l := range layers {
+		for _, val := range []uint64{l.num, l.oneNum, l.dataOff, l.dataLen, l.onesOff, l.onesLen, l.zerosOff, l.zerosLen} {
+			header = binary.LittleEndian.AppendUint64(header, val)
+		}
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, l := range layers {
+		if err := writeMappedPadding(w, l.dataOff); err != nil {
+			return err
+		}
+		if err := writeMappedWords(w, l.bv.data); err != nil {
+			return err
+		}
+		for _, hints := range []struct {
+			off  uint64
+			vals []uint32
+		}{{l.onesOff, l.bv.selectOnes}, {l.zerosOff, l.bv.selectZeros}} {
+			if err := writeMappedPadding(w, hints.off); err != nil {
+				return err
+			}
+			buf := make([]byte, 0, 4*len(hints.vals))
+			for _, val := range hints.vals {
+				buf = binary.LittleEndian.AppendUint32(buf, val)
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	if err := writeMappedPadding(w, alphabetOff); err != nil {
+		return err
+	}
+	return writeMappedWords(w, wm.alphabet)
+}
+
+// writeMappedPadding writes zeros up to off.
+func writeMappedPadding(w *countingWriter, off uint64) error {
+	var zeros [mappedAlign]byte
+	_, err := w.Write(zeros[:off-uint64(w.n)])
+	return err
+}
+
+// writeMappedWords writes words as little-endian uint64s.
+func writeMappedWords(w io.Writer, words []uint64) error {
+	const chunk = 4096
+	buf := make([]byte, 0, 8*chunk)
+	for beg := 0; beg < len(words); beg += chunk {
+		buf = buf[:0]
+		for i := beg; i < len(words) && i < beg+chunk; i++ {
+			buf = binary.LittleEndian.AppendUint64(buf, words[i])
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MappedMatrix is a WaveletMatrix served from a read-only memory mapping
+// of a file written by MarshalMappedFile.  The layers are not copied, so
+// opening needs no memory for them, and processes mapping the same file
+// share the page cache.
+//
+// All queries of WaveletMatrix are available.  Close unmaps the file, after
+// which queries through mp.WaveletMatrix, or any copy of that pointer, panic
+// rather than read the unmapped memory.  A WaveletMatrix copied by value
+// from it must not be used after Close, and Close must not run concurrently
+// with queries.
+type MappedMatrix struct {
+	*WaveletMatrix
+	mapping []byte
+}
+
+// OpenMapped maps the file written by MarshalMappedFile and returns
+// MappedMatrix serving queries from the mapping.  Opening takes O(blen)
+// time whatever the size of the file; the blocks are not checked, so a
+// damaged file may answer queries wrongly until ValidateDeep rejects it.
+func OpenMapped(inpath string) (*MappedMatrix, error) {
+	f, err := os.Open(inpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < mappedHeaderLen {
+		return nil, ErrBadMagic
+	}
+	if int64(int(fi.Size())) != fi.Size() {
+		return nil, fmt.Errorf("watrix: %s is too large to map", inpath)
+	}
+	mapping, err := mapFile(f, int(fi.Size()))
+	if err != nil {
+		return nil, err
+	}
+	var wm *WaveletMatrix
+	err = func() (err error) {
+		defer recoverCorrupt(&err)
+		if wm, err = parseMapped(mapping); err != nil {
+			return err
+		}
+		return wm.Validate()
+	}()
+	if err != nil {
+		unmapFile(mapping)
+		return nil, err
+	}
+	return &MappedMatrix{
+		WaveletMatrix: wm,
+		mapping:       mapping,
+	}, nil
+}
+
+// Close unmaps the file.
+func (mp *MappedMatrix) Close() error {
+	if mp.mapping == nil {
+		return nil
+	}
+	// The layers are shared with every copy of the pointer, so emptying
+	// them makes queries through any copy panic rather than fault.
+	for _, layer := range mp.layers {
+		bv := layer.(*interleavedBitVector)
+		*bv = interleavedBitVector{num: bv.num, oneNum: bv.oneNum}
+	}
+	mp.alphabet = nil
+	mapping := mp.mapping
+	mp.mapping = nil
+	return unmapFile(mapping)
+}
+
+// parseMapped returns WaveletMatrix whose layers and alphabet refer to buf,
+// which must be aligned to 8 bytes.
+func parseMapped(buf []byte) (*WaveletMatrix, error) {
+	if len(buf) < mappedHeaderLen || !bytes.Equal(buf[:len(mappedMagic)], mappedMagic[:]) {
+		return nil, ErrBadMagic
+	}
+	if version := binary.LittleEndian.Uint32(buf[8:]); version != mappedVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		return nil, errors.New("watrix: the mapped form needs a little-endian host")
+	}
+	flags := binary.LittleEndian.Uint32(buf[12:])
+	header := make([]uint64, 6)
+	for i := range header {
+		header[i] = binary.LittleEndian.Uint64(buf[16+8*i:])
+	}
+	wm := &WaveletMatrix{
+		dim:     header[0],
+		num:     header[1],
+		blen:    header[2],
+		backend: BackendInterleaved,
+	}
+	layerNum := header[3]
+	if layerNum != wm.blen || layerNum > 64 {
+		return nil, fmt.Errorf("watrix: %d layers for blen %d", layerNum, wm.blen)
+	}
+	if uint64(len(buf)) < mappedHeaderLen+layerNum*mappedTableFields*8 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	wm.layers = make([]BitVector, layerNum)
+	for i := range wm.layers {
+		table := make([]uint64, mappedTableFields)
+		for j := range table {
+			table[j] = binary.LittleEndian.Uint64(buf[mappedHeaderLen+(uint64(i)*mappedTableFields+uint64(j))*8:])
+		}
+		bv := &interleavedBitVector{
+			num:    table[0],
+			oneNum: table[1],
+		}
+		if bv.num != wm.num || bv.oneNum > bv.num {
+			return nil, fmt.Errorf("watrix: layer %d has %d bits, want %d", i, bv.num, wm.num)
+		}
+		if table[3] != (bv.num/interleavedBlockBits+1)*interleavedBlockWords {
+			return nil, fmt.Errorf("watrix: layer %d has %d words for %d bits", i, table[3], bv.num)
+		}
+		var err error
+		if bv.data, err = mappedWords(buf, table[2], table[3]); err != nil {
+			return nil, err
+		}
+		if bv.selectOnes, err = mappedUint32s(buf, table[4], table[5]); err != nil {
+			return nil, err
+		}
+		if bv.selectZeros, err = mappedUint32s(buf, table[6], table[7]); err != nil {
+			return nil, err
+		}
+		wm.layers[i] = bv
+	}
+	if flags&flagCompacted != 0 {
+		var err error
+		if wm.alphabet, err = mappedWords(buf, header[4], header[5]); err != nil {
+			return nil, err
+		}
+	}
+	return wm, nil
+}
+
+// mappedWords returns n uint64s at off of buf without copying.
+func mappedWords(buf []byte, off, n uint64) ([]uint64, error) {
+	if off%8 != 0 || off > uint64(len(buf)) || n > (uint64(len(buf))-off)/8 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if n == 0 {
+		return []uint64{}, nil
+	}
+	return unsafe.Slice((*uint64)(unsafe.Pointer(&buf[off])), n), nil
+}
+
+// mappedUint32s returns n uint32s at off of buf without copying.
+func mappedUint32s(buf []byte, off, n uint64) ([]uint32, error) {
+	if off%4 != 0 || off > uint64(len(buf)) || n > (uint64(len(buf))-off)/4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if n == 0 {
+		return []uint32{}, nil
+	}
+	return unsafe.Slice((*uint32)(unsafe.Pointer(&buf[off])), n), nil
+}
@@ -0,0 +1,95 @@
+package watrix
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenMapped(t *testing.T) {
+	for _, backend := range []Backend{BackendRSDic, BackendInterleaved} {
+		Convey("When a matrix on "+backend.String()+" is mapped", t, func() {
+			num := uint64(14000)
+			dim := uint64(100)
+			testNum := uint64(10)
+			orig := make([]uint64, num)
+			ranks := make([][]uint64, dim)
+			ranksLessThan := make([][]uint64, dim)
+			ranksMoreThan := make([][]uint64, dim)
+
+			wm := buildWaveletHelper(t, num, testNum, dim, orig, ranks, ranksLessThan, ranksMoreThan, WithBackend(backend))
+			path := filepath.Join(t.TempDir(), "mapped.bin")
+			So(wm.MarshalMappedFile(path), ShouldBeNil)
+
+			mp, err := OpenMapped(path)
+			So(err, ShouldBeNil)
+			So(mp.Backend(), ShouldEqual, BackendInterleaved)
+			So(mp.WaveletMatrix, shouldEqualMatrix, wm)
+
+			Convey("Queries should be served from the mapping", func() {
+				testWaveletHelper(t, mp.WaveletMatrix, num, testNum, dim, orig, ranks, ranksLessThan, ranksMoreThan)
+				saved := mp.WaveletMatrix
+				So(mp.Close(), ShouldBeNil)
+				So(mp.Close(), ShouldBeNil)
+				So(func() { saved.Lookup(0) }, ShouldPanic)
+			})
+		})
+	}
+	Convey("When a compacted matrix is mapped", t, func() {
+		wmb := NewBuilder()
+		for i := uint64(0); i < 5000; i++ {
+			wmb.PushBack(i % 13 << 50)
+		}
+		wm := wmb.Build(WithCompactAlphabet())
+		path := filepath.Join(t.TempDir(), "mapped.bin")
+		So(wm.MarshalMappedFile(path), ShouldBeNil)
+		mp, err := OpenMapped(path)
+		So(err, ShouldBeNil)
+		defer mp.Close()
+		So(mp.Compacted(), ShouldBeTrue)
+		So(mp.WaveletMatrix, shouldEqualMatrix, wm)
+		So(mp.Rank(5000, 3<<50), ShouldEqual, wm.Rank(5000, 3<<50))
+		So(mp.Quantile(Range{0, 5000}, 4000), ShouldEqual, wm.Quantile(Range{0, 5000}, 4000))
+	})
+	Convey("When a mapped file is broken", t, func() {
+		wm := buildFromSlice([]uint64{3, 1, 4, 1, 5, 9, 2, 6})
+		dir := t.TempDir()
+		path := filepath.Join(dir, "mapped.bin")
+		So(wm.MarshalMappedFile(path), ShouldBeNil)
+		in, err := os.ReadFile(path)
+		So(err, ShouldBeNil)
+
+		truncated := filepath.Join(dir, "truncated.bin")
+		So(os.WriteFile(truncated, in[:len(in)-64], 0644), ShouldBeNil)
+		_, err = OpenMapped(truncated)
+		So(err, ShouldNotBeNil)
+
+		// Forge the first layer: its block counter, a bit behind the
+		// counters, and its first select hint of ones.
+		dataOff := binary.LittleEndian.Uint64(in[mappedHeaderLen+16:])
+		onesOff := binary.LittleEndian.Uint64(in[mappedHeaderLen+32:])
+		for _, off := range []uint64{dataOff + 8, dataOff + 16, onesOff} {
+			forged := append([]byte(nil), in...)
+			forged[off]++
+			forgedPath := filepath.Join(dir, "forged.bin")
+			So(os.WriteFile(forgedPath, forged, 0644), ShouldBeNil)
+			// Opening reads no blocks, so the damage may only show in
+			// ValidateDeep.
+			mp, err := OpenMapped(forgedPath)
+			if err == nil {
+				err = mp.ValidateDeep()
+				mp.Close()
+			}
+			So(errors.Is(err, ErrCorrupt), ShouldBeTrue)
+		}
+
+		other := filepath.Join(dir, "other.bin")
+		So(wm.MarshalBinaryFile(other), ShouldBeNil)
+		_, err = OpenMapped(other)
+		So(err, ShouldEqual, ErrBadMagic)
+	})
+}
@@ -0,0 +1,24 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package watrix
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+// mapFile reads size bytes of f into an aligned buffer where mmap is not
+// available.  The buffer is not shared between processes.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	words := alignedWords((size + 7) / 8)
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), size)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func unmapFile(mapping []byte) error {
+	return nil
+}
@@ -0,0 +1,310 @@
+package watrix
+
+import (
+	"fmt"
+)
+
+// MultiMatrix is a multi-ary wavelet matrix.
+//
+// Each layer stores bitsPerLayer (2 or 4) bits of every value as a symbol,
+// so a 4-ary (16-ary) matrix has half (a quarter) as many layers as
+// WaveletMatrix.  Lookup, Rank, Select and Quantile visit one layer per
+// symbol instead of one per bit.
+type MultiMatrix struct {
+	layers       []*symbolVector
+	dim          uint64
+	num          uint64
+	bitsPerLayer uint64
+	blen         uint64 // =len(layers)*bitsPerLayer
+}
+
+// BuildMultiary constructs a MultiMatrix that stores bitsPerLayer bits per
+// layer.  bitsPerLayer must be 2 (4-ary) or 4 (16-ary).
+func (wmb *WaveletMatrixBuilder) BuildMultiary(bitsPerLayer uint64) *MultiMatrix {
+	if bitsPerLayer != 2 && bitsPerLayer != 4 {
+		panic(fmt.Sprintf("watrix: unsupported bitsPerLayer %d", bitsPerLayer))
+	}
+	layerNum := (getBinaryLen(wmb.dim) + bitsPerLayer - 1) / bitsPerLayer
+	mm := &MultiMatrix{
+		layers:       make([]*symbolVector, layerNum),
+		dim:          wmb.dim,
+		num:          uint64(len(wmb.vals)),
+		bitsPerLayer: bitsPerLayer,
+		blen:         layerNum * bitsPerLayer,
+	}
+	cur := wmb.vals
+	for depth := uint64(0); depth < layerNum; depth++ {
+		sv := newSymbolVector(bitsPerLayer)
+		buckets := make([][]uint64, sv.sigma())
+		for _, val := range cur {
+			c := mm.digit(val, depth)
+			sv.pushBack(c)
+			buckets[c] = append(buckets[c], val)
+		}
+		sv.buildIndex()
+		mm.layers[depth] = sv
+		next := make([]uint64, 0, len(cur))
+		for _, bucket := range buckets {
+			next = append(next, bucket...)
+		}
+		cur = next
+	}
+	return mm
+}
+
+// digit returns the symbol of val stored in layer depth.
+func (mm *MultiMatrix) digit(val uint64, depth uint64) uint64 {
+	return (val >> (mm.blen - (depth+1)*mm.bitsPerLayer)) & ((1 << mm.bitsPerLayer) - 1)
+}
+
+// Num return the number of values in T
+func (mm *MultiMatrix) Num() uint64 {
+	return mm.num
+}
+
+// Dim returns (max. of T[0...Num) + 1)
+func (mm *MultiMatrix) Dim() uint64 {
+	return mm.dim
+}
+
+// BitsPerLayer returns the number of bits stored per layer.
+func (mm *MultiMatrix) BitsPerLayer() uint64 {
+	return mm.bitsPerLayer
+}
+
+// Lookup returns T[pos]
+func (mm *MultiMatrix) Lookup(pos uint64) uint64 {
+	val := uint64(0)
+	for _, sv := range mm.layers {
+		c := sv.access(pos)
+		val = (val << mm.bitsPerLayer) | c
+		pos = sv.starts[c] + sv.rank(c, pos)
+	}
+	return val
+}
+
+// LookupAndRank returns T[pos] and Rank(pos, T[pos]) in one call.
+// Faster than calling Lookup and Rank separately.
+func (mm *MultiMatrix) LookupAndRank(pos uint64) (uint64, uint64) {
+	val := uint64(0)
+	bpos := uint64(0)
+	epos := pos
+	for _, sv := range mm.layers {
+		c := sv.access(epos)
+		val = (val << mm.bitsPerLayer) | c
+		bpos = sv.starts[c] + sv.rank(c, bpos)
+		epos = sv.starts[c] + sv.rank(c, epos)
+	}
+	return val, epos - bpos
+}
+
+// Rank returns the number of c (== val) in T[0...pos)
+func (mm *MultiMatrix) Rank(pos uint64, val uint64) uint64 {
+	return mm.RangedRankOp(Range{0, pos}, val, OpEqual)
+}
+
+// RankLessThan returns the number of c (< val) in T[0...pos)
+func (mm *MultiMatrix) RankLessThan(pos uint64, val uint64) uint64 {
+	return mm.RangedRankOp(Range{0, pos}, val, OpLessThan)
+}
+
+// RankMoreThan returns the number of c (> val) in T[0...pos)
+func (mm *MultiMatrix) RankMoreThan(pos uint64, val uint64) uint64 {
+	return mm.RangedRankOp(Range{0, pos}, val, OpMoreThan)
+}
+
+// RangedRankOp returns the number of c that satisfies 'c op val'
+// in T[posRange.Beg, posRange.End).
+// The op should be one of {OpEqual, OpLessThan, OpMoreThan}.
+func (mm *MultiMatrix) RangedRankOp(posRange Range, val uint64, op int) uint64 {
+	if getBinaryLen(val) > mm.blen {
+		// val is larger than any value that fits in blen bits.
+		if op == OpLessThan {
+			return posRange.End - posRange.Beg
+		}
+		return 0
+	}
+	rankLessThan := uint64(0)
+	rankMoreThan := uint64(0)
+	for depth, sv := range mm.layers {
+		d := mm.digit(val, uint64(depth))
+		if op == OpLessThan {
+			for c := uint64(0); c < d; c++ {
+				rankLessThan += sv.rank(c, posRange.End) - sv.rank(c, posRange.Beg)
+			}
+		}
+		if op == OpMoreThan {
+			for c := d + 1; c < sv.sigma(); c++ {
+				rankMoreThan += sv.rank(c, posRange.End) - sv.rank(c, posRange.Beg)
+			}
+		}
+		posRange.Beg = sv.starts[d] + sv.rank(d, posRange.Beg)
+		posRange.End = sv.starts[d] + sv.rank(d, posRange.End)
+	}
+	switch op {
+	case OpEqual:
+		return posRange.End - posRange.Beg
+	case OpLessThan:
+		return rankLessThan
+	case OpMoreThan:
+		return rankMoreThan
+	default:
+		return 0
+	}
+}
+
+// RangedRankRange searches T[posRange.Beg, posRange.End) and
+// returns the number of c that falls within valueRange
+// i.e. [valueRange.Beg, valueRange.End).
+func (mm *MultiMatrix) RangedRankRange(posRange Range, valueRange Range) uint64 {
+	end := mm.RangedRankOp(posRange, valueRange.End, OpLessThan)
+	beg := mm.RangedRankOp(posRange, valueRange.Beg, OpLessThan)
+	return end - beg
+}
+
+// Select returns the position of (rank+1)-th val in T.
+// If no match has been found, it returns Num().
+func (mm *MultiMatrix) Select(rank uint64, val uint64) uint64 {
+	return mm.RangedSelect(Range{0, mm.num}, rank, val)
+}
+
+// RangedSelect takes T[posRange) and returns the position of rank+1'th val.
+// If no match has been found, it returns posRange.End.
+func (mm *MultiMatrix) RangedSelect(posRange Range, rank uint64, val uint64) uint64 {
+	if getBinaryLen(val) > mm.blen {
+		return posRange.End
+	}
+	r := posRange
+	for depth, sv := range mm.layers {
+		d := mm.digit(val, uint64(depth))
+		r.Beg = sv.starts[d] + sv.rank(d, r.Beg)
+		r.End = sv.starts[d] + sv.rank(d, r.End)
+	}
+	pos := r.Beg + rank
+	if r.End <= pos {
+		return posRange.End
+	}
+	for depth := len(mm.layers) - 1; depth >= 0; depth-- {
+		sv := mm.layers[depth]
+		d := mm.digit(val, uint64(depth))
+		pos = sv.selectSymbol(d, pos-sv.starts[d])
+	}
+	return pos
+}
+
+// Quantile returns (k+1)th smallest value in T[posRange.Beg, posRange.End).
+func (mm *MultiMatrix) Quantile(posRange Range, k uint64) uint64 {
+	val := uint64(0)
+	bpos, epos := posRange.Beg, posRange.End
+	for _, sv := range mm.layers {
+		c := uint64(0)
+		for ; c+1 < sv.sigma(); c++ {
+			nb, ne := sv.rank(c, bpos), sv.rank(c, epos)
+			if k < ne-nb {
+				break
+			}
+			k -= ne - nb
+		}
+		val = (val << mm.bitsPerLayer) | c
+		bpos = sv.starts[c] + sv.rank(c, bpos)
+		epos = sv.starts[c] + sv.rank(c, epos)
+	}
+	return val
+}
+
+// Intersect returns values that occur at least k ranges.
+func (mm *MultiMatrix) Intersect(ranges []Range, k int) []uint64 {
+	return mm.intersectHelper(ranges, k, 0, 0)
+}
+
+func (mm *MultiMatrix) intersectHelper(ranges []Range, k int, depth uint64, prefix uint64) []uint64 {
+	if depth == uint64(len(mm.layers)) {
+		return []uint64{prefix}
+	}
+	sv := mm.layers[depth]
+	ret := make([]uint64, 0)
+	for c := uint64(0); c < sv.sigma(); c++ {
+		next := make([]Range, 0, len(ranges))
+		for _, posRange := range ranges {
+			beg := sv.starts[c] + sv.rank(c, posRange.Beg)
+			end := sv.starts[c] + sv.rank(c, posRange.End)
+			if end > beg {
+				next = append(next, Range{beg, end})
+			}
+		}
+		if len(next) >= k {
+			ret = append(ret, mm.intersectHelper(next, k, depth+1, (prefix<<mm.bitsPerLayer)|c)...)
+		}
+	}
+	return ret
+}
+
+// MarshalBinary encodes MultiMatrix into the framed form and returns the
+// result.  The counters are not stored; they are rebuilt by UnmarshalBinary.
+func (mm *MultiMatrix) MarshalBinary() ([]byte, error) {
+	fw := newFramedWriter(multiMagic)
+	fw.section(appendUint64s(nil, mm.bitsPerLayer, mm.dim, mm.num, uint64(len(mm.layers))))
+	for _, sv := range mm.layers {
+		fw.section(appendUint64s(nil, sv.words...))
+	}
+	return fw.bytes(), nil
+}
+
+// UnmarshalBinary decodes MultiMatrix from a binary form generated MarshalBinary.
+func (mm *MultiMatrix) UnmarshalBinary(in []byte) (err error) {
+	defer recoverCorrupt(&err)
+	fr, err := newFramedReader(in, multiMagic)
+	if err != nil {
+		return err
+	}
+	payload, err := fr.section()
+	if err != nil {
+		return err
+	}
+	header, err := uint64s(payload)
+	if err != nil {
+		return err
+	}
+	if len(header) != 4 {
+		return corruptf("the header has %d fields, want 4", len(header))
+	}
+	bitsPerLayer, dim, num, layerNum := header[0], header[1], header[2], header[3]
+	if bitsPerLayer != 2 && bitsPerLayer != 4 {
+		return fmt.Errorf("watrix: unsupported bitsPerLayer %d", bitsPerLayer)
+	}
+	// A layer holds bitsPerLayer bits of values of up to 64 bits.
+	if layerNum > (64+bitsPerLayer-1)/bitsPerLayer {
+		return fmt.Errorf("%w: %d layers of %d bits", ErrLimit, layerNum, bitsPerLayer)
+	}
+	if err := fr.limits.check(layerNum, num); err != nil {
+		return err
+	}
+	var layers []*symbolVector
+	for i := uint64(0); i < layerNum; i++ {
+		payload, err := fr.section()
+		if err != nil {
+			return err
+		}
+		sv := newSymbolVector(bitsPerLayer)
+		if sv.words, err = uint64s(payload); err != nil {
+			return err
+		}
+		if want := (num + sv.perWord() - 1) / sv.perWord(); uint64(len(sv.words)) != want {
+			return corruptf("layer %d has %d words for %d values", i, len(sv.words), num)
+		}
+		sv.num = num
+		sv.buildIndex()
+		layers = append(layers, sv)
+	}
+	if err := fr.finish(); err != nil {
+		return err
+	}
+	*mm = MultiMatrix{
+		layers:       layers,
+		dim:          dim,
+		num:          num,
+		bitsPerLayer: bitsPerLayer,
+		blen:         layerNum * bitsPerLayer,
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package watrix
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSymbolVector(t *testing.T) {
+	Convey("When random symbols are pushed", t, func() {
+		for _, w := range []uint64{2, 4} {
+			sv := newSymbolVector(w)
+			num := uint64(70000) // more than one superblock
+			orig := make([]uint64, num)
+			for i := range orig {
+				orig[i] = uint64(rand.Intn(int(sv.sigma())))
+				sv.pushBack(orig[i])
+			}
+			sv.buildIndex()
+			counts := make([]uint64, sv.sigma())
+			for pos, c := range orig {
+				So(sv.access(uint64(pos)), ShouldEqual, c)
+				if pos%997 == 0 {
+					for d := uint64(0); d < sv.sigma(); d++ {
+						So(sv.rank(d, uint64(pos)), ShouldEqual, counts[d])
+					}
+					So(sv.selectSymbol(c, counts[c]), ShouldEqual, pos)
+				}
+				counts[c]++
+			}
+			for d := uint64(0); d < sv.sigma(); d++ {
+				So(sv.rank(d, num), ShouldEqual, counts[d])
+			}
+		}
+	})
+}
+
+func TestMultiMatrix(t *testing.T) {
+	Convey("When a vector is empty", t, func() {
+		mm := NewBuilder().BuildMultiary(2)
+		So(mm.Num(), ShouldEqual, 0)
+		So(mm.Rank(0, 0), ShouldEqual, 0)
+		So(mm.Select(0, 0), ShouldEqual, 0)
+	})
+	Convey("When bitsPerLayer is unsupported", t, func() {
+		So(func() { NewBuilder().BuildMultiary(3) }, ShouldPanic)
+	})
+	Convey("When a random vector is generated", t, func() {
+		num := uint64(5000)
+		orig := make([]uint64, num)
+		wmb := NewBuilder()
+		for i := range orig {
+			orig[i] = uint64(rand.Int63n(1000))
+			wmb.PushBack(orig[i])
+		}
+		plain := wmb.Build()
+		for _, bitsPerLayer := range []uint64{2, 4} {
+			mm := wmb.BuildMultiary(bitsPerLayer)
+			So(len(mm.layers), ShouldEqual, (10+bitsPerLayer-1)/bitsPerLayer)
+
+			check := func(mm *MultiMatrix) {
+				for i := 0; i < 30; i++ {
+					ranze := generateRange(num)
+					x := orig[rand.Intn(int(num))]
+					So(mm.Lookup(ranze.Beg), ShouldEqual, orig[ranze.Beg])
+					c, rank := mm.LookupAndRank(ranze.Beg)
+					So(c, ShouldEqual, orig[ranze.Beg])
+					So(mm.Select(rank, c), ShouldEqual, ranze.Beg)
+					for _, val := range []uint64{x, x + 1, 1 << 20} {
+						So(mm.Rank(ranze.End, val), ShouldEqual, plain.Rank(ranze.End, val))
+						So(mm.RankLessThan(ranze.End, val), ShouldEqual, plain.RankLessThan(ranze.End, val))
+						So(mm.RankMoreThan(ranze.End, val), ShouldEqual, plain.RankMoreThan(ranze.End, val))
+						So(mm.Select(1, val), ShouldEqual, plain.Select(1, val))
+						first := ranze.End
+						for pos := ranze.Beg; pos < ranze.End; pos++ {
+							if orig[pos] == val {
+								first = pos
+								break
+							}
+						}
+						So(mm.RangedSelect(ranze, 0, val), ShouldEqual, first)
+					}
+					So(mm.RangedRankRange(ranze, Range{100, 700}), ShouldEqual, plain.RangedRankRange(ranze, Range{100, 700}))
+					if ranze.End > ranze.Beg {
+						vs := append([]uint64(nil), orig[ranze.Beg:ranze.End]...)
+						sort.Sort(uint64Slice(vs))
+						k := uint64(rand.Intn(len(vs)))
+						So(mm.Quantile(ranze, k), ShouldEqual, vs[k])
+					}
+					ranges := []Range{generateRange(num), generateRange(num)}
+					So(mm.Intersect(ranges, 2), ShouldResemble, origIntersect(orig, ranges, 2))
+				}
+			}
+			check(mm)
+
+			out, err := mm.MarshalBinary()
+			So(err, ShouldBeNil)
+			loaded := new(MultiMatrix)
+			So(loaded.UnmarshalBinary(out), ShouldBeNil)
+			check(loaded)
+		}
+	})
+	Convey("When a binary form claims more values than its layers hold", t, func() {
+		wmb := NewBuilder()
+		for _, val := range []uint64{3, 1, 4, 1, 5, 9, 2, 6} {
+			wmb.PushBack(val)
+		}
+		forged := *wmb.BuildMultiary(2)
+		forged.num = 1 << 20
+		out, err := forged.MarshalBinary()
+		So(err, ShouldBeNil)
+		loaded := new(MultiMatrix)
+		So(errors.Is(loaded.UnmarshalBinary(out), ErrCorrupt), ShouldBeTrue)
+	})
+}
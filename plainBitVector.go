@@ -0,0 +1,193 @@
+package watrix
+
+import (
+	"math/bits"
+)
+
+const (
+	plainWordsPerSuper = 8    // 512 bits per superblock
+	plainSelectSample  = 4096 // a select hint is kept for every plainSelectSample-th bit
+)
+
+// plainBitVector is an uncompressed BitVector in the rank9 layout.
+//
+// For every superblock of 512 bits it keeps the absolute number of ones and
+// seven 9-bit counts of ones before each word within the superblock, packed
+// into one word.  Rank is two lookups and one popcount.  Select starts from
+// a sampled hint, searches the superblocks and then the packed counts.
+// The index takes about 25% of the raw bits.
+type plainBitVector struct {
+	words       []uint64
+	supers      []uint64 // supers[s]: the number of ones before superblock s; has a sentinel
+	blocks      []uint64 // blocks[s]: the number of ones before word j of superblock s at bits 9(j-1)
+	selectOnes  []uint32 // selectOnes[i]: the superblock of the (i*plainSelectSample)-th one
+	selectZeros []uint32 // selectZeros[i]: the superblock of the (i*plainSelectSample)-th zero
+	num         uint64
+	oneNum      uint64
+}
+
+func newPlainBitVector() *plainBitVector {
+	return &plainBitVector{
+		words: make([]uint64, 0),
+	}
+}
+
+// PushBack appends the bit to the end of B
+func (bv *plainBitVector) PushBack(bit bool) {
+	if bv.num%64 == 0 {
+		bv.words = append(bv.words, 0)
+	}
+	if bit {
+		bv.words[bv.num/64] |= 1 << (bv.num % 64)
+		bv.oneNum++
+	}
+	bv.num++
+}
+
+// Build builds the index and returns bv itself.
+func (bv *plainBitVector) Build() BitVector {
+	bv.buildIndex()
+	return bv
+}
+
+func (bv *plainBitVector) buildIndex() {
+	superNum := (uint64(len(bv.words)) + plainWordsPerSuper - 1) / plainWordsPerSuper
+	bv.supers = make([]uint64, superNum+1)
+	bv.blocks = make([]uint64, superNum+1)
+	ones := uint64(0)
+	for s := uint64(0); s < superNum; s++ {
+		bv.supers[s] = ones
+		inSuper := uint64(0)
+		for j := uint64(0); j < plainWordsPerSuper; j++ {
+			if j > 0 {
+				bv.blocks[s] |= inSuper << (9 * (j - 1))
+			}
+			if i := s*plainWordsPerSuper + j; i < uint64(len(bv.words)) {
+				inSuper += uint64(bits.OnesCount64(bv.words[i]))
+			}
+		}
+		ones += inSuper
+	}
+	bv.supers[superNum] = ones
+	bv.oneNum = ones
+
+	bv.selectOnes = make([]uint32, 0, ones/plainSelectSample+1)
+	bv.selectZeros = make([]uint32, 0, (bv.num-ones)/plainSelectSample+1)
+	for s := uint64(0); s < superNum; s++ {
+		for uint64(len(bv.selectOnes))*plainSelectSample < bv.superCount(s+1, true) {
+			bv.selectOnes = append(bv.selectOnes, uint32(s))
+		}
+		for uint64(len(bv.selectZeros))*plainSelectSample < bv.superCount(s+1, false) {
+			bv.selectZeros = append(bv.selectZeros, uint32(s))
+		}
+	}
+}
+
+// superCount returns the number of bit before superblock s.
+func (bv *plainBitVector) superCount(s uint64, bit bool) uint64 {
+	if bit {
+		return bv.supers[s]
+	}
+	if s == uint64(len(bv.supers))-1 {
+		return bv.num - bv.supers[s]
+	}
+	return s*plainWordsPerSuper*64 - bv.supers[s]
+}
+
+// blockCount returns the number of bit before word j within superblock s.
+func (bv *plainBitVector) blockCount(s uint64, j uint64, bit bool) uint64 {
+	ones := uint64(0)
+	if j > 0 {
+		ones = (bv.blocks[s] >> (9 * (j - 1))) & 0x1ff
+	}
+	if bit {
+		return ones
+	}
+	return j*64 - ones
+}
+
+func (bv *plainBitVector) Num() uint64 {
+	return bv.num
+}
+
+func (bv *plainBitVector) OneNum() uint64 {
+	return bv.oneNum
+}
+
+func (bv *plainBitVector) ZeroNum() uint64 {
+	return bv.num - bv.oneNum
+}
+
+func (bv *plainBitVector) Bit(pos uint64) bool {
+	return (bv.words[pos/64]>>(pos%64))&1 == 1
+}
+
+func (bv *plainBitVector) Rank(pos uint64, bit bool) uint64 {
+	w := pos / 64
+	s := w / plainWordsPerSuper
+	ones := bv.supers[s] + bv.blockCount(s, w%plainWordsPerSuper, true)
+	if r := pos % 64; r > 0 {
+		ones += uint64(bits.OnesCount64(bv.words[w] & ((1 << r) - 1)))
+	}
+	if bit {
+		return ones
+	}
+	return pos - ones
+}
+
+func (bv *plainBitVector) Select(rank uint64, bit bool) uint64 {
+	hints := bv.selectZeros
+	if bit {
+		hints = bv.selectOnes
+		if rank >= bv.OneNum() {
+			return bv.num
+		}
+	} else if rank >= bv.ZeroNum() {
+		return bv.num
+	}
+	// the last superblock s in [lo, hi) with superCount(s) <= rank
+	i := rank / plainSelectSample
+	lo, hi := uint64(hints[i]), uint64(len(bv.supers))-1
+	if i+1 < uint64(len(hints)) {
+		hi = uint64(hints[i+1]) + 1
+	}
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if bv.superCount(mid, bit) <= rank {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	s := lo
+	rank -= bv.superCount(s, bit)
+	j := uint64(0)
+	for j+1 < plainWordsPerSuper && s*plainWordsPerSuper+j+1 < uint64(len(bv.words)) && bv.blockCount(s, j+1, bit) <= rank {
+		j++
+	}
+	rank -= bv.blockCount(s, j, bit)
+	w := s*plainWordsPerSuper + j
+	word := bv.words[w]
+	if !bit {
+		word = ^word
+	}
+	return w*64 + selectInWord(word, rank)
+}
+
+// MarshalBinary encodes the bit vector in the same form as denseBitVector.
+// The index is rebuilt by UnmarshalBinary.
+func (bv *plainBitVector) MarshalBinary() ([]byte, error) {
+	return marshalWords(bv.num, bv.words), nil
+}
+
+// UnmarshalBinary decodes the bit vector from a binary form generated MarshalBinary.
+func (bv *plainBitVector) UnmarshalBinary(in []byte) error {
+	num, words, err := unmarshalWords(in)
+	if err != nil {
+		return err
+	}
+	bv.num = num
+	bv.words = words
+	bv.buildIndex()
+	return nil
+}
@@ -0,0 +1,380 @@
+package watrix
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// DefaultSegmentSize is the number of values buffered by SegmentedMatrix
+// before they are sealed into an immutable segment.
+const DefaultSegmentSize = 1 << 16
+
+// SegmentedMatrix is an append-friendly sequence of values that can be
+// queried at any time.
+//
+// Values are pushed into a small unsealed tail.  When the tail is full it is
+// sealed into an immutable WaveletMatrix segment.  Segments are merged in the
+// background so that every segment is more than twice as large as the
+// following (newer) one, which keeps the number of segments O(log n).
+//
+// All positions taken and returned by the query methods are global positions
+// in the whole sequence T[0...Num()).  SegmentedMatrix is safe for concurrent
+// use.
+type SegmentedMatrix struct {
+	mu          sync.RWMutex
+	segments    []*WaveletMatrix
+	tail        []uint64
+	segmentSize uint64
+	merging     bool
+	mergeDone   *sync.Cond
+	opts        []BuildOption // used to build the segments
+	conf        buildConfig
+}
+
+// NewSegmentedMatrix returns an empty SegmentedMatrix that seals a segment
+// every segmentSize values.  If segmentSize is 0, DefaultSegmentSize is used.
+// The segments are built, and rebuilt when merged, with opts.
+func NewSegmentedMatrix(segmentSize uint64, opts ...BuildOption) *SegmentedMatrix {
+	if segmentSize == 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	sm := &SegmentedMatrix{
+		segments:    make([]*WaveletMatrix, 0),
+		tail:        make([]uint64, 0, segmentSize),
+		segmentSize: segmentSize,
+		opts:        opts,
+		conf:        newBuildConfig(opts),
+	}
+	sm.mergeDone = sync.NewCond(&sm.mu)
+	return sm
+}
+
+// BuildSegmented constructs a SegmentedMatrix whose first segment holds
+// the values pushed so far.  More values can be appended to the result with
+// PushBack.  All segments are built with opts.
+func (wmb *WaveletMatrixBuilder) BuildSegmented(segmentSize uint64, opts ...BuildOption) *SegmentedMatrix {
+	sm := NewSegmentedMatrix(segmentSize, opts...)
+	if len(wmb.vals) > 0 {
+		sm.Append(wmb.Build(opts...))
+	}
+	return sm
+}
+
+// PushBack appends a value to the end of T.
+func (sm *SegmentedMatrix) PushBack(val uint64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.tail = append(sm.tail, val)
+	if uint64(len(sm.tail)) >= sm.segmentSize {
+		sm.sealLocked()
+	}
+}
+
+// Append appends all values of wm to the end of T as a new segment.
+// The unsealed tail is sealed first so that the order of values is kept.
+// wm must not be modified afterwards.
+func (sm *SegmentedMatrix) Append(wm *WaveletMatrix) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.sealLocked()
+	if wm.Num() > 0 {
+		sm.segments = append(sm.segments, wm)
+		sm.scheduleMergeLocked()
+	}
+}
+
+// Seal forces the unsealed tail into an immutable segment.
+func (sm *SegmentedMatrix) Seal() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.sealLocked()
+}
+
+// Wait blocks until no background merge is running.
+func (sm *SegmentedMatrix) Wait() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for sm.merging {
+		sm.mergeDone.Wait()
+	}
+}
+
+// NumSegments returns the number of sealed segments.
+func (sm *SegmentedMatrix) NumSegments() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return len(sm.segments)
+}
+
+func (sm *SegmentedMatrix) sealLocked() {
+	if len(sm.tail) == 0 {
+		return
+	}
+	wmb := NewBuilder()
+	for _, val := range sm.tail {
+		wmb.PushBack(val)
+	}
+	sm.segments = append(sm.segments, wmb.Build(sm.opts...))
+	sm.tail = make([]uint64, 0, sm.segmentSize)
+	sm.scheduleMergeLocked()
+}
+
+// mergeCandidateLocked returns the index i of the newest pair of segments
+// (i, i+1) that violates the size invariant, or -1.
+func (sm *SegmentedMatrix) mergeCandidateLocked() int {
+	for i := len(sm.segments) - 2; i >= 0; i-- {
+		if sm.segments[i].Num() <= 2*sm.segments[i+1].Num() {
+			return i
+		}
+	}
+	return -1
+}
+
+func (sm *SegmentedMatrix) scheduleMergeLocked() {
+	if sm.merging {
+		return
+	}
+	i := sm.mergeCandidateLocked()
+	if i < 0 {
+		return
+	}
+	sm.merging = true
+	go sm.merge(sm.segments[i], sm.segments[i+1])
+}
+
+// merge runs without holding the lock.  Segments are immutable and new
+// segments are only appended, so the pair is located again by identity
+// before it is replaced.
+func (sm *SegmentedMatrix) merge(a, b *WaveletMatrix) {
+	merged := sm.concat(a, b)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for i := 0; i+1 < len(sm.segments); i++ {
+		if sm.segments[i] == a && sm.segments[i+1] == b {
+			sm.segments[i] = merged
+			sm.segments = append(sm.segments[:i+1], sm.segments[i+2:]...)
+			break
+		}
+	}
+	sm.merging = false
+	sm.scheduleMergeLocked()
+	if !sm.merging {
+		sm.mergeDone.Broadcast()
+	}
+}
+
+// concat returns the concatenation of a and b built with the options of sm.
+// Segments given to Append may have been built with other options, and are
+// then decoded and rebuilt.
+func (sm *SegmentedMatrix) concat(a, b *WaveletMatrix) *WaveletMatrix {
+	if sm.conf.matches(a) && sm.conf.matches(b) {
+		return Concat(a, b)
+	}
+	wmb := NewBuilder()
+	var vals []uint64
+	for _, seg := range []*WaveletMatrix{a, b} {
+		vals = seg.Extract(Range{0, seg.Num()}, vals)
+		for _, val := range vals {
+			wmb.PushBack(val)
+		}
+	}
+	return wmb.Build(sm.opts...)
+}
+
+// Num returns the number of values in T, including the unsealed tail.
+func (sm *SegmentedMatrix) Num() uint64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.numLocked()
+}
+
+func (sm *SegmentedMatrix) numLocked() uint64 {
+	num := uint64(len(sm.tail))
+	for _, seg := range sm.segments {
+		num += seg.Num()
+	}
+	return num
+}
+
+// forEachPart calls fn for every segment overlapping posRange with the part
+// of posRange local to that segment and the global offset of the segment.
+// The unsealed tail is reported with a nil segment.
+func (sm *SegmentedMatrix) forEachPart(posRange Range, fn func(seg *WaveletMatrix, local Range, offset uint64) bool) {
+	offset := uint64(0)
+	for _, seg := range sm.segments {
+		if !sm.visitPart(seg, seg.Num(), posRange, offset, fn) {
+			return
+		}
+		offset += seg.Num()
+	}
+	sm.visitPart(nil, uint64(len(sm.tail)), posRange, offset, fn)
+}
+
+func (sm *SegmentedMatrix) visitPart(seg *WaveletMatrix, num uint64, posRange Range, offset uint64, fn func(seg *WaveletMatrix, local Range, offset uint64) bool) bool {
+	beg, end := offset, offset+num
+	if posRange.Beg > beg {
+		beg = posRange.Beg
+	}
+	if posRange.End < end {
+		end = posRange.End
+	}
+	if beg >= end {
+		return true
+	}
+	return fn(seg, Range{beg - offset, end - offset}, offset)
+}
+
+// Lookup returns T[pos]
+func (sm *SegmentedMatrix) Lookup(pos uint64) (val uint64) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	sm.forEachPart(Range{pos, pos + 1}, func(seg *WaveletMatrix, local Range, offset uint64) bool {
+		if seg == nil {
+			val = sm.tail[local.Beg]
+		} else {
+			val = seg.Lookup(local.Beg)
+		}
+		return false
+	})
+	return val
+}
+
+// Rank returns the number of c (== val) in T[0...pos)
+func (sm *SegmentedMatrix) Rank(pos uint64, val uint64) uint64 {
+	if val == math.MaxUint64 {
+		// val+1 overflows; no value is larger than val.
+		sm.mu.RLock()
+		defer sm.mu.RUnlock()
+		if num := sm.numLocked(); pos > num {
+			pos = num
+		}
+		return pos - sm.rangedRankLessThanLocked(Range{0, pos}, val)
+	}
+	return sm.RangedRankRange(Range{0, pos}, Range{val, val + 1})
+}
+
+// RangedRankRange searches T[posRange.Beg, posRange.End) and
+// returns the number of c that falls within valueRange
+// i.e. [valueRange.Beg, valueRange.End).
+func (sm *SegmentedMatrix) RangedRankRange(posRange Range, valueRange Range) uint64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.rangedRankLessThanLocked(posRange, valueRange.End) - sm.rangedRankLessThanLocked(posRange, valueRange.Beg)
+}
+
+func (sm *SegmentedMatrix) rangedRankLessThanLocked(posRange Range, val uint64) uint64 {
+	rank := uint64(0)
+	sm.forEachPart(posRange, func(seg *WaveletMatrix, local Range, offset uint64) bool {
+		if seg == nil {
+			for _, c := range sm.tail[local.Beg:local.End] {
+				if c < val {
+					rank++
+				}
+			}
+		} else if val >= seg.Dim() {
+			rank += local.End - local.Beg
+		} else {
+			rank += seg.RangedRankOp(local, val, OpLessThan)
+		}
+		return true
+	})
+	return rank
+}
+
+// Select returns the position of (rank+1)-th val in T.
+// If no match has been found, it returns Num().
+func (sm *SegmentedMatrix) Select(rank uint64, val uint64) uint64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	num := sm.numLocked()
+	position := num
+	sm.forEachPart(Range{0, num}, func(seg *WaveletMatrix, local Range, offset uint64) bool {
+		if seg == nil {
+			for i, c := range sm.tail {
+				if c != val {
+					continue
+				}
+				if rank == 0 {
+					position = offset + uint64(i)
+					return false
+				}
+				rank--
+			}
+			return true
+		}
+		if val >= seg.Dim() {
+			return true
+		}
+		count := seg.Rank(seg.Num(), val)
+		if rank < count {
+			position = offset + seg.Select(rank, val)
+			return false
+		}
+		rank -= count
+		return true
+	})
+	return position
+}
+
+// Quantile returns (k+1)th smallest value in T[posRange.Beg, posRange.End).
+func (sm *SegmentedMatrix) Quantile(posRange Range, k uint64) uint64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	blen := uint64(0)
+	sm.forEachPart(posRange, func(seg *WaveletMatrix, local Range, offset uint64) bool {
+		if seg == nil {
+			for _, c := range sm.tail[local.Beg:local.End] {
+				if l := getBinaryLen(c); l > blen {
+					blen = l
+				}
+			}
+		} else if l := getBinaryLen(seg.Dim() - 1); l > blen {
+			blen = l
+		}
+		return true
+	})
+	// The answer is the largest val such that fewer than k+1 values are less than val.
+	val := uint64(0)
+	for depth := blen; depth > 0; depth-- {
+		cand := val | (1 << (depth - 1))
+		if sm.rangedRankLessThanLocked(posRange, cand) <= k {
+			val = cand
+		}
+	}
+	return val
+}
+
+// Intersect returns values that occur at least k ranges.
+func (sm *SegmentedMatrix) Intersect(ranges []Range, k int) []uint64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	cand := make(map[uint64]int)
+	for _, posRange := range ranges {
+		set := make(map[uint64]struct{})
+		sm.forEachPart(posRange, func(seg *WaveletMatrix, local Range, offset uint64) bool {
+			if seg == nil {
+				for _, c := range sm.tail[local.Beg:local.End] {
+					set[c] = struct{}{}
+				}
+			} else {
+				for _, c := range seg.Intersect([]Range{local}, 1) {
+					set[c] = struct{}{}
+				}
+			}
+			return true
+		})
+		for c := range set {
+			cand[c]++
+		}
+	}
+	ret := make([]uint64, 0)
+	for c, n := range cand {
+		if n >= k {
+			ret = append(ret, c)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
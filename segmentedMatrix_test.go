@@ -0,0 +1,129 @@
+package watrix
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSegmentedMatrix(t *testing.T) {
+	Convey("When a segmented matrix is empty", t, func() {
+		sm := NewSegmentedMatrix(16)
+		So(sm.Num(), ShouldEqual, 0)
+		So(sm.Rank(0, 0), ShouldEqual, 0)
+		So(sm.Select(0, 0), ShouldEqual, 0)
+		So(sm.NumSegments(), ShouldEqual, 0)
+	})
+	Convey("When values are pushed continuously", t, func() {
+		num := uint64(5000)
+		dim := uint64(50)
+		orig := make([]uint64, 0, num)
+		sm := NewSegmentedMatrix(64)
+		for i := uint64(0); i < num; i++ {
+			x := uint64(rand.Int31n(int32(dim)))
+			if i%7 == 0 {
+				x += 1000 // later segments may have a wider blen
+			}
+			orig = append(orig, x)
+			sm.PushBack(x)
+		}
+		sm.Wait()
+
+		Convey("The segment count should be logarithmic", func() {
+			So(sm.NumSegments(), ShouldBeLessThanOrEqualTo, 8)
+			So(sm.Num(), ShouldEqual, num)
+		})
+		Convey("Queries should use global positions", func() {
+			for i := 0; i < 20; i++ {
+				ranze := generateRange(num)
+				x := orig[rand.Intn(int(num))]
+
+				So(sm.Lookup(ranze.Beg), ShouldEqual, orig[ranze.Beg])
+
+				rank := uint64(0)
+				inRange := uint64(0)
+				for j := uint64(0); j < ranze.End; j++ {
+					if orig[j] == x {
+						rank++
+					}
+					if j >= ranze.Beg && orig[j] >= 10 && orig[j] < 30 {
+						inRange++
+					}
+				}
+				So(sm.Rank(ranze.End, x), ShouldEqual, rank)
+				So(sm.RangedRankRange(ranze, Range{10, 30}), ShouldEqual, inRange)
+				if rank > 0 {
+					So(orig[sm.Select(rank-1, x)], ShouldEqual, x)
+				}
+				So(sm.Select(num, x), ShouldEqual, num)
+
+				if ranze.End > ranze.Beg {
+					k := uint64(rand.Int63()) % (ranze.End - ranze.Beg)
+					vs := append([]uint64(nil), orig[ranze.Beg:ranze.End]...)
+					sort.Sort(uint64Slice(vs))
+					So(sm.Quantile(ranze, k), ShouldEqual, vs[k])
+				}
+
+				ranges := []Range{generateRange(num), generateRange(num), generateRange(num)}
+				So(sm.Intersect(ranges, 3), ShouldResemble, origIntersect(orig, ranges, 3))
+			}
+		})
+	})
+	Convey("When a built matrix is extended", t, func() {
+		wmb := NewBuilder()
+		for _, v := range []uint64{3, 1, 4, 1, 5} {
+			wmb.PushBack(v)
+		}
+		sm := wmb.BuildSegmented(4)
+		for _, v := range []uint64{9, 2, 6, 5, 3, 5} {
+			sm.PushBack(v)
+		}
+		sm.Wait()
+		So(sm.Num(), ShouldEqual, 11)
+		So(sm.Rank(11, 5), ShouldEqual, 3)
+		So(sm.Select(1, 5), ShouldEqual, 8)
+		So(sm.Lookup(5), ShouldEqual, 9)
+		So(sm.Quantile(Range{0, 11}, 10), ShouldEqual, 9)
+	})
+	Convey("When the largest value is stored", t, func() {
+		sm := NewSegmentedMatrix(4)
+		for _, v := range []uint64{1, 2, 3, 4, math.MaxUint64, 1} {
+			sm.PushBack(v)
+		}
+		sm.Wait()
+		So(sm.Rank(6, math.MaxUint64), ShouldEqual, 1)
+		So(sm.Rank(4, math.MaxUint64), ShouldEqual, 0)
+		So(sm.Rank(10, math.MaxUint64), ShouldEqual, 1)
+		So(sm.Rank(6, math.MaxUint64-1), ShouldEqual, 0)
+		So(sm.Select(0, math.MaxUint64), ShouldEqual, 4)
+	})
+	Convey("When segments are built with options", t, func() {
+		wmb := NewBuilder()
+		for _, v := range []uint64{1000, 5, 1000} {
+			wmb.PushBack(v)
+		}
+		sm := wmb.BuildSegmented(2, WithBackend(BackendPlain), WithCompactAlphabet())
+		other := NewBuilder()
+		other.PushBack(7)
+		sm.Append(other.Build())
+		for _, v := range []uint64{5, 1 << 40, 5, 7} {
+			sm.PushBack(v)
+		}
+		sm.Seal()
+		sm.Wait()
+		So(sm.Num(), ShouldEqual, 8)
+		So(sm.NumSegments(), ShouldBeLessThan, 4)
+		sm.mu.RLock()
+		for _, seg := range sm.segments {
+			So(seg.Backend(), ShouldEqual, BackendPlain)
+			So(seg.Compacted(), ShouldBeTrue)
+		}
+		sm.mu.RUnlock()
+		So(sm.Rank(8, 5), ShouldEqual, 3)
+		So(sm.Select(1, 7), ShouldEqual, 7)
+		So(sm.Lookup(5), ShouldEqual, 1<<40)
+	})
+}
@@ -0,0 +1,213 @@
+package watrix
+
+import (
+	"sort"
+	"strings"
+)
+
+// StringBuilder builds StringMatrix from a string array.
+// A user calls PushBack()s followed by Build().
+type StringBuilder struct {
+	vals []string
+}
+
+// NewStringBuilder returns StringBuilder
+func NewStringBuilder() *StringBuilder {
+	return &StringBuilder{
+		vals: make([]string, 0),
+	}
+}
+
+// PushBack append a value to the builder.
+func (sb *StringBuilder) PushBack(val string) {
+	sb.vals = append(sb.vals, val)
+}
+
+// Build constructs StringMatrix data structure.
+func (sb *StringBuilder) Build() *StringMatrix {
+	dict := append([]string(nil), sb.vals...)
+	sort.Strings(dict)
+	n := 0
+	for i, s := range dict {
+		if i == 0 || s != dict[n-1] {
+			dict[n] = s
+			n++
+		}
+	}
+	dict = dict[:n:n]
+	codes := make(map[string]uint64, n)
+	for i, s := range dict {
+		codes[s] = uint64(i)
+	}
+	wmb := NewBuilder()
+	for _, s := range sb.vals {
+		wmb.PushBack(codes[s])
+	}
+	return &StringMatrix{dict, wmb.Build()}
+}
+
+// StringMatrix is a WaveletMatrix storing strings.
+//
+// The distinct strings are kept in a sorted dictionary and the matrix stores
+// their indices, so the order of the codes matches the lexicographic order
+// of the strings (byte-wise, as with the < operator).
+type StringMatrix struct {
+	dict []string
+	wm   *WaveletMatrix
+}
+
+// Num return the number of values in T
+func (sm *StringMatrix) Num() uint64 {
+	return sm.wm.Num()
+}
+
+// Dictionary returns the sorted distinct strings.  It must not be modified.
+func (sm *StringMatrix) Dictionary() []string {
+	return sm.dict
+}
+
+// WaveletMatrix returns the underlying matrix storing the codes.
+func (sm *StringMatrix) WaveletMatrix() *WaveletMatrix {
+	return sm.wm
+}
+
+// Code returns the code of val and whether val is in the dictionary.
+func (sm *StringMatrix) Code(val string) (uint64, bool) {
+	code := sm.lowerBound(val)
+	return code, code < uint64(len(sm.dict)) && sm.dict[code] == val
+}
+
+// lowerBound returns the number of strings in the dictionary that are less than val.
+func (sm *StringMatrix) lowerBound(val string) uint64 {
+	return uint64(sort.SearchStrings(sm.dict, val))
+}
+
+// prefixCodeRange returns the range of codes of the strings that start with prefix.
+func (sm *StringMatrix) prefixCodeRange(prefix string) Range {
+	beg := sort.SearchStrings(sm.dict, prefix)
+	end := beg + sort.Search(len(sm.dict)-beg, func(i int) bool {
+		return !strings.HasPrefix(sm.dict[beg+i], prefix)
+	})
+	return Range{uint64(beg), uint64(end)}
+}
+
+// Lookup returns T[pos]
+func (sm *StringMatrix) Lookup(pos uint64) string {
+	return sm.dict[sm.wm.Lookup(pos)]
+}
+
+// Rank returns the number of c (== val) in T[0...pos)
+func (sm *StringMatrix) Rank(pos uint64, val string) uint64 {
+	code, ok := sm.Code(val)
+	if !ok {
+		return 0
+	}
+	return sm.wm.Rank(pos, code)
+}
+
+// RankLessThan returns the number of c (< val) in T[0...pos)
+func (sm *StringMatrix) RankLessThan(pos uint64, val string) uint64 {
+	return sm.wm.RankLessThan(pos, sm.lowerBound(val))
+}
+
+// RangedRankRange searches T[posRange.Beg, posRange.End) and
+// returns the number of c that falls within [beg, end) in lexicographic order.
+func (sm *StringMatrix) RangedRankRange(posRange Range, beg, end string) uint64 {
+	if end <= beg {
+		return 0
+	}
+	return sm.wm.RangedRankRange(posRange, Range{sm.lowerBound(beg), sm.lowerBound(end)})
+}
+
+// RangedRankPrefix searches T[posRange.Beg, posRange.End) and
+// returns the number of c that starts with prefix.
+func (sm *StringMatrix) RangedRankPrefix(posRange Range, prefix string) uint64 {
+	return sm.wm.RangedRankRange(posRange, sm.prefixCodeRange(prefix))
+}
+
+// Select returns the position of (rank+1)-th val in T.
+// If no match has been found, it returns Num().
+func (sm *StringMatrix) Select(rank uint64, val string) uint64 {
+	code, ok := sm.Code(val)
+	if !ok {
+		return sm.wm.Num()
+	}
+	return sm.wm.Select(rank, code)
+}
+
+// RangedSelect takes T[posRange) and returns the position of rank+1'th val.
+// If no match has been found, it returns posRange.End.
+func (sm *StringMatrix) RangedSelect(posRange Range, rank uint64, val string) uint64 {
+	code, ok := sm.Code(val)
+	if !ok {
+		return posRange.End
+	}
+	return sm.wm.RangedSelect(posRange, rank, code)
+}
+
+// Quantile returns (k+1)th smallest string in T[posRange.Beg, posRange.End).
+func (sm *StringMatrix) Quantile(posRange Range, k uint64) string {
+	return sm.dict[sm.wm.Quantile(posRange, k)]
+}
+
+// Intersect returns strings that occur at least k ranges, in lexicographic order.
+func (sm *StringMatrix) Intersect(ranges []Range, k int) []string {
+	codes := sm.wm.Intersect(ranges, k)
+	ret := make([]string, len(codes))
+	for i, code := range codes {
+		ret[i] = sm.dict[code]
+	}
+	return ret
+}
+
+// MarshalBinary encodes StringMatrix, i.e. the dictionary followed by
+// the matrix, into the framed form and returns the result.
+func (sm *StringMatrix) MarshalBinary() ([]byte, error) {
+	fw := newFramedWriter(stringMagic)
+	var dict []byte
+	for _, s := range sm.dict {
+		dict = appendString(dict, s)
+	}
+	fw.section(dict)
+	if err := fw.matrix(sm.wm); err != nil {
+		return nil, err
+	}
+	return fw.bytes(), nil
+}
+
+// UnmarshalBinary decodes StringMatrix from a binary form generated MarshalBinary.
+func (sm *StringMatrix) UnmarshalBinary(in []byte) (err error) {
+	defer recoverCorrupt(&err)
+	fr, err := newFramedReader(in, stringMagic)
+	if err != nil {
+		return err
+	}
+	payload, err := fr.section()
+	if err != nil {
+		return err
+	}
+	pr := payloadReader{buf: payload, what: "the string dictionary"}
+	var dict []string
+	for len(pr.buf) > 0 && pr.err == nil {
+		s := pr.string()
+		if pr.err == nil && len(dict) > 0 && dict[len(dict)-1] >= s {
+			return corruptf("the string dictionary is not sorted at %d", len(dict))
+		}
+		dict = append(dict, s)
+	}
+	if pr.err != nil {
+		return pr.err
+	}
+	wm, err := fr.matrix()
+	if err != nil {
+		return err
+	}
+	if err := fr.finish(); err != nil {
+		return err
+	}
+	if wm.Dim() > uint64(len(dict)) {
+		return corruptf("codes up to %d for %d strings", wm.Dim(), len(dict))
+	}
+	sm.dict, sm.wm = dict, wm
+	return nil
+}
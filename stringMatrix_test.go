@@ -0,0 +1,77 @@
+package watrix
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStringMatrix(t *testing.T) {
+	Convey("When strings are built", t, func() {
+		words := []string{"", "a", "ab", "abc", "b", "ba", "http://a/", "http://a/x", "http://b/", "zzz"}
+		num := uint64(1500)
+		orig := make([]string, num)
+		sb := NewStringBuilder()
+		for i := range orig {
+			orig[i] = words[rand.Intn(len(words))]
+			sb.PushBack(orig[i])
+		}
+		sm := sb.Build()
+		So(sort.StringsAreSorted(sm.Dictionary()), ShouldBeTrue)
+
+		check := func(sm *StringMatrix) {
+			for i := 0; i < 20; i++ {
+				ranze := generateRange(num)
+				x := words[rand.Intn(len(words))]
+				So(sm.Lookup(ranze.Beg), ShouldEqual, orig[ranze.Beg])
+
+				rank, less, prefixed, within := uint64(0), uint64(0), uint64(0), uint64(0)
+				for j, s := range orig[:ranze.End] {
+					if s == x {
+						rank++
+					}
+					if s < x {
+						less++
+					}
+					if uint64(j) >= ranze.Beg && strings.HasPrefix(s, "http://a") {
+						prefixed++
+					}
+					if uint64(j) >= ranze.Beg && s >= "ab" && s < "b" {
+						within++
+					}
+				}
+				So(sm.Rank(ranze.End, x), ShouldEqual, rank)
+				So(sm.RankLessThan(ranze.End, x), ShouldEqual, less)
+				So(sm.RangedRankPrefix(ranze, "http://a"), ShouldEqual, prefixed)
+				So(sm.RangedRankRange(ranze, "ab", "b"), ShouldEqual, within)
+				if rank > 0 {
+					So(sm.Select(rank-1, x), ShouldBeLessThan, ranze.End)
+					So(orig[sm.Select(rank-1, x)], ShouldEqual, x)
+				}
+				if ranze.End > ranze.Beg {
+					vs := append([]string(nil), orig[ranze.Beg:ranze.End]...)
+					sort.Strings(vs)
+					k := uint64(rand.Intn(len(vs)))
+					So(sm.Quantile(ranze, k), ShouldEqual, vs[k])
+				}
+			}
+			So(sm.Rank(num, "missing"), ShouldEqual, 0)
+			So(sm.Select(0, "missing"), ShouldEqual, num)
+			So(sm.RangedRankPrefix(Range{0, num}, ""), ShouldEqual, num)
+			So(sm.RangedRankPrefix(Range{0, num}, "q"), ShouldEqual, 0)
+		}
+		check(sm)
+
+		Convey("The dictionary and the matrix should be marshaled together", func() {
+			out, err := sm.MarshalBinary()
+			So(err, ShouldBeNil)
+			loaded := new(StringMatrix)
+			So(loaded.UnmarshalBinary(out), ShouldBeNil)
+			So(loaded.Dictionary(), ShouldResemble, sm.Dictionary())
+			check(loaded)
+		})
+	})
+}
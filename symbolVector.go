@@ -0,0 +1,170 @@
+package watrix
+
+import (
+	"math/bits"
+)
+
+const (
+	symbolBlockWords     = 16  // words per block
+	symbolBlocksPerSuper = 128 // blocks per superblock, so that block counts fit in uint16
+)
+
+// symbolVector is a rank/select dictionary over a sequence of small symbols
+// of w bits (w = 2 or 4), packed into uint64 words.
+//
+// For each symbol it keeps absolute counts per superblock and counts relative
+// to the superblock per block of symbolBlockWords words.  Rank counts the
+// matching symbols of the remaining words with a few popcounts.
+type symbolVector struct {
+	words       []uint64
+	num         uint64
+	w           uint64
+	superCounts []uint64 // [s*sigma+c]: the number of c before superblock s
+	blockCounts []uint16 // [b*sigma+c]: the number of c before block b within its superblock
+	counts      []uint64 // [c]: the number of c
+	starts      []uint64 // [c]: the number of symbols less than c
+}
+
+func newSymbolVector(w uint64) *symbolVector {
+	return &symbolVector{
+		words: make([]uint64, 0),
+		w:     w,
+	}
+}
+
+func (sv *symbolVector) sigma() uint64 {
+	return 1 << sv.w
+}
+
+func (sv *symbolVector) perWord() uint64 {
+	return 64 / sv.w
+}
+
+// lowMask has the lowest bit of every symbol set.
+func (sv *symbolVector) lowMask() uint64 {
+	if sv.w == 2 {
+		return 0x5555555555555555
+	}
+	return 0x1111111111111111
+}
+
+// pushBack appends symbol c.
+func (sv *symbolVector) pushBack(c uint64) {
+	i := sv.num % sv.perWord()
+	if i == 0 {
+		sv.words = append(sv.words, 0)
+	}
+	sv.words[len(sv.words)-1] |= c << (i * sv.w)
+	sv.num++
+}
+
+// buildIndex builds the counters.  It must be called after the last pushBack.
+func (sv *symbolVector) buildIndex() {
+	sigma := sv.sigma()
+	blockNum := (uint64(len(sv.words)) + symbolBlockWords - 1) / symbolBlockWords
+	superNum := (blockNum + symbolBlocksPerSuper - 1) / symbolBlocksPerSuper
+	sv.superCounts = make([]uint64, superNum*sigma)
+	sv.blockCounts = make([]uint16, blockNum*sigma)
+	sv.counts = make([]uint64, sigma)
+	inSuper := make([]uint64, sigma)
+	for b := uint64(0); b < blockNum; b++ {
+		if b%symbolBlocksPerSuper == 0 {
+			copy(sv.superCounts[(b/symbolBlocksPerSuper)*sigma:], sv.counts)
+			for c := range inSuper {
+				inSuper[c] = 0
+			}
+		}
+		for c := uint64(0); c < sigma; c++ {
+			sv.blockCounts[b*sigma+c] = uint16(inSuper[c])
+		}
+		for i := b * symbolBlockWords; i < (b+1)*symbolBlockWords && i < uint64(len(sv.words)); i++ {
+			for j := uint64(0); j < sv.perWord() && i*sv.perWord()+j < sv.num; j++ {
+				c := (sv.words[i] >> (j * sv.w)) & (sigma - 1)
+				inSuper[c]++
+				sv.counts[c]++
+			}
+		}
+	}
+	sv.starts = make([]uint64, sigma)
+	for c := uint64(1); c < sigma; c++ {
+		sv.starts[c] = sv.starts[c-1] + sv.counts[c-1]
+	}
+}
+
+// access returns the symbol at pos.
+func (sv *symbolVector) access(pos uint64) uint64 {
+	return (sv.words[pos/sv.perWord()] >> ((pos % sv.perWord()) * sv.w)) & (sv.sigma() - 1)
+}
+
+// match returns the lowest bit of every symbol of x that equals c.
+func (sv *symbolVector) match(x uint64, c uint64) uint64 {
+	x ^= c * sv.lowMask()
+	t := x | (x >> 1)
+	if sv.w == 4 {
+		t |= (x >> 2) | (x >> 3)
+	}
+	return ^t & sv.lowMask()
+}
+
+// rank returns the number of c in S[0...pos).
+func (sv *symbolVector) rank(c uint64, pos uint64) uint64 {
+	perBlock := sv.perWord() * symbolBlockWords
+	b := pos / perBlock
+	if pos == sv.num && pos%perBlock == 0 {
+		// pos is at the end; there is no block b.
+		return sv.counts[c]
+	}
+	sigma := sv.sigma()
+	r := sv.superCounts[(b/symbolBlocksPerSuper)*sigma+c] + uint64(sv.blockCounts[b*sigma+c])
+	last := pos / sv.perWord()
+	for i := b * symbolBlockWords; i < last; i++ {
+		r += uint64(bits.OnesCount64(sv.match(sv.words[i], c)))
+	}
+	if rem := pos % sv.perWord(); rem > 0 {
+		r += uint64(bits.OnesCount64(sv.match(sv.words[last], c) & ((1 << (rem * sv.w)) - 1)))
+	}
+	return r
+}
+
+// selectSymbol returns the position of the (rank+1)-th c.
+// rank must be less than the number of c.
+func (sv *symbolVector) selectSymbol(c uint64, rank uint64) uint64 {
+	sigma := sv.sigma()
+	superNum := uint64(len(sv.superCounts)) / sigma
+	// the last superblock s with superCounts[s] <= rank
+	lo, hi := uint64(0), superNum
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if sv.superCounts[mid*sigma+c] <= rank {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	rank -= sv.superCounts[lo*sigma+c]
+	blockNum := uint64(len(sv.blockCounts)) / sigma
+	bLo, bHi := lo*symbolBlocksPerSuper, (lo+1)*symbolBlocksPerSuper
+	if bHi > blockNum {
+		bHi = blockNum
+	}
+	for bHi-bLo > 1 {
+		mid := (bLo + bHi) / 2
+		if uint64(sv.blockCounts[mid*sigma+c]) <= rank {
+			bLo = mid
+		} else {
+			bHi = mid
+		}
+	}
+	rank -= uint64(sv.blockCounts[bLo*sigma+c])
+	for i := bLo * symbolBlockWords; ; i++ {
+		m := sv.match(sv.words[i], c)
+		n := uint64(bits.OnesCount64(m))
+		if rank < n {
+			for ; rank > 0; rank-- {
+				m &= m - 1
+			}
+			return i*sv.perWord() + uint64(bits.TrailingZeros64(m))/sv.w
+		}
+		rank -= n
+	}
+}
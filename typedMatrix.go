@@ -0,0 +1,181 @@
+package watrix
+
+import (
+	"unsafe"
+)
+
+// Integer is a constraint that permits any integer type.
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// encodeInteger maps val to uint64 preserving the order of T.
+// Signed values are biased by 2^(bits-1) so that negative numbers come first.
+func encodeInteger[T Integer](val T) uint64 {
+	var zero T
+	if ^zero > zero {
+		return uint64(val)
+	}
+	bits := uint64(unsafe.Sizeof(zero)) * 8
+	mask := ^uint64(0) >> (64 - bits)
+	return (uint64(val) & mask) ^ (1 << (bits - 1))
+}
+
+// decodeInteger is the inverse of encodeInteger.
+func decodeInteger[T Integer](code uint64) T {
+	var zero T
+	if ^zero > zero {
+		return T(code)
+	}
+	bits := uint64(unsafe.Sizeof(zero)) * 8
+	return T(code ^ (1 << (bits - 1)))
+}
+
+// Builder builds Matrix[T] from an array of T.
+// A user calls PushBack()s followed by Build().
+type Builder[T Integer] struct {
+	wmb *WaveletMatrixBuilder
+}
+
+// NewTypedBuilder returns Builder[T]
+func NewTypedBuilder[T Integer]() *Builder[T] {
+	return &Builder[T]{NewBuilder()}
+}
+
+// PushBack append a value to the builder.
+func (b *Builder[T]) PushBack(val T) {
+	b.wmb.PushBack(encodeInteger(val))
+}
+
+// Build constructs Matrix[T] data structure
+func (b *Builder[T]) Build(opts ...BuildOption) *Matrix[T] {
+	return &Matrix[T]{b.wmb.Build(opts...)}
+}
+
+// Matrix is a WaveletMatrix storing values of an integer type T.
+//
+// Signed values are stored with an order-preserving bias, so comparison
+// based queries such as RankLessThan and Quantile follow the order of T,
+// with negative numbers before positive ones.
+type Matrix[T Integer] struct {
+	wm *WaveletMatrix
+}
+
+// NewMatrix wraps wm, whose values must have been encoded by a Builder[T].
+func NewMatrix[T Integer](wm *WaveletMatrix) *Matrix[T] {
+	return &Matrix[T]{wm}
+}
+
+// WaveletMatrix returns the underlying matrix storing the encoded values.
+func (m *Matrix[T]) WaveletMatrix() *WaveletMatrix {
+	return m.wm
+}
+
+// Num return the number of values in T
+func (m *Matrix[T]) Num() uint64 {
+	return m.wm.Num()
+}
+
+// Lookup returns T[pos]
+func (m *Matrix[T]) Lookup(pos uint64) T {
+	return decodeInteger[T](m.wm.Lookup(pos))
+}
+
+// Rank returns the number of c (== val) in T[0...pos)
+func (m *Matrix[T]) Rank(pos uint64, val T) uint64 {
+	return m.wm.Rank(pos, encodeInteger(val))
+}
+
+// RankLessThan returns the number of c (< val) in T[0...pos)
+func (m *Matrix[T]) RankLessThan(pos uint64, val T) uint64 {
+	return m.wm.RankLessThan(pos, encodeInteger(val))
+}
+
+// RankMoreThan returns the number of c (> val) in T[0...pos)
+func (m *Matrix[T]) RankMoreThan(pos uint64, val T) uint64 {
+	return m.wm.RankMoreThan(pos, encodeInteger(val))
+}
+
+// RangedRankOp returns the number of c that satisfies 'c op val'
+// in T[posRange.Beg, posRange.End).
+// The op should be one of {OpEqual, OpLessThan, OpMoreThan}.
+func (m *Matrix[T]) RangedRankOp(posRange Range, val T, op int) uint64 {
+	return m.wm.RangedRankOp(posRange, encodeInteger(val), op)
+}
+
+// RangedRankRange searches T[posRange.Beg, posRange.End) and
+// returns the number of c that falls within [beg, end).
+func (m *Matrix[T]) RangedRankRange(posRange Range, beg, end T) uint64 {
+	if end <= beg {
+		return 0
+	}
+	return m.wm.RangedRankRange(posRange, Range{encodeInteger(beg), encodeInteger(end)})
+}
+
+// Select returns the position of (rank+1)-th val in T.
+// If no match has been found, it returns Num().
+func (m *Matrix[T]) Select(rank uint64, val T) uint64 {
+	return m.wm.Select(rank, encodeInteger(val))
+}
+
+// RangedSelect takes T[posRange) and returns the position of rank+1'th val.
+// If no match has been found, it returns posRange.End.
+func (m *Matrix[T]) RangedSelect(posRange Range, rank uint64, val T) uint64 {
+	return m.wm.RangedSelect(posRange, rank, encodeInteger(val))
+}
+
+// LookupAndRank returns T[pos] and Rank(pos, T[pos]) in one call.
+func (m *Matrix[T]) LookupAndRank(pos uint64) (T, uint64) {
+	code, rank := m.wm.LookupAndRank(pos)
+	return decodeInteger[T](code), rank
+}
+
+// Quantile returns (k+1)th smallest value in T[posRange.Beg, posRange.End).
+func (m *Matrix[T]) Quantile(posRange Range, k uint64) T {
+	return decodeInteger[T](m.wm.Quantile(posRange, k))
+}
+
+// Intersect returns values that occur at least k ranges, in ascending order.
+func (m *Matrix[T]) Intersect(ranges []Range, k int) []T {
+	codes := m.wm.Intersect(ranges, k)
+	ret := make([]T, len(codes))
+	for i, code := range codes {
+		ret[i] = decodeInteger[T](code)
+	}
+	return ret
+}
+
+// Extract decodes T[posRange) into dst and returns dst[:posRange.End-posRange.Beg].
+// If dst is too short, a new slice is allocated.
+func (m *Matrix[T]) Extract(posRange Range, dst []T) []T {
+	codes := m.wm.Extract(posRange, nil)
+	if dst == nil || cap(dst) < len(codes) {
+		dst = make([]T, len(codes))
+	}
+	dst = dst[:len(codes)]
+	for i, code := range codes {
+		dst[i] = decodeInteger[T](code)
+	}
+	return dst
+}
+
+// Slice returns a new standalone Matrix[T] that stores T[posRange).
+func (m *Matrix[T]) Slice(posRange Range) *Matrix[T] {
+	return &Matrix[T]{m.wm.Slice(posRange)}
+}
+
+// MarshalBinary encodes Matrix[T] into a binary form and returns the result.
+func (m *Matrix[T]) MarshalBinary() ([]byte, error) {
+	return m.wm.MarshalBinary()
+}
+
+// UnmarshalBinary decodes Matrix[T] from a binary form generated MarshalBinary.
+func (m *Matrix[T]) UnmarshalBinary(in []byte) error {
+	wm := new(WaveletMatrix)
+	if err := wm.UnmarshalBinary(in); err != nil {
+		return err
+	}
+	m.wm = wm
+	return nil
+}
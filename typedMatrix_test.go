@@ -0,0 +1,131 @@
+package watrix
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIntegerEncoding(t *testing.T) {
+	Convey("The encoding should preserve the order of signed types", t, func() {
+		So(encodeInteger(int8(math.MinInt8)), ShouldEqual, 0)
+		So(encodeInteger(int8(-1)), ShouldEqual, 127)
+		So(encodeInteger(int8(0)), ShouldEqual, 128)
+		So(encodeInteger(int8(math.MaxInt8)), ShouldEqual, 255)
+		So(encodeInteger(int64(math.MinInt64)), ShouldEqual, 0)
+		So(encodeInteger(int64(-1)), ShouldBeLessThan, encodeInteger(int64(0)))
+		So(encodeInteger(uint16(7)), ShouldEqual, 7)
+		for _, v := range []int32{math.MinInt32, -5, 0, 5, math.MaxInt32} {
+			So(decodeInteger[int32](encodeInteger(v)), ShouldEqual, v)
+		}
+	})
+}
+
+func TestTypedMatrix(t *testing.T) {
+	Convey("When int64 values with negative numbers are built", t, func() {
+		num := uint64(2000)
+		orig := make([]int64, num)
+		b := NewTypedBuilder[int64]()
+		for i := range orig {
+			orig[i] = rand.Int63n(200) - 100
+			if i%50 == 0 {
+				orig[i] = math.MinInt64 + rand.Int63n(10)
+			}
+			b.PushBack(orig[i])
+		}
+		m := b.Build()
+
+		for i := 0; i < 20; i++ {
+			ranze := generateRange(num)
+			x := orig[rand.Intn(int(num))]
+			So(m.Lookup(ranze.Beg), ShouldEqual, orig[ranze.Beg])
+
+			rank, less, more, within := uint64(0), uint64(0), uint64(0), uint64(0)
+			for _, v := range orig[ranze.Beg:ranze.End] {
+				switch {
+				case v == x:
+					rank++
+				case v < x:
+					less++
+				default:
+					more++
+				}
+				if v >= -10 && v < 10 {
+					within++
+				}
+			}
+			So(m.RangedRankOp(ranze, x, OpEqual), ShouldEqual, rank)
+			So(m.RangedRankOp(ranze, x, OpLessThan), ShouldEqual, less)
+			So(m.RangedRankOp(ranze, x, OpMoreThan), ShouldEqual, more)
+			So(m.RangedRankRange(ranze, -10, 10), ShouldEqual, within)
+
+			v, r := m.LookupAndRank(ranze.Beg)
+			So(v, ShouldEqual, orig[ranze.Beg])
+			So(m.Select(r, v), ShouldEqual, ranze.Beg)
+
+			if ranze.End > ranze.Beg {
+				vs := append([]int64(nil), orig[ranze.Beg:ranze.End]...)
+				sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+				k := uint64(rand.Int63()) % uint64(len(vs))
+				So(m.Quantile(ranze, k), ShouldEqual, vs[k])
+				So(m.Quantile(ranze, 0), ShouldEqual, vs[0])
+			}
+			So(m.Extract(ranze, nil), ShouldResemble, orig[ranze.Beg:ranze.End])
+		}
+		Convey("Intersect should return values in the order of T", func() {
+			vals := m.Intersect([]Range{{0, num}}, 1)
+			So(sort.SliceIsSorted(vals, func(i, j int) bool { return vals[i] < vals[j] }), ShouldBeTrue)
+			So(vals[0], ShouldBeLessThan, 0)
+		})
+		Convey("The matrix should survive marshaling", func() {
+			out, err := m.MarshalBinary()
+			So(err, ShouldBeNil)
+			loaded := new(Matrix[int64])
+			So(loaded.UnmarshalBinary(out), ShouldBeNil)
+			So(loaded.Extract(Range{0, num}, nil), ShouldResemble, orig)
+		})
+	})
+	Convey("When int8 values are built with a compact alphabet", t, func() {
+		b := NewTypedBuilder[int8]()
+		for _, v := range []int8{-128, 5, -3, 127, 0, -3} {
+			b.PushBack(v)
+		}
+		m := b.Build(WithCompactAlphabet())
+		So(m.Quantile(Range{0, 6}, 0), ShouldEqual, -128)
+		So(m.Quantile(Range{0, 6}, 2), ShouldEqual, -3)
+		So(m.RankLessThan(6, 0), ShouldEqual, 3)
+		So(m.RankMoreThan(6, -3), ShouldEqual, 3)
+		So(m.Slice(Range{1, 4}).Extract(Range{0, 3}, nil), ShouldResemble, []int8{5, -3, 127})
+	})
+	Convey("When values above the largest stored value are queried", t, func() {
+		// The stored values fit in fewer bits than the queried ones.
+		b := NewTypedBuilder[int8]()
+		for _, v := range []int8{-50, -30, -10} {
+			b.PushBack(v)
+		}
+		m := b.Build()
+		So(m.RankLessThan(3, 5), ShouldEqual, 3)
+		So(m.RankMoreThan(3, 5), ShouldEqual, 0)
+		So(m.RankLessThan(3, -60), ShouldEqual, 0)
+		So(m.RankMoreThan(3, -60), ShouldEqual, 3)
+		So(m.Rank(3, 5), ShouldEqual, 0)
+		So(m.RangedRankRange(Range{0, 3}, -40, 100), ShouldEqual, 2)
+		So(m.Select(0, 5), ShouldEqual, 3)
+
+		u := NewTypedBuilder[uint64]()
+		for _, v := range []uint64{1, 2, 3} {
+			u.PushBack(v)
+		}
+		um := u.Build()
+		So(um.RankLessThan(3, 9), ShouldEqual, 3)
+		So(um.RankMoreThan(3, 9), ShouldEqual, 0)
+		So(um.RankMoreThan(3, 1), ShouldEqual, 2)
+		So(um.RangedRankRange(Range{0, 3}, 2, math.MaxUint64), ShouldEqual, 2)
+		So(um.Rank(3, 9), ShouldEqual, 0)
+		So(um.Select(0, 9), ShouldEqual, 3)
+		So(um.RangedSelect(Range{0, 3}, 0, 9), ShouldEqual, 3)
+	})
+}
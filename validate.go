@@ -0,0 +1,144 @@
+package watrix
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// ErrCorrupt is returned when a matrix is structurally inconsistent.
+var ErrCorrupt = errors.New("watrix: corrupt matrix")
+
+func corruptf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, args...))
+}
+
+// Validate checks that the fields and the layers of wm are consistent:
+// blen matches the layers, every layer has Num() bits, ZeroNum agrees
+// with Rank, the alphabet is sorted, and the values fit in dim.
+// It takes O(blen) rank operations.  The returned error wraps ErrCorrupt.
+//
+// Decoding runs Validate automatically, except OpenLazy, which runs only
+// the checks that do not read the layers.
+func (wm *WaveletMatrix) Validate() error {
+	if err := wm.validateHeader(); err != nil {
+		return err
+	}
+	for depth, layer := range wm.layers {
+		if layer.Num() != wm.num {
+			return corruptf("layer %d has %d bits, want %d", depth, layer.Num(), wm.num)
+		}
+		if zeros := layer.ZeroNum(); zeros > wm.num || zeros != layer.Rank(wm.num, false) {
+			return corruptf("layer %d has ZeroNum %d inconsistent with Rank", depth, zeros)
+		}
+	}
+	if wm.num > 0 {
+		if max := wm.quantile(Range{0, wm.num}, wm.num-1); max >= wm.codeDim() {
+			return corruptf("code %d is out of dim %d", max, wm.codeDim())
+		}
+	}
+	return nil
+}
+
+// ValidateDeep runs Validate, and also checks the rank and select
+// directories of every layer against its raw bits.
+// It takes time linear in the size of the layers.  Run it on a matrix from
+// OpenMapped before trusting queries on a file that may have been damaged.
+func (wm *WaveletMatrix) ValidateDeep() (err error) {
+	defer recoverCorrupt(&err)
+	if err := wm.Validate(); err != nil {
+		return err
+	}
+	for depth, layer := range wm.layers {
+		if bv, ok := layer.(*interleavedBitVector); ok {
+			if err := bv.validateBlocks(); err != nil {
+				return corruptf("layer %d: %v", depth, err)
+			}
+		}
+		if err := validateBitVector(layer); err != nil {
+			return corruptf("layer %d: %v", depth, err)
+		}
+	}
+	return nil
+}
+
+// validateHeader checks the fields of wm without reading the layers.
+func (wm *WaveletMatrix) validateHeader() error {
+	if wm.blen > 64 {
+		return corruptf("blen %d exceeds 64", wm.blen)
+	}
+	if uint64(len(wm.layers)) != wm.blen {
+		return corruptf("%d layers for blen %d", len(wm.layers), wm.blen)
+	}
+	if wm.alphabet == nil {
+		if wm.dim > 0 && getBinaryLen(wm.dim-1) > wm.blen {
+			return corruptf("dim %d does not fit in %d bits", wm.dim, wm.blen)
+		}
+		return nil
+	}
+	for i := 1; i < len(wm.alphabet); i++ {
+		if wm.alphabet[i-1] >= wm.alphabet[i] {
+			return corruptf("alphabet is not sorted at %d", i)
+		}
+	}
+	if n := uint64(len(wm.alphabet)); n > 0 {
+		if getBinaryLen(n-1) > wm.blen {
+			return corruptf("%d codes do not fit in %d bits", n, wm.blen)
+		}
+		if wm.dim != wm.alphabet[n-1]+1 {
+			return corruptf("dim %d does not match the alphabet", wm.dim)
+		}
+	} else if wm.dim != 0 {
+		return corruptf("dim %d for an empty alphabet", wm.dim)
+	}
+	return nil
+}
+
+// validateBitVector checks Bit, Rank and Select of bv against the raw bits:
+// Bit at every position, Rank at every word boundary, and Select of every
+// 64th one and zero.
+func validateBitVector(bv BitVector) error {
+	num := bv.Num()
+	words := bitVectorWords(bv)
+	if uint64(len(words)) != (num+63)/64 {
+		return fmt.Errorf("%d words for %d bits", len(words), num)
+	}
+	ones := uint64(0)
+	for i, word := range words {
+		pos := uint64(i) * 64
+		if r := bv.Rank(pos, true); r != ones {
+			return fmt.Errorf("Rank(%d) is %d, want %d", pos, r, ones)
+		}
+		if rem := num - pos; rem < 64 {
+			word &= (1 << rem) - 1
+		}
+		for j := 0; j < 64 && pos+uint64(j) < num; j++ {
+			if bv.Bit(pos+uint64(j)) != ((word>>j)&1 == 1) {
+				return fmt.Errorf("Bit(%d) disagrees with the bits", pos+uint64(j))
+			}
+		}
+		ones += uint64(bits.OnesCount64(word))
+	}
+	if r := bv.Rank(num, true); r != ones {
+		return fmt.Errorf("Rank(%d) is %d, want %d", num, r, ones)
+	}
+	if zeros := bv.ZeroNum(); zeros != num-ones {
+		return fmt.Errorf("ZeroNum is %d, want %d", zeros, num-ones)
+	}
+	for _, bit := range []bool{true, false} {
+		n := ones
+		if !bit {
+			n = num - ones
+		}
+		for rank := uint64(0); rank < n; rank += 64 {
+			pos := bv.Select(rank, bit)
+			if pos >= num || bv.Bit(pos) != bit || bv.Rank(pos, bit) != rank {
+				return fmt.Errorf("Select(%d, %v) is %d", rank, bit, pos)
+			}
+		}
+		if pos := bv.Select(n, bit); pos != num {
+			return fmt.Errorf("Select(%d, %v) is %d, want %d", n, bit, pos, num)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package watrix
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func shouldBeCorrupt(actual interface{}, expected ...interface{}) string {
+	err, _ := actual.(error)
+	if !errors.Is(err, ErrCorrupt) {
+		return fmt.Sprintf("Expected an error wrapping ErrCorrupt, but got %v", err)
+	}
+	return ""
+}
+
+func TestValidate(t *testing.T) {
+	Convey("When matrices are built", t, func() {
+		orig := make([]uint64, 3000)
+		for i := range orig {
+			orig[i] = uint64(rand.Int63n(1000)) << 20
+		}
+		wmb := NewBuilder()
+		for _, val := range orig {
+			wmb.PushBack(val)
+		}
+		matrices := []*WaveletMatrix{
+			NewBuilder().Build(),
+			NewBuilder().Build(WithCompactAlphabet()),
+			wmb.Build(WithCompactAlphabet()),
+			buildFromSlice(orig[:10]).Slice(Range{2, 7}),
+			Concat(buildFromSlice([]uint64{1, 2}), buildFromSlice([]uint64{300})),
+		}
+		for backend := range backends {
+			matrices = append(matrices, wmb.Build(WithBackend(backend)))
+		}
+		Convey("They should be valid", func() {
+			for _, wm := range matrices {
+				So(wm.Validate(), ShouldBeNil)
+				So(wm.ValidateDeep(), ShouldBeNil)
+			}
+		})
+	})
+	Convey("When a matrix is inconsistent", t, func() {
+		build := func() *WaveletMatrix {
+			return buildFromSlice([]uint64{3, 1, 4, 1, 5, 9, 2, 6})
+		}
+		wm := build()
+		wm.blen++
+		So(wm.Validate(), shouldBeCorrupt)
+
+		wm = build()
+		wm.num--
+		So(wm.Validate(), shouldBeCorrupt)
+
+		wm = build()
+		wm.dim = 9 // 9 is stored
+		So(wm.Validate(), shouldBeCorrupt)
+
+		wm = build()
+		wm.dim = 100 // wider than blen
+		So(wm.Validate(), shouldBeCorrupt)
+
+		wmb := NewBuilder()
+		for _, val := range []uint64{10, 20, 30} {
+			wmb.PushBack(val)
+		}
+		wm = wmb.Build(WithCompactAlphabet())
+		wm.alphabet[0], wm.alphabet[1] = wm.alphabet[1], wm.alphabet[0]
+		So(wm.Validate(), shouldBeCorrupt)
+
+		Convey("It should be rejected on decoding", func() {
+			wm := build()
+			wm.dim = 9
+			out, err := wm.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(new(WaveletMatrix).UnmarshalBinary(out), shouldBeCorrupt)
+		})
+	})
+	Convey("When a rank directory is corrupt", t, func() {
+		vals := make([]uint64, 5000)
+		for i := range vals {
+			vals[i] = uint64(rand.Int63n(64))
+		}
+		wmb := NewBuilder()
+		for _, val := range vals {
+			wmb.PushBack(val)
+		}
+		wm := wmb.Build(WithBackend(BackendPlain))
+		wm.layers[2].(*plainBitVector).supers[3]++
+		So(wm.ValidateDeep(), shouldBeCorrupt)
+	})
+}
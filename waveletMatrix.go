@@ -14,10 +14,8 @@ package watrix
 
 import (
 	"bufio"
+	"bytes"
 	"os"
-
-	"github.com/hillbig/rsdic"
-	"github.com/ugorji/go/codec"
 )
 
 // Range represents a range [Beg, End).
@@ -45,10 +43,15 @@ const (
 // Query time depends on the number of bits of the data stored.  Querying on
 // 16-bit value sequence is 4-times faster than that on 64-bit value sequence.
 type WaveletMatrix struct {
-	layers []rsdic.RSDic
-	dim    uint64
-	num    uint64
-	blen   uint64 // =len(layers)
+	layers  []BitVector
+	dim     uint64
+	num     uint64
+	blen    uint64 // =len(layers)
+	backend Backend
+
+	// alphabet is the sorted dictionary of the distinct values when the
+	// matrix stores dense codes instead of the values (nil otherwise).
+	alphabet []uint64
 }
 
 // Num return the number of values in T
@@ -61,6 +64,11 @@ func (wm *WaveletMatrix) Dim() uint64 {
 	return wm.dim
 }
 
+// Backend returns the BitVector backend of the layers.
+func (wm *WaveletMatrix) Backend() Backend {
+	return wm.backend
+}
+
 // Lookup returns T[pos]
 func (wm *WaveletMatrix) Lookup(pos uint64) uint64 {
 	val := uint64(0)
@@ -74,7 +82,7 @@ func (wm *WaveletMatrix) Lookup(pos uint64) uint64 {
 			pos = rsd.ZeroNum() + rsd.Rank(pos, true)
 		}
 	}
-	return val
+	return wm.decode(val)
 }
 
 // Rank returns the number of c (== val) in T[0...pos)
@@ -96,6 +104,35 @@ func (wm *WaveletMatrix) RankMoreThan(pos uint64, val uint64) (rankLessThan uint
 // in T[posRange.Beg, posRange.End).
 // The op should be one of {OpEqual, OpLessThan, OpMoreThan}.
 func (wm *WaveletMatrix) RangedRankOp(posRange Range, val uint64, op int) (rankResult uint64) {
+	if wm.alphabet != nil {
+		switch op {
+		case OpEqual:
+			code, ok := alphabetCode(wm.alphabet, val)
+			if !ok {
+				return 0
+			}
+			return wm.rangedRankOp(posRange, code, OpEqual)
+		case OpLessThan:
+			return wm.rangedRankLessThan(posRange, alphabetLowerBound(wm.alphabet, val))
+		case OpMoreThan:
+			return posRange.End - posRange.Beg - wm.rangedRankLessThan(posRange, alphabetUpperBound(wm.alphabet, val))
+		default:
+			return 0
+		}
+	}
+	if getBinaryLen(val) > wm.blen {
+		// val is larger than any value that fits in blen bits.
+		switch op {
+		case OpLessThan:
+			return posRange.End - posRange.Beg
+		default:
+			return 0
+		}
+	}
+	return wm.rangedRankOp(posRange, val, op)
+}
+
+func (wm *WaveletMatrix) rangedRankOp(posRange Range, val uint64, op int) (rankResult uint64) {
 	rankLessThan := uint64(0)
 	rankMoreThan := uint64(0)
 	for depth := uint64(0); depth < wm.blen; depth++ {
@@ -159,6 +196,13 @@ func (wm *WaveletMatrix) rangedRankIgnoreLSBsHelper(posRange Range, val uint64,
 // This behavior is useful for IP address prefix search such as 192.168.10.0/24
 // (ignoreBits in this case, is 8).
 func (wm *WaveletMatrix) RangedRankIgnoreLSBs(posRange Range, val, ignoreBits uint64) (rank uint64) {
+	if wm.alphabet != nil {
+		codeRange := wm.ignoreLSBsCodeRange(val, ignoreBits)
+		return wm.rangedRankLessThan(posRange, codeRange.End) - wm.rangedRankLessThan(posRange, codeRange.Beg)
+	}
+	if wm.exceedsIgnoreLSBs(val, ignoreBits) {
+		return 0
+	}
 	r := wm.rangedRankIgnoreLSBsHelper(posRange, val, ignoreBits)
 	return r.End - r.Beg
 }
@@ -185,6 +229,18 @@ func (wm *WaveletMatrix) rangedSelectIgnoreLSBsHelper(pos, val, ignoreBits uint6
 // This behavior is useful for IP address prefix search such as 192.168.10.0/24
 // (ignoreBits in this case, is 8).
 func (wm *WaveletMatrix) RangedSelectIgnoreLSBs(posRange Range, rank, val, ignoreBits uint64) (position uint64) {
+	if wm.alphabet != nil {
+		if ignoreBits > 0 {
+			return wm.rangedSelectCodeRange(posRange, rank, wm.ignoreLSBsCodeRange(val, ignoreBits))
+		}
+		code, ok := alphabetCode(wm.alphabet, val)
+		if !ok {
+			return posRange.End
+		}
+		val = code
+	} else if wm.exceedsIgnoreLSBs(val, ignoreBits) {
+		return posRange.End
+	}
 	r := wm.rangedRankIgnoreLSBsHelper(posRange, val, ignoreBits)
 	pos := r.Beg + rank
 	if r.End <= pos {
@@ -196,6 +252,15 @@ func (wm *WaveletMatrix) RangedSelectIgnoreLSBs(posRange Range, rank, val, ignor
 // Select returns the position of (rank+1)-th val in T.
 // If no match has been found, it returns Num().
 func (wm *WaveletMatrix) Select(rank uint64, val uint64) (position uint64) {
+	if wm.alphabet != nil {
+		code, ok := alphabetCode(wm.alphabet, val)
+		if !ok {
+			return wm.num
+		}
+		val = code
+	} else if val >= wm.dim {
+		return wm.num
+	}
 	return wm.selectHelper(rank, val, 0, 0)
 	// return wm.RangedSelectIgnoreLSBs(Range{0, wm.Num()}, rank, val, 0)
 }
@@ -246,11 +311,15 @@ func (wm *WaveletMatrix) LookupAndRank(pos uint64) (uint64, uint64) {
 			val |= 1
 		}
 	}
-	return val, epos - bpos
+	return wm.decode(val), epos - bpos
 }
 
 // Quantile returns (k+1)th smallest value in T[posRange.Beg, posRange.End).
 func (wm *WaveletMatrix) Quantile(posRange Range, k uint64) uint64 {
+	return wm.decode(wm.quantile(posRange, k))
+}
+
+func (wm *WaveletMatrix) quantile(posRange Range, k uint64) uint64 {
 	val := uint64(0)
 	bpos, epos := posRange.Beg, posRange.End
 	for depth := 0; depth < len(wm.layers); depth++ {
@@ -274,7 +343,11 @@ func (wm *WaveletMatrix) Quantile(posRange Range, k uint64) uint64 {
 
 // Intersect returns values that occur at least k ranges.
 func (wm *WaveletMatrix) Intersect(ranges []Range, k int) []uint64 {
-	return wm.intersectHelper(ranges, k, 0, 0)
+	ret := wm.intersectHelper(ranges, k, 0, 0)
+	for i, code := range ret {
+		ret[i] = wm.decode(code)
+	}
+	return ret
 }
 
 func (wm *WaveletMatrix) intersectHelper(ranges []Range, k int, depth uint64, prefix uint64) []uint64 {
@@ -310,149 +383,62 @@ func (wm *WaveletMatrix) intersectHelper(ranges []Range, k int, depth uint64, pr
 }
 
 // MarshalBinary encodes WaveletMatrix into a binary form and returns the result.
-func (wm *WaveletMatrix) MarshalBinary() (out []byte, err error) {
-	var bh codec.MsgpackHandle
-	enc := codec.NewEncoderBytes(&out, &bh)
-	err = enc.Encode(len(wm.layers))
-	if err != nil {
-		return
-	}
-	for i := 0; i < len(wm.layers); i++ {
-		err = enc.Encode(wm.layers[i])
-		if err != nil {
-			return
-		}
-	}
-	err = enc.Encode(wm.dim)
-	if err != nil {
-		return
-	}
-	err = enc.Encode(wm.num)
-	if err != nil {
-		return
+func (wm *WaveletMatrix) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := wm.WriteTo(&buf); err != nil {
+		return nil, err
 	}
-	err = enc.Encode(wm.blen)
-	if err != nil {
-		return
-	}
-	return
+	return buf.Bytes(), nil
 }
 
 // MarshalBinary encodes WaveletMatrix into a binary form and writes it to a file.
-func (wm *WaveletMatrix) MarshalBinaryFile(outpath string) error {
-	var bh codec.MsgpackHandle
-
+// The options are those of Encode.
+func (wm *WaveletMatrix) MarshalBinaryFile(outpath string, opts ...EncodeOption) (err error) {
 	f, err := os.Create(outpath)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
 
 	bufWriter := bufio.NewWriter(f)
-
-	enc := codec.NewEncoder(bufWriter, &bh)
-
-	err = enc.Encode(len(wm.layers))
-	if err != nil {
-		return err
-	}
-	for i := 0; i < len(wm.layers); i++ {
-		err = enc.Encode(wm.layers[i])
-		if err != nil {
-			return err
-		}
-	}
-	err = enc.Encode(wm.dim)
-	if err != nil {
-		return err
-	}
-	err = enc.Encode(wm.num)
-	if err != nil {
+	if _, err = wm.Encode(bufWriter, opts...); err != nil {
 		return err
 	}
-	err = enc.Encode(wm.blen)
-	if err != nil {
-		return err
-	}
-
-	bufWriter.Flush()
-
-	return nil
+	return bufWriter.Flush()
 }
 
 // UnmarshalBinary decodes WaveletMatrix from a binary form generated MarshalBinary.
-func (wm *WaveletMatrix) UnmarshalBinary(in []byte) (err error) {
-	var bh codec.MsgpackHandle
-	dec := codec.NewDecoderBytes(in, &bh)
-	layerNum := 0
-	err = dec.Decode(&layerNum)
-	if err != nil {
-		return
-	}
-	wm.layers = make([]rsdic.RSDic, layerNum)
-	for i := 0; i < layerNum; i++ {
-		wm.layers[i] = *rsdic.New()
-		err = dec.Decode(&wm.layers[i])
-		if err != nil {
-			return
-		}
-	}
-	err = dec.Decode(&wm.dim)
-	if err != nil {
-		return
-	}
-	err = dec.Decode(&wm.num)
-	if err != nil {
-		return
-	}
-	err = dec.Decode(&wm.blen)
-	if err != nil {
-		return
-	}
-	return
+// It also accepts the legacy form written by earlier versions.
+func (wm *WaveletMatrix) UnmarshalBinary(in []byte) error {
+	_, err := wm.ReadFrom(bytes.NewReader(in))
+	return err
 }
 
 // UnmarshalBinaryFile decodes WaveletMatrix from a binary file generated MarshalBinaryFile.
+// It also accepts the legacy form written by earlier versions.
 func (wm *WaveletMatrix) UnmarshalBinaryFile(inpath string) error {
-	var bh codec.MsgpackHandle
-
 	f, err := os.Open(inpath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	bufReader := bufio.NewReader(f)
-
-	dec := codec.NewDecoder(bufReader, &bh)
+	_, err = wm.ReadFrom(bufio.NewReader(f))
+	return err
+}
 
-	layerNum := 0
-	err = dec.Decode(&layerNum)
-	if err != nil {
-		return err
-	}
-	wm.layers = make([]rsdic.RSDic, layerNum)
-	for i := 0; i < layerNum; i++ {
-		wm.layers[i] = *rsdic.New()
-		err = dec.Decode(&wm.layers[i])
-		if err != nil {
-			return err
-		}
-	}
-	err = dec.Decode(&wm.dim)
-	if err != nil {
-		return err
-	}
-	err = dec.Decode(&wm.num)
-	if err != nil {
-		return err
+// exceedsIgnoreLSBs reports whether val has a bit set above blen bits that is
+// not ignored, i.e. no value can match val.
+func (wm *WaveletMatrix) exceedsIgnoreLSBs(val, ignoreBits uint64) bool {
+	shift := wm.blen
+	if ignoreBits > shift {
+		shift = ignoreBits
 	}
-	err = dec.Decode(&wm.blen)
-	if err != nil {
-		return err
-	}
-
-	return err
+	return shift < 64 && val>>shift != 0
 }
 
 func getMSB(x uint64, pos uint64, blen uint64) bool {
@@ -0,0 +1,105 @@
+package watrix
+
+// batchChunk is the number of queries that the batched queries advance
+// together through the layers.
+const batchChunk = 256
+
+// LookupBatch returns T[pos] for each pos in poss, in the same order.
+// The result is stored in dst if it has enough capacity.
+//
+// The queries are advanced together layer by layer: each layer is
+// visited once per chunk of batchChunk queries rather than once per query,
+// and the queries of a chunk do not depend on each other.  This pays off
+// with the uncompressed backends such as BackendPlain and
+// BackendInterleaved; see the Benchmark section of the README.
+func (wm *WaveletMatrix) LookupBatch(poss []uint64, dst []uint64) []uint64 {
+	if dst == nil || uint64(cap(dst)) < uint64(len(poss)) {
+		dst = make([]uint64, len(poss))
+	}
+	dst = dst[:len(poss)]
+	var cur [batchChunk]uint64
+	for beg := 0; beg < len(poss); beg += batchChunk {
+		n := copy(cur[:], poss[beg:])
+		vals := dst[beg : beg+n]
+		for i := range vals {
+			vals[i] = 0
+		}
+		for _, rsd := range wm.layers {
+			zeroNum := rsd.ZeroNum()
+			for i := 0; i < n; i++ {
+				pos := cur[i]
+				if !rsd.Bit(pos) {
+					vals[i] <<= 1
+					cur[i] = rsd.Rank(pos, false)
+				} else {
+					vals[i] = vals[i]<<1 | 1
+					cur[i] = zeroNum + rsd.Rank(pos, true)
+				}
+			}
+		}
+		for i := range vals {
+			vals[i] = wm.decode(vals[i])
+		}
+	}
+	return dst
+}
+
+// RangedRankOpBatch returns RangedRankOp(posRanges[i], vals[i], op) for
+// each i, in the same order.  posRanges and vals must have the same length.
+// The result is stored in dst if it has enough capacity.
+//
+// The queries are advanced together layer by layer like LookupBatch.
+// A matrix built with WithCompactAlphabet answers them one by one.
+func (wm *WaveletMatrix) RangedRankOpBatch(posRanges []Range, vals []uint64, op int, dst []uint64) []uint64 {
+	if dst == nil || uint64(cap(dst)) < uint64(len(posRanges)) {
+		dst = make([]uint64, len(posRanges))
+	}
+	dst = dst[:len(posRanges)]
+	if wm.alphabet != nil || (op != OpEqual && op != OpLessThan && op != OpMoreThan) {
+		for i, posRange := range posRanges {
+			dst[i] = wm.RangedRankOp(posRange, vals[i], op)
+		}
+		return dst
+	}
+	var cur [batchChunk]Range
+	var curVals [batchChunk]uint64
+	var idx [batchChunk]int
+	for beg := 0; beg < len(posRanges); beg += batchChunk {
+		// Queries of values wider than blen are answered up front.
+		n := 0
+		for i := beg; i < len(posRanges) && i < beg+batchChunk; i++ {
+			dst[i] = 0
+			if getBinaryLen(vals[i]) > wm.blen {
+				dst[i] = wm.RangedRankOp(posRanges[i], vals[i], op)
+				continue
+			}
+			cur[n], curVals[n], idx[n] = posRanges[i], vals[i], i
+			n++
+		}
+		for depth, rsd := range wm.layers {
+			zeroNum := rsd.ZeroNum()
+			for i := 0; i < n; i++ {
+				posRange := cur[i]
+				begOnes := rsd.Rank(posRange.Beg, true)
+				endOnes := rsd.Rank(posRange.End, true)
+				if getMSB(curVals[i], uint64(depth), wm.blen) {
+					if op == OpLessThan {
+						dst[idx[i]] += (posRange.End - endOnes) - (posRange.Beg - begOnes)
+					}
+					cur[i] = Range{zeroNum + begOnes, zeroNum + endOnes}
+				} else {
+					if op == OpMoreThan {
+						dst[idx[i]] += endOnes - begOnes
+					}
+					cur[i] = Range{posRange.Beg - begOnes, posRange.End - endOnes}
+				}
+			}
+		}
+		if op == OpEqual {
+			for i := 0; i < n; i++ {
+				dst[idx[i]] = cur[i].End - cur[i].Beg
+			}
+		}
+	}
+	return dst
+}
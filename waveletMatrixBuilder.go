@@ -1,9 +1,5 @@
 package watrix
 
-import (
-	"github.com/hillbig/rsdic"
-)
-
 // WaveletMatrixBuilder builds WaveletMatrix from integer array.
 // A user calls PushBack()s followed by Build().
 type WaveletMatrixBuilder struct {
@@ -26,27 +22,93 @@ func (wmb *WaveletMatrixBuilder) PushBack(val uint64) {
 	}
 }
 
+// BuildOption configures Build.
+type BuildOption func(*buildConfig)
+
+type buildConfig struct {
+	compactAlphabet bool
+	backend         Backend
+}
+
+// WithCompactAlphabet makes Build remap the values to dense codes through a
+// sorted dictionary of the distinct values, which is stored with the matrix.
+// The number of layers then depends on the number of distinct values instead
+// of the largest value.  All queries keep accepting and returning the
+// original values.
+func WithCompactAlphabet() BuildOption {
+	return func(conf *buildConfig) {
+		conf.compactAlphabet = true
+	}
+}
+
+// WithBackend makes Build store the layers in the given BitVector backend.
+// The default is BackendRSDic, which is also used, and recorded, for an
+// unknown backend.
+func WithBackend(backend Backend) BuildOption {
+	return func(conf *buildConfig) {
+		conf.backend = backend
+	}
+}
+
+func newBuildConfig(opts []BuildOption) buildConfig {
+	var conf buildConfig
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	if _, err := conf.backend.info(); err != nil {
+		conf.backend = BackendRSDic
+	}
+	return conf
+}
+
+// matches reports whether wm is stored as Build with conf would store it.
+func (conf buildConfig) matches(wm *WaveletMatrix) bool {
+	return wm.backend == conf.backend && wm.Compacted() == conf.compactAlphabet
+}
+
 // Build constructs WaveletMatrix data structure
-func (wmb *WaveletMatrixBuilder) Build() *WaveletMatrix {
-	blen := getBinaryLen(wmb.dim)
-	num := len(wmb.vals)
-	zeros := wmb.vals
+func (wmb *WaveletMatrixBuilder) Build(opts ...BuildOption) *WaveletMatrix {
+	conf := newBuildConfig(opts)
+	if conf.compactAlphabet {
+		alphabet := makeAlphabet(wmb.vals)
+		codes := make([]uint64, len(wmb.vals))
+		for i, val := range wmb.vals {
+			codes[i], _ = alphabetCode(alphabet, val)
+		}
+		wm := buildLayers(codes, uint64(len(alphabet)), conf.backend)
+		wm.dim = wmb.dim
+		wm.alphabet = alphabet
+		return wm
+	}
+	return buildLayers(wmb.vals, wmb.dim, conf.backend)
+}
+
+func buildLayers(vals []uint64, dim uint64, backend Backend) *WaveletMatrix {
+	blen := getBinaryLen(dim)
+	num := len(vals)
+	zeros := vals
 	ones := make([]uint64, 0, num)
-	layers := make([]rsdic.RSDic, blen)
+	layers := make([]BitVector, blen)
 	for depth := uint64(0); depth < blen; depth++ {
 		nextZeros := make([]uint64, 0, num)
 		nextOnes := make([]uint64, 0, num)
-		rsd := rsdic.New()
-		filter(zeros, blen-depth-1, &nextZeros, &nextOnes, rsd)
-		filter(ones, blen-depth-1, &nextZeros, &nextOnes, rsd)
+		bvb := backend.newBitVectorBuilder()
+		filter(zeros, blen-depth-1, &nextZeros, &nextOnes, bvb)
+		filter(ones, blen-depth-1, &nextZeros, &nextOnes, bvb)
 		zeros = nextZeros
 		ones = nextOnes
-		layers[depth] = *rsd
+		layers[depth] = bvb.Build()
+	}
+	return &WaveletMatrix{
+		layers:  layers,
+		dim:     dim,
+		num:     uint64(num),
+		blen:    blen,
+		backend: backend,
 	}
-	return &WaveletMatrix{layers, wmb.dim, uint64(num), blen}
 }
 
-func filter(vals []uint64, depth uint64, nextZeros *[]uint64, nextOnes *[]uint64, rsd *rsdic.RSDic) {
+func filter(vals []uint64, depth uint64, nextZeros *[]uint64, nextOnes *[]uint64, rsd BitVectorBuilder) {
 	for _, val := range vals {
 		bit := ((val >> depth) & 1) == 1
 		rsd.PushBack(bit)
@@ -0,0 +1,158 @@
+package watrix
+
+// Concat returns a WaveletMatrix equivalent to the one built over
+// the concatenation of the sequences stored in a and b.
+func Concat(a, b *WaveletMatrix) *WaveletMatrix {
+	return ConcatN(a, b)
+}
+
+// ConcatN returns a WaveletMatrix equivalent to the one built over
+// the concatenation of the sequences stored in wms, in order.
+//
+// The result is constructed layer by layer from the bits of the existing
+// layers, without decoding the stored values.  Matrices with a narrower
+// bit length are treated as if padded with leading zero layers.
+//
+// Compacted matrices (see WithCompactAlphabet) have different dictionaries,
+// so they are decoded with Extract and rebuilt with a merged dictionary.
+func ConcatN(wms ...*WaveletMatrix) *WaveletMatrix {
+	for _, wm := range wms {
+		if wm.alphabet != nil {
+			return concatCompacted(wms)
+		}
+	}
+	dim := uint64(0)
+	num := uint64(0)
+	for _, wm := range wms {
+		if wm.dim > dim {
+			dim = wm.dim
+		}
+		num += wm.num
+	}
+	blen := getBinaryLen(dim)
+	backend := BackendRSDic
+	if len(wms) > 0 {
+		backend = wms[0].backend
+	}
+	info, err := backend.info()
+	if err != nil {
+		backend, info = BackendRSDic, backends[BackendRSDic]
+	}
+	layers := make([]BitVector, blen)
+
+	// Each layer of a wavelet matrix is sorted by the reversed prefix of the
+	// values (stably), so a layer of the concatenation consists of groups of
+	// equal prefixes, and each group holds the corresponding range of every
+	// input layer in order.  groups[g*k+i] is the range of wms[i] in group g.
+	// The ranges are copied a word at a time.
+	k := len(wms)
+	groups := make([]Range, k)
+	for i, wm := range wms {
+		groups[i] = Range{0, wm.num}
+	}
+	var zeroGroups, oneGroups []Range
+	srcWords := make([][]uint64, k)
+	for depth := uint64(0); depth < blen; depth++ {
+		for i, wm := range wms {
+			srcWords[i] = nil
+			if d := depth + wm.blen - blen; d < wm.blen {
+				srcWords[i] = bitVectorWords(wm.layers[d])
+			}
+		}
+		bw := bitWriter{words: make([]uint64, 0, (num+63)/64)}
+		zeroGroups, oneGroups = zeroGroups[:0], oneGroups[:0]
+		for g := 0; g < len(groups); g += k {
+			zeroSize, oneSize := uint64(0), uint64(0)
+			for i, wm := range wms {
+				posRange := groups[g+i]
+				if srcWords[i] == nil {
+					bw.appendZeros(posRange.End - posRange.Beg)
+				} else {
+					bw.appendBits(srcWords[i], posRange)
+				}
+				zeroRange, oneRange := splitLayerRange(wm, depth+wm.blen-blen, posRange)
+				zeroGroups = append(zeroGroups, zeroRange)
+				oneGroups = append(oneGroups, oneRange)
+				zeroSize += zeroRange.End - zeroRange.Beg
+				oneSize += oneRange.End - oneRange.Beg
+			}
+			if zeroSize == 0 {
+				zeroGroups = zeroGroups[:len(zeroGroups)-k]
+			}
+			if oneSize == 0 {
+				oneGroups = oneGroups[:len(oneGroups)-k]
+			}
+		}
+		layers[depth] = info.fromWords(bw.num, bw.words)
+		groups = append(append(groups[:0], zeroGroups...), oneGroups...)
+	}
+	return &WaveletMatrix{
+		layers:  layers,
+		dim:     dim,
+		num:     num,
+		blen:    blen,
+		backend: backend,
+	}
+}
+
+func concatCompacted(wms []*WaveletMatrix) *WaveletMatrix {
+	wmb := NewBuilder()
+	var vals []uint64
+	for _, wm := range wms {
+		vals = wm.Extract(Range{0, wm.num}, vals)
+		for _, val := range vals {
+			wmb.PushBack(val)
+		}
+	}
+	return wmb.Build(WithCompactAlphabet(), WithBackend(wms[0].backend))
+}
+
+// bitWriter appends bits to words packed from the LSB.
+type bitWriter struct {
+	words []uint64
+	num   uint64
+}
+
+// appendBits appends the bits src[posRange) to bw, taking as many bits at a
+// time as the source and the destination words allow.
+func (bw *bitWriter) appendBits(src []uint64, posRange Range) {
+	for pos := posRange.Beg; pos < posRange.End; {
+		n := 64 - pos%64
+		if free := 64 - bw.num%64; free < n {
+			n = free
+		}
+		if left := posRange.End - pos; left < n {
+			n = left
+		}
+		chunk := src[pos/64] >> (pos % 64)
+		if n < 64 {
+			chunk &= (1 << n) - 1
+		}
+		if bw.num%64 == 0 {
+			bw.words = append(bw.words, 0)
+		}
+		bw.words[bw.num/64] |= chunk << (bw.num % 64)
+		bw.num += n
+		pos += n
+	}
+}
+
+// appendZeros appends n zeros to bw.
+func (bw *bitWriter) appendZeros(n uint64) {
+	bw.num += n
+	for uint64(len(bw.words)) < (bw.num+63)/64 {
+		bw.words = append(bw.words, 0)
+	}
+}
+
+// splitLayerRange returns the ranges of the next layer that the zeros and
+// the ones of wm.layers[depth][posRange) are moved to.
+func splitLayerRange(wm *WaveletMatrix, depth uint64, posRange Range) (zeroRange, oneRange Range) {
+	if depth >= wm.blen {
+		return posRange, Range{wm.num, wm.num}
+	}
+	rsd := wm.layers[depth]
+	zeroRange = Range{rsd.Rank(posRange.Beg, false), rsd.Rank(posRange.End, false)}
+	oneRange = Range{rsd.ZeroNum() + rsd.Rank(posRange.Beg, true), rsd.ZeroNum() + rsd.Rank(posRange.End, true)}
+	return zeroRange, oneRange
+}
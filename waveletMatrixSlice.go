@@ -0,0 +1,131 @@
+package watrix
+
+// Slice returns a new standalone WaveletMatrix that stores T[posRange).
+// The result is equivalent to the one built over the values in posRange,
+// and is constructed layer by layer without decoding each value, copying
+// the bits a word at a time.
+//
+// A compacted matrix is decoded with Extract and rebuilt, so that its
+// dictionary holds only the values in posRange, as Build would make it.
+func (wm *WaveletMatrix) Slice(posRange Range) *WaveletMatrix {
+	if wm.alphabet != nil {
+		wmb := NewBuilder()
+		for _, val := range wm.Extract(posRange, nil) {
+			wmb.PushBack(val)
+		}
+		return wmb.Build(WithCompactAlphabet(), WithBackend(wm.backend))
+	}
+	num := posRange.End - posRange.Beg
+	codeDim := uint64(0)
+	if num > 0 {
+		codeDim = wm.quantile(posRange, num-1) + 1
+	}
+	blen := getBinaryLen(codeDim)
+	skip := wm.blen - blen // leading layers where every value in posRange is 0
+	backend := wm.backend
+	info, err := backend.info()
+	if err != nil {
+		backend, info = BackendRSDic, backends[BackendRSDic]
+	}
+	layers := make([]BitVector, blen)
+	var bw bitWriter
+	var srcWords []uint64
+	wm.walkRanges(posRange, func(depth uint64, layerRange Range) {
+		if depth < skip {
+			return
+		}
+		if bw.num == 0 {
+			srcWords = bitVectorWords(wm.layers[depth])
+			bw = bitWriter{words: make([]uint64, 0, (num+63)/64)}
+		}
+		bw.appendBits(srcWords, layerRange)
+		if bw.num == num {
+			layers[depth-skip] = info.fromWords(bw.num, bw.words)
+			bw = bitWriter{}
+		}
+	})
+	return &WaveletMatrix{
+		layers:  layers,
+		dim:     codeDim,
+		num:     num,
+		blen:    blen,
+		backend: backend,
+	}
+}
+
+// Extract decodes T[posRange) into dst and returns dst[:posRange.End-posRange.Beg].
+// If dst is too short, a new slice is allocated.
+//
+// Extract decodes the whole range layer by layer, which is much faster than
+// calling Lookup for each position.
+func (wm *WaveletMatrix) Extract(posRange Range, dst []uint64) []uint64 {
+	num := posRange.End - posRange.Beg
+	if dst == nil || uint64(cap(dst)) < num {
+		dst = make([]uint64, num)
+	}
+	dst = dst[:num]
+	for i := range dst {
+		dst[i] = 0
+	}
+	// order[k] is the index in dst of the k-th value of posRange in the
+	// order of the current layer.
+	order := make([]uint64, num)
+	for i := range order {
+		order[i] = uint64(i)
+	}
+	zeros := make([]uint64, 0, num)
+	ones := make([]uint64, 0, num)
+	cursor := 0
+	wm.walkRanges(posRange, func(depth uint64, layerRange Range) {
+		rsd := wm.layers[depth]
+		for pos := layerRange.Beg; pos < layerRange.End; pos++ {
+			i := order[cursor]
+			cursor++
+			dst[i] <<= 1
+			if rsd.Bit(pos) {
+				dst[i] |= 1
+				ones = append(ones, i)
+			} else {
+				zeros = append(zeros, i)
+			}
+		}
+		if cursor == len(order) {
+			order = append(order[:0], zeros...)
+			order = append(order, ones...)
+			zeros, ones = zeros[:0], ones[:0]
+			cursor = 0
+		}
+	})
+	if wm.alphabet != nil {
+		for i, code := range dst {
+			dst[i] = wm.alphabet[code]
+		}
+	}
+	return dst
+}
+
+// walkRanges calls visit, layer by layer, for every range of the layer that
+// holds values of T[posRange).  The ranges of each layer are visited in the
+// order of the layer, i.e. the stable order of the values sorted by their
+// reversed prefix.  Empty ranges are skipped.
+func (wm *WaveletMatrix) walkRanges(posRange Range, visit func(depth uint64, layerRange Range)) {
+	ranges := make([]Range, 0, 1)
+	if posRange.End > posRange.Beg {
+		ranges = append(ranges, posRange)
+	}
+	for depth := uint64(0); depth < wm.blen && len(ranges) > 0; depth++ {
+		zeroRanges := make([]Range, 0, len(ranges))
+		oneRanges := make([]Range, 0, len(ranges))
+		for _, layerRange := range ranges {
+			visit(depth, layerRange)
+			zeroRange, oneRange := splitLayerRange(wm, depth, layerRange)
+			if zeroRange.End > zeroRange.Beg {
+				zeroRanges = append(zeroRanges, zeroRange)
+			}
+			if oneRange.End > oneRange.Beg {
+				oneRanges = append(oneRanges, oneRange)
+			}
+		}
+		ranges = append(zeroRanges, oneRanges...)
+	}
+}
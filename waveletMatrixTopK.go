@@ -0,0 +1,80 @@
+package watrix
+
+import (
+	"container/heap"
+)
+
+// ValueCount is a value and the number of its occurrences.
+type ValueCount struct {
+	Value uint64
+	Count uint64
+}
+
+// TopK returns the k most frequent values in T[posRange.Beg, posRange.End)
+// with their counts, in descending order of the count.  Values with the
+// same count are in ascending order.
+//
+// It visits the nodes of the matrix from the widest range, so it stops
+// after the k-th value rather than counting every distinct value.
+func (wm *WaveletMatrix) TopK(posRange Range, k int) []ValueCount {
+	ret := make([]ValueCount, 0)
+	if k <= 0 || posRange.End <= posRange.Beg {
+		return ret
+	}
+	nodes := &topKHeap{{posRange: posRange}}
+	for nodes.Len() > 0 && len(ret) < k {
+		node := heap.Pop(nodes).(topKNode)
+		if node.depth == wm.blen {
+			ret = append(ret, ValueCount{wm.decode(node.prefix), node.posRange.End - node.posRange.Beg})
+			continue
+		}
+		rsd := wm.layers[node.depth]
+		bpos, epos := node.posRange.Beg, node.posRange.End
+		nzBeg := rsd.Rank(bpos, false)
+		nzEnd := rsd.Rank(epos, false)
+		noBeg := bpos - nzBeg + rsd.ZeroNum()
+		noEnd := epos - nzEnd + rsd.ZeroNum()
+		shift := wm.blen - node.depth - 1
+		if nzEnd > nzBeg {
+			heap.Push(nodes, topKNode{Range{nzBeg, nzEnd}, node.depth + 1, node.prefix << 1, node.prefix << 1 << shift})
+		}
+		if noEnd > noBeg {
+			prefix := node.prefix<<1 | 1
+			heap.Push(nodes, topKNode{Range{noBeg, noEnd}, node.depth + 1, prefix, prefix << shift})
+		}
+	}
+	return ret
+}
+
+// topKNode is a node of the matrix whose values start with prefix.
+// lowest is the smallest code under the node.
+type topKNode struct {
+	posRange Range
+	depth    uint64
+	prefix   uint64
+	lowest   uint64
+}
+
+// topKHeap pops the widest node first, and the one of the smallest codes
+// among those of the same width, so that equal counts come in ascending
+// order of the values.
+type topKHeap []topKNode
+
+func (h topKHeap) Len() int      { return len(h) }
+func (h topKHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *topKHeap) Push(x any)   { *h = append(*h, x.(topKNode)) }
+func (h topKHeap) Less(i, j int) bool {
+	wi := h[i].posRange.End - h[i].posRange.Beg
+	wj := h[j].posRange.End - h[j].posRange.Beg
+	if wi != wj {
+		return wi > wj
+	}
+	return h[i].lowest < h[j].lowest
+}
+
+func (h *topKHeap) Pop() any {
+	old := *h
+	node := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return node
+}
@@ -3,6 +3,7 @@ package watrix
 import (
 	"fmt"
 	"math/rand"
+	"path/filepath"
 	"sort"
 	"testing"
 
@@ -50,7 +51,7 @@ func origIntersect(orig []uint64, ranges []Range, k int) []uint64 {
 	return ret
 }
 
-func buildWaveletHelper(t *testing.T, num uint64, testNum uint64, dim uint64, orig []uint64, ranks, ranksLessThan, ranksMoreThan [][]uint64) *WaveletMatrix {
+func buildWaveletHelper(t *testing.T, num uint64, testNum uint64, dim uint64, orig []uint64, ranks, ranksLessThan, ranksMoreThan [][]uint64, opts ...BuildOption) *WaveletMatrix {
 	wmb := NewBuilder()
 	for i := 0; i < len(ranks); i++ {
 		ranks[i] = make([]uint64, num)
@@ -71,7 +72,7 @@ func buildWaveletHelper(t *testing.T, num uint64, testNum uint64, dim uint64, or
 		}
 		freqs[x]++
 	}
-	return wmb.Build()
+	return wmb.Build(opts...)
 }
 
 func testWaveletHelper(t *testing.T, wm *WaveletMatrix, num uint64, testNum uint64, dim uint64, orig []uint64, ranks, ranksLessThan, ranksMoreThan [][]uint64) {
@@ -104,13 +105,15 @@ func testWaveletHelper(t *testing.T, wm *WaveletMatrix, num uint64, testNum uint
 		So(wm.Intersect(ranges, 4), ShouldResemble, origIntersect(orig, ranges, 4))
 
 		ranze := generateRange(num)
-		k := uint64(rand.Int63()) % (ranze.End - ranze.Beg)
-		vs := make([]int, ranze.End-ranze.Beg)
-		for i := uint64(0); i < uint64(len(vs)); i++ {
-			vs[i] = int(orig[i+ranze.Beg])
+		if ranze.End > ranze.Beg {
+			k := uint64(rand.Int63()) % (ranze.End - ranze.Beg)
+			vs := make([]int, ranze.End-ranze.Beg)
+			for i := uint64(0); i < uint64(len(vs)); i++ {
+				vs[i] = int(orig[i+ranze.Beg])
+			}
+			sort.Ints(vs)
+			So(wm.Quantile(ranze, k), ShouldEqual, vs[k])
 		}
-		sort.Ints(vs)
-		So(wm.Quantile(ranze, k), ShouldEqual, vs[k])
 	}
 	Convey("when op is wrong", func() {
 		So(wm.RangedRankOp(Range{0, num}, 0, OpMax), ShouldEqual, 0)
@@ -189,6 +192,161 @@ func TestWaveletMatrix(t *testing.T) {
 	})
 }
 
+func TestBackends(t *testing.T) {
+	for _, backend := range []Backend{BackendRSDic, BackendDense, BackendPlain, BackendInterleaved} {
+		Convey("When a random bit vector is built on "+backend.String(), t, func() {
+			num := uint64(14000)
+			dim := uint64(100)
+			testNum := uint64(10)
+			orig := make([]uint64, num)
+			ranks := make([][]uint64, dim)
+			ranksLessThan := make([][]uint64, dim)
+			ranksMoreThan := make([][]uint64, dim)
+
+			wm := buildWaveletHelper(t, num, testNum, dim, orig, ranks, ranksLessThan, ranksMoreThan, WithBackend(backend))
+			So(wm.Backend(), ShouldEqual, backend)
+
+			Convey("Queries should match the reference counts", func() {
+				testWaveletHelper(t, wm, num, testNum, dim, orig, ranks, ranksLessThan, ranksMoreThan)
+			})
+			Convey("The backend should survive marshaling", func() {
+				out, err := wm.MarshalBinary()
+				So(err, ShouldBeNil)
+				loaded := new(WaveletMatrix)
+				So(loaded.UnmarshalBinary(out), ShouldBeNil)
+				So(loaded.Backend(), ShouldEqual, backend)
+				testWaveletHelper(t, loaded, num, testNum, dim, orig, ranks, ranksLessThan, ranksMoreThan)
+			})
+			Convey("The backend should survive marshaling into a file", func() {
+				So(wm.MarshalBinaryFile("test.bin"), ShouldBeNil)
+				loaded := new(WaveletMatrix)
+				So(loaded.UnmarshalBinaryFile("test.bin"), ShouldBeNil)
+				So(loaded.Backend(), ShouldEqual, backend)
+				testWaveletHelper(t, loaded, num, testNum, dim, orig, ranks, ranksLessThan, ranksMoreThan)
+			})
+		})
+	}
+	Convey("Backend names should round-trip", t, func() {
+		b, err := ParseBackend("dense")
+		So(err, ShouldBeNil)
+		So(b, ShouldEqual, BackendDense)
+		_, err = ParseBackend("nope")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("An unknown backend should fall back to rsdic and round-trip", t, func() {
+		wm := buildFromSlice([]uint64{3, 1, 4, 1, 5})
+		wmb := NewBuilder()
+		for _, v := range []uint64{3, 1, 4, 1, 5} {
+			wmb.PushBack(v)
+		}
+		unknown := wmb.Build(WithBackend(Backend(99)))
+		So(unknown.Backend(), ShouldEqual, BackendRSDic)
+		out, err := unknown.MarshalBinary()
+		So(err, ShouldBeNil)
+		loaded := new(WaveletMatrix)
+		So(loaded.UnmarshalBinary(out), ShouldBeNil)
+		So(loaded.Backend(), ShouldEqual, BackendRSDic)
+		So(loaded, shouldEqualMatrix, wm)
+	})
+}
+
+func TestBatch(t *testing.T) {
+	for _, backend := range []Backend{BackendRSDic, BackendInterleaved} {
+		Convey("When queries are batched on "+backend.String(), t, func() {
+			num := uint64(3000)
+			orig := make([]uint64, num)
+			for i := range orig {
+				orig[i] = uint64(rand.Int63n(1000))
+			}
+			wmb := NewBuilder()
+			for _, val := range orig {
+				wmb.PushBack(val)
+			}
+			for _, wm := range []*WaveletMatrix{wmb.Build(WithBackend(backend)), wmb.Build(WithBackend(backend), WithCompactAlphabet())} {
+				queryNum := 2*batchChunk + 7
+				poss := make([]uint64, queryNum)
+				ranges := make([]Range, queryNum)
+				vals := make([]uint64, queryNum)
+				for i := range poss {
+					poss[i] = uint64(rand.Int63n(int64(num)))
+					ranges[i] = generateRange(num)
+					vals[i] = uint64(rand.Int63n(1100))
+				}
+				vals[0] = 1 << 40 // wider than blen
+
+				want := make([]uint64, queryNum)
+				for i, pos := range poss {
+					want[i] = wm.Lookup(pos)
+				}
+				So(wm.LookupBatch(poss, nil), ShouldResemble, want)
+				for _, op := range []int{OpEqual, OpLessThan, OpMoreThan} {
+					for i := range ranges {
+						want[i] = wm.RangedRankOp(ranges[i], vals[i], op)
+					}
+					So(wm.RangedRankOpBatch(ranges, vals, op, make([]uint64, 3)), ShouldResemble, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTopK(t *testing.T) {
+	Convey("When the most frequent values are queried", t, func() {
+		num := uint64(3000)
+		orig := make([]uint64, num)
+		for i := range orig {
+			// skewed so that the counts differ
+			orig[i] = uint64(rand.Int63n(1+rand.Int63n(200))) << 30
+		}
+		wmb := NewBuilder()
+		for _, val := range orig {
+			wmb.PushBack(val)
+		}
+		for _, wm := range []*WaveletMatrix{wmb.Build(), wmb.Build(WithCompactAlphabet())} {
+			for i := 0; i < 10; i++ {
+				ranze := generateRange(num)
+				counts := make(map[uint64]uint64)
+				for _, val := range orig[ranze.Beg:ranze.End] {
+					counts[val]++
+				}
+				want := make([]ValueCount, 0, len(counts))
+				for val, count := range counts {
+					want = append(want, ValueCount{val, count})
+				}
+				sort.Slice(want, func(i, j int) bool {
+					if want[i].Count != want[j].Count {
+						return want[i].Count > want[j].Count
+					}
+					return want[i].Value < want[j].Value
+				})
+				k := rand.Intn(len(want)+2) + 1
+				if k < len(want) {
+					want = want[:k]
+				}
+				So(wm.TopK(ranze, k), ShouldResemble, want)
+			}
+			So(wm.TopK(Range{5, 5}, 3), ShouldBeEmpty)
+			So(wm.TopK(Range{0, num}, 0), ShouldBeEmpty)
+		}
+		So(buildFromSlice([]uint64{0, 0, 0}).TopK(Range{0, 3}, 2), ShouldResemble, []ValueCount{{0, 3}})
+	})
+}
+
+func TestIgnoreLSBsWiderThanBlen(t *testing.T) {
+	Convey("When the value has bits above blen that are not ignored", t, func() {
+		wm := buildFromSlice([]uint64{8, 9, 10})
+		So(wm.RangedRankIgnoreLSBs(Range{0, 3}, 1<<20|8, 0), ShouldEqual, 0)
+		So(wm.RangedRankIgnoreLSBs(Range{0, 3}, 1<<20|8, 3), ShouldEqual, 0)
+		So(wm.RangedSelectIgnoreLSBs(Range{0, 3}, 0, 1<<20|8, 0), ShouldEqual, 3)
+		So(wm.RangedSelectIgnoreLSBs(Range{0, 3}, 0, 1<<20|8, 3), ShouldEqual, 3)
+	})
+	Convey("When the bits above blen are ignored", t, func() {
+		wm := buildFromSlice([]uint64{8, 9, 10})
+		So(wm.RangedRankIgnoreLSBs(Range{0, 3}, 1<<20|8, 21), ShouldEqual, 3)
+		So(wm.RangedSelectIgnoreLSBs(Range{0, 3}, 2, 1<<20|8, 21), ShouldEqual, 2)
+	})
+}
+
 func TestSelectExperimental(t *testing.T) {
 	src := []uint64{
 		8, 9, 10, 11, 12, 18, 8, 9, 10, 11,
@@ -205,6 +363,7 @@ func TestSelectExperimental(t *testing.T) {
 		So(wm.RangedSelect(Range{0, 10}, 1, 11), ShouldEqual, 9)
 		So(wm.RangedSelect(Range{10, 20}, 0, 13), ShouldEqual, 14)
 		So(wm.RangedSelect(Range{10, 20}, 1, 13), ShouldEqual, 20)
+		So(wm.RangedSelect(Range{0, 10}, 0, 1<<20|8), ShouldEqual, 10) // wider than blen
 	})
 	Convey("RangedRankIgnoreLSBs", t, func() {
 		So(wm.RangedRankIgnoreLSBs(Range{0, 10}, 11, 0), ShouldEqual, 2)
@@ -220,6 +379,9 @@ func TestSelectExperimental(t *testing.T) {
 		So(wm.RangedRankIgnoreLSBs(Range{10, 20}, 12, 3), ShouldEqual, 4)  // 0b1xxx 8-15
 		So(wm.RangedRankIgnoreLSBs(Range{10, 20}, 12, 4), ShouldEqual, 7)  // 0b0xxxx 0-15
 		So(wm.RangedRankIgnoreLSBs(Range{10, 20}, 12, 5), ShouldEqual, 10) // 0b0xxxxx 0-31
+
+		So(wm.RangedRankIgnoreLSBs(Range{0, 10}, 1<<20|8, 0), ShouldEqual, 0) // wider than blen
+		So(wm.RangedRankIgnoreLSBs(Range{0, 10}, 1<<20|8, 21), ShouldEqual, 10)
 	})
 	Convey("RangedSelectIgnoreLSBs", t, func() {
 		So(wm.RangedSelectIgnoreLSBs(Range{0, 10}, 0, 11, 0), ShouldEqual, 3) // 0b1011 11
@@ -230,6 +392,8 @@ func TestSelectExperimental(t *testing.T) {
 		So(wm.RangedSelectIgnoreLSBs(Range{0, 10}, 0, 11, 5), ShouldEqual, 0) // 0b0xxxxx 0-31
 
 		So(wm.RangedSelectIgnoreLSBs(Range{0, 10}, 0, 20, 0), ShouldEqual, 10)
+		So(wm.RangedSelectIgnoreLSBs(Range{0, 10}, 0, 1<<20|8, 0), ShouldEqual, 10) // wider than blen
+		So(wm.RangedSelectIgnoreLSBs(Range{0, 10}, 0, 1<<20|8, 21), ShouldEqual, 0)
 
 		So(wm.RangedSelectIgnoreLSBs(Range{0, 10}, 1, 11, 0), ShouldEqual, 9) // 0b1011 11
 		So(wm.RangedSelectIgnoreLSBs(Range{0, 10}, 1, 11, 1), ShouldEqual, 3) // 0b101x 10-11
@@ -252,6 +416,240 @@ func TestSelectExperimental(t *testing.T) {
 	})
 }
 
+func buildFromSlice(vals []uint64) *WaveletMatrix {
+	wmb := NewBuilder()
+	for _, v := range vals {
+		wmb.PushBack(v)
+	}
+	return wmb.Build()
+}
+
+func shouldEqualMatrix(actual interface{}, expected ...interface{}) string {
+	a := actual.(*WaveletMatrix)
+	e := expected[0].(*WaveletMatrix)
+	if msg := ShouldEqual(a.Num(), e.Num()); msg != "" {
+		return msg
+	}
+	if msg := ShouldEqual(a.Dim(), e.Dim()); msg != "" {
+		return msg
+	}
+	for i := uint64(0); i < e.Num(); i++ {
+		if msg := ShouldEqual(a.Lookup(i), e.Lookup(i)); msg != "" {
+			return msg
+		}
+	}
+	for depth := range e.layers {
+		for i := uint64(0); i < e.Num(); i++ {
+			if msg := ShouldEqual(a.layers[depth].Bit(i), e.layers[depth].Bit(i)); msg != "" {
+				return msg
+			}
+		}
+	}
+	return ""
+}
+
+func TestConcat(t *testing.T) {
+	Convey("When matrices are concatenated", t, func() {
+		a := []uint64{8, 9, 10, 11, 12, 18, 8}
+		b := []uint64{1, 3, 0, 2, 3}
+		c := []uint64{100, 4, 77}
+		Convey("The result should equal the matrix built over the concatenation", func() {
+			ab := append(append([]uint64{}, a...), b...)
+			So(Concat(buildFromSlice(a), buildFromSlice(b)), shouldEqualMatrix, buildFromSlice(ab))
+			ba := append(append([]uint64{}, b...), a...)
+			So(Concat(buildFromSlice(b), buildFromSlice(a)), shouldEqualMatrix, buildFromSlice(ba))
+			abc := append(append([]uint64{}, ab...), c...)
+			So(ConcatN(buildFromSlice(a), buildFromSlice(b), buildFromSlice(c)), shouldEqualMatrix, buildFromSlice(abc))
+		})
+		Convey("Empty inputs should be allowed", func() {
+			So(Concat(NewBuilder().Build(), buildFromSlice(a)), shouldEqualMatrix, buildFromSlice(a))
+			So(ConcatN().Num(), ShouldEqual, 0)
+		})
+	})
+	Convey("When long matrices are concatenated across word boundaries", t, func() {
+		var all []uint64
+		parts := make([][]uint64, 0, 4)
+		for _, n := range []int{1000, 77, 0, 650} {
+			part := make([]uint64, n)
+			for i := range part {
+				part[i] = uint64(rand.Int31n(int32(50 + 10*n)))
+			}
+			parts = append(parts, part)
+			all = append(all, part...)
+		}
+		for _, backend := range []Backend{BackendRSDic, BackendDense, BackendPlain, BackendInterleaved} {
+			wms := make([]*WaveletMatrix, len(parts))
+			for i, part := range parts {
+				wmb := NewBuilder()
+				for _, v := range part {
+					wmb.PushBack(v)
+				}
+				wms[i] = wmb.Build(WithBackend(backend))
+			}
+			joined := ConcatN(wms...)
+			So(joined.Backend(), ShouldEqual, backend)
+			So(joined, shouldEqualMatrix, buildFromSlice(all))
+			So(joined.ValidateDeep(), ShouldBeNil)
+		}
+	})
+}
+
+func TestSliceAndExtract(t *testing.T) {
+	Convey("When a random matrix is sliced", t, func() {
+		num := uint64(3000)
+		orig := make([]uint64, num)
+		for i := range orig {
+			orig[i] = uint64(rand.Int31n(1000))
+			if i%100 == 0 {
+				orig[i] = uint64(rand.Int63())
+			}
+		}
+		wm := buildFromSlice(orig)
+		for i := 0; i < 10; i++ {
+			ranze := generateRange(num)
+			So(wm.Slice(ranze), shouldEqualMatrix, buildFromSlice(orig[ranze.Beg:ranze.End]))
+			So(wm.Extract(ranze, nil), ShouldResemble, orig[ranze.Beg:ranze.End])
+		}
+		Convey("Extract should reuse dst when it is large enough", func() {
+			dst := make([]uint64, 10)
+			out := wm.Extract(Range{5, 10}, dst)
+			So(out, ShouldResemble, orig[5:10])
+			So(&out[0], ShouldEqual, &dst[0])
+		})
+		Convey("An empty range should give an empty matrix", func() {
+			So(wm.Slice(Range{7, 7}).Num(), ShouldEqual, 0)
+			So(wm.Extract(Range{7, 7}, nil), ShouldBeEmpty)
+		})
+		Convey("Slices across word boundaries should keep the backend", func() {
+			for backend := range backends {
+				wmb := NewBuilder()
+				for _, val := range orig {
+					wmb.PushBack(val)
+				}
+				bwm := wmb.Build(WithBackend(backend))
+				for _, ranze := range []Range{{0, 64}, {63, 130}, {1, 2999}, {200, 1000}} {
+					sliced := bwm.Slice(ranze)
+					So(sliced.Backend(), ShouldEqual, backend)
+					So(sliced, shouldEqualMatrix, buildFromSlice(orig[ranze.Beg:ranze.End]))
+					So(sliced.ValidateDeep(), ShouldBeNil)
+				}
+			}
+		})
+	})
+	Convey("When a compacted matrix is sliced", t, func() {
+		orig := []uint64{1 << 40, 7, 1 << 50, 7, 3 << 40, 1 << 60}
+		wmb := NewBuilder()
+		for _, val := range orig {
+			wmb.PushBack(val)
+		}
+		sliced := wmb.Build(WithCompactAlphabet()).Slice(Range{1, 5})
+		rebuilt := NewBuilder()
+		for _, val := range orig[1:5] {
+			rebuilt.PushBack(val)
+		}
+		want := rebuilt.Build(WithCompactAlphabet())
+		So(sliced.Compacted(), ShouldBeTrue)
+		So(sliced.alphabet, ShouldResemble, want.alphabet)
+		So(sliced, shouldEqualMatrix, want)
+	})
+}
+
+func TestCompactAlphabet(t *testing.T) {
+	Convey("When sparse values are built with a compact alphabet", t, func() {
+		num := uint64(4000)
+		ids := make([]uint64, 40)
+		for i := range ids {
+			ids[i] = uint64(rand.Int63()) << 1
+		}
+		orig := make([]uint64, num)
+		plainBuilder := NewBuilder()
+		compactBuilder := NewBuilder()
+		for i := range orig {
+			orig[i] = ids[rand.Intn(len(ids))]
+			plainBuilder.PushBack(orig[i])
+			compactBuilder.PushBack(orig[i])
+		}
+		plain := plainBuilder.Build()
+		wm := compactBuilder.Build(WithCompactAlphabet())
+
+		So(wm.Compacted(), ShouldBeTrue)
+		So(len(wm.layers), ShouldBeLessThanOrEqualTo, 6)
+		So(wm.Dim(), ShouldEqual, plain.Dim())
+
+		check := func(wm *WaveletMatrix) {
+			for i := 0; i < 50; i++ {
+				ranze := generateRange(num)
+				x := ids[rand.Intn(len(ids))]
+				absent := x + 1
+				So(wm.Lookup(ranze.Beg), ShouldEqual, orig[ranze.Beg])
+				for _, val := range []uint64{x, absent, 0, ^uint64(0)} {
+					So(wm.Rank(ranze.End, val), ShouldEqual, plain.Rank(ranze.End, val))
+					So(wm.RankLessThan(ranze.End, val), ShouldEqual, plain.RankLessThan(ranze.End, val))
+					So(wm.RankMoreThan(ranze.End, val), ShouldEqual, plain.RankMoreThan(ranze.End, val))
+					So(wm.Select(1, val), ShouldEqual, plain.Select(1, val))
+					So(wm.RangedSelect(ranze, 0, val), ShouldEqual, plain.RangedSelect(ranze, 0, val))
+				}
+				So(wm.RangedRankRange(ranze, Range{x, absent + 1<<40}), ShouldEqual, plain.RangedRankRange(ranze, Range{x, absent + 1<<40}))
+				So(wm.RangedRankIgnoreLSBs(ranze, x, 60), ShouldEqual, plain.RangedRankIgnoreLSBs(ranze, x, 60))
+				So(wm.RangedSelectIgnoreLSBs(ranze, 2, x, 60), ShouldEqual, plain.RangedSelectIgnoreLSBs(ranze, 2, x, 60))
+				if ranze.End > ranze.Beg {
+					k := uint64(rand.Int63()) % (ranze.End - ranze.Beg)
+					So(wm.Quantile(ranze, k), ShouldEqual, plain.Quantile(ranze, k))
+				}
+				c, rank := wm.LookupAndRank(ranze.Beg)
+				So(c, ShouldEqual, orig[ranze.Beg])
+				So(wm.Select(rank, c), ShouldEqual, ranze.Beg)
+				ranges := []Range{generateRange(num), generateRange(num)}
+				So(wm.Intersect(ranges, 2), ShouldResemble, origIntersect(orig, ranges, 2))
+				So(wm.Extract(ranze, nil), ShouldResemble, orig[ranze.Beg:ranze.End])
+			}
+		}
+		Convey("Queries should accept and return the original values", func() {
+			check(wm)
+		})
+		Convey("The dictionary should survive marshaling", func() {
+			out, err := wm.MarshalBinary()
+			So(err, ShouldBeNil)
+			loaded := new(WaveletMatrix)
+			So(loaded.UnmarshalBinary(out), ShouldBeNil)
+			So(loaded.Compacted(), ShouldBeTrue)
+			check(loaded)
+
+			So(wm.MarshalBinaryFile("test.bin"), ShouldBeNil)
+			loaded = new(WaveletMatrix)
+			So(loaded.UnmarshalBinaryFile("test.bin"), ShouldBeNil)
+			So(loaded.Compacted(), ShouldBeTrue)
+			check(loaded)
+		})
+		Convey("An empty compacted matrix should stay compacted", func() {
+			empty := NewBuilder().Build(WithCompactAlphabet())
+			So(empty.Compacted(), ShouldBeTrue)
+			So(newBuildConfig([]BuildOption{WithCompactAlphabet()}).matches(empty), ShouldBeTrue)
+			out, err := empty.MarshalBinary()
+			So(err, ShouldBeNil)
+			loaded := new(WaveletMatrix)
+			So(loaded.UnmarshalBinary(out), ShouldBeNil)
+			So(loaded.Compacted(), ShouldBeTrue)
+			So(loaded.Num(), ShouldEqual, 0)
+
+			path := filepath.Join(t.TempDir(), "mapped.bin")
+			So(empty.MarshalMappedFile(path), ShouldBeNil)
+			mp, err := OpenMapped(path)
+			So(err, ShouldBeNil)
+			So(mp.Compacted(), ShouldBeTrue)
+			So(mp.Close(), ShouldBeNil)
+		})
+		Convey("Slice and Concat should keep the original values", func() {
+			sliced := wm.Slice(Range{100, 200})
+			So(sliced.Extract(Range{0, 100}, nil), ShouldResemble, orig[100:200])
+			joined := Concat(wm, plain)
+			So(joined.Num(), ShouldEqual, 2*num)
+			So(joined.Lookup(num+5), ShouldEqual, orig[5])
+			So(joined.Rank(2*num, orig[0]), ShouldEqual, 2*plain.Rank(num, orig[0]))
+		})
+	})
+}
+
 // -----------------------------------------------------------------------------
 // Benchmarks
 //
@@ -304,6 +702,15 @@ func BenchmarkWM_Lookup(b *testing.B) {
 	}
 }
 
+func BenchmarkWM_Extract1K(b *testing.B) {
+	dst := make([]uint64, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		beg := uint64(rand.Int63() % (N - 1000))
+		bf.wt.Extract(Range{beg, beg + 1000}, dst)
+	}
+}
+
 func BenchmarkWM_Rank(b *testing.B) {
 	dim := bf.wt.Dim()
 	b.ResetTimer()
@@ -366,6 +773,204 @@ func BenchmarkWM_Quantile(b *testing.B) {
 	}
 }
 
+var benchBackendMatrices = make(map[Backend]*WaveletMatrix)
+
+func benchBackendMatrix(backend Backend) *WaveletMatrix {
+	if backend == BackendRSDic {
+		return bf.wt
+	}
+	if wm, ok := benchBackendMatrices[backend]; ok {
+		return wm
+	}
+	wm := bf.builder.Build(WithBackend(backend))
+	benchBackendMatrices[backend] = wm
+	return wm
+}
+
+func benchBackendLookup(b *testing.B, backend Backend) {
+	wm := benchBackendMatrix(backend)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ind := uint64(rand.Int63() % N)
+		wm.Lookup(ind)
+	}
+}
+
+func benchBackendRank(b *testing.B, backend Backend) {
+	wm := benchBackendMatrix(backend)
+	dim := wm.Dim()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ind := uint64(rand.Int63() % N)
+		x := uint64(rand.Int63()) % dim
+		wm.Rank(ind, x)
+	}
+}
+
+func benchBackendSelect(b *testing.B, backend Backend) {
+	wm := benchBackendMatrix(backend)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x := bf.vals[uint64(rand.Int63())%uint64(len(bf.vals))]
+		rank := uint64(rand.Int63()) % bf.counter[x]
+		wm.Select(rank, x)
+	}
+}
+
+func benchBackendQuantile(b *testing.B, backend Backend) {
+	wm := benchBackendMatrix(backend)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ranze := generateRange(N)
+		if ranze.End-ranze.Beg == 0 {
+			continue
+		}
+		k := uint64(rand.Int()) % (ranze.End - ranze.Beg)
+		wm.Quantile(ranze, k)
+	}
+}
+
+func BenchmarkWMDense_Lookup(b *testing.B)   { benchBackendLookup(b, BackendDense) }
+func BenchmarkWMDense_Rank(b *testing.B)     { benchBackendRank(b, BackendDense) }
+func BenchmarkWMDense_Select(b *testing.B)   { benchBackendSelect(b, BackendDense) }
+func BenchmarkWMDense_Quantile(b *testing.B) { benchBackendQuantile(b, BackendDense) }
+func BenchmarkWMPlain_Lookup(b *testing.B)   { benchBackendLookup(b, BackendPlain) }
+func BenchmarkWMPlain_Rank(b *testing.B)     { benchBackendRank(b, BackendPlain) }
+func BenchmarkWMPlain_Select(b *testing.B)   { benchBackendSelect(b, BackendPlain) }
+func BenchmarkWMPlain_Quantile(b *testing.B) { benchBackendQuantile(b, BackendPlain) }
+
+// The batched benchmarks answer batchChunk queries per iteration and
+// report the time per query.
+func benchBackendLookupBatch(b *testing.B, backend Backend) {
+	wm := benchBackendMatrix(backend)
+	poss := make([]uint64, batchChunk)
+	dst := make([]uint64, batchChunk)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchChunk {
+		for j := range poss {
+			poss[j] = uint64(rand.Int63() % N)
+		}
+		wm.LookupBatch(poss, dst)
+	}
+}
+
+func benchBackendRangedRankOp(b *testing.B, backend Backend) {
+	wm := benchBackendMatrix(backend)
+	dim := wm.Dim()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ranze := generateRange(N)
+		x := uint64(rand.Int63()) % dim
+		wm.RangedRankOp(ranze, x, OpLessThan)
+	}
+}
+
+func benchBackendRangedRankOpBatch(b *testing.B, backend Backend) {
+	wm := benchBackendMatrix(backend)
+	dim := wm.Dim()
+	ranges := make([]Range, batchChunk)
+	vals := make([]uint64, batchChunk)
+	dst := make([]uint64, batchChunk)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchChunk {
+		for j := range ranges {
+			ranges[j] = generateRange(N)
+			vals[j] = uint64(rand.Int63()) % dim
+		}
+		wm.RangedRankOpBatch(ranges, vals, OpLessThan, dst)
+	}
+}
+
+func BenchmarkWM_LookupBatch(b *testing.B)            { benchBackendLookupBatch(b, BackendRSDic) }
+func BenchmarkWM_RangedRankOp(b *testing.B)           { benchBackendRangedRankOp(b, BackendRSDic) }
+func BenchmarkWM_RangedRankOpBatch(b *testing.B)      { benchBackendRangedRankOpBatch(b, BackendRSDic) }
+func BenchmarkWMPlain_LookupBatch(b *testing.B)       { benchBackendLookupBatch(b, BackendPlain) }
+func BenchmarkWMPlain_RangedRankOp(b *testing.B)      { benchBackendRangedRankOp(b, BackendPlain) }
+func BenchmarkWMPlain_RangedRankOpBatch(b *testing.B) { benchBackendRangedRankOpBatch(b, BackendPlain) }
+func BenchmarkWMInter_Lookup(b *testing.B)            { benchBackendLookup(b, BackendInterleaved) }
+func BenchmarkWMInter_LookupBatch(b *testing.B)       { benchBackendLookupBatch(b, BackendInterleaved) }
+func BenchmarkWMInter_Rank(b *testing.B)              { benchBackendRank(b, BackendInterleaved) }
+func BenchmarkWMInter_RangedRankOp(b *testing.B)      { benchBackendRangedRankOp(b, BackendInterleaved) }
+func BenchmarkWMInter_RangedRankOpBatch(b *testing.B) {
+	benchBackendRangedRankOpBatch(b, BackendInterleaved)
+}
+
+var benchMultiMatrices = make(map[uint64]*MultiMatrix)
+
+func benchMultiMatrix(bitsPerLayer uint64) *MultiMatrix {
+	if mm, ok := benchMultiMatrices[bitsPerLayer]; ok {
+		return mm
+	}
+	mm := bf.builder.BuildMultiary(bitsPerLayer)
+	benchMultiMatrices[bitsPerLayer] = mm
+	return mm
+}
+
+func BenchmarkMM4_Lookup(b *testing.B) {
+	mm := benchMultiMatrix(2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ind := uint64(rand.Int63() % N)
+		mm.Lookup(ind)
+	}
+}
+
+func BenchmarkMM4_Rank(b *testing.B) {
+	mm := benchMultiMatrix(2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ind := uint64(rand.Int63() % N)
+		x := uint64(rand.Int63()) % mm.Dim()
+		mm.Rank(ind, x)
+	}
+}
+
+func BenchmarkMM16_Lookup(b *testing.B) {
+	mm := benchMultiMatrix(4)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ind := uint64(rand.Int63() % N)
+		mm.Lookup(ind)
+	}
+}
+
+func BenchmarkMM16_Rank(b *testing.B) {
+	mm := benchMultiMatrix(4)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ind := uint64(rand.Int63() % N)
+		x := uint64(rand.Int63()) % mm.Dim()
+		mm.Rank(ind, x)
+	}
+}
+
+func BenchmarkMM16_Quantile(b *testing.B) {
+	mm := benchMultiMatrix(4)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ranze := generateRange(N)
+		if ranze.End-ranze.Beg == 0 {
+			continue
+		}
+		k := uint64(rand.Int()) % (ranze.End - ranze.Beg)
+		mm.Quantile(ranze, k)
+	}
+}
+
+func BenchmarkHWM_Lookup(b *testing.B) {
+	wmb := NewBuilder()
+	for i := 0; i < N; i++ {
+		x := uint64(rand.ExpFloat64() * 16) // skewed
+		wmb.PushBack(x)
+	}
+	hm := wmb.BuildHuffman()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ind := uint64(rand.Int63() % N)
+		hm.Lookup(ind)
+	}
+}
+
 func BenchmarkRaw_Lookup(b *testing.B) {
 	b.ResetTimer()
 	dummy := uint64(0)